@@ -1,13 +1,18 @@
 package commands
 
 import (
+	"bytes"
 	"context"
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"os"
+	"os/signal"
 	"path"
 	"path/filepath"
+	"sync"
+	"syscall"
 
 	"github.com/cbednarski/mkdeb/deb"
 	"github.com/facebookgo/flagenv"
@@ -16,58 +21,182 @@ import (
 
 // BuildCmd .
 type BuildCmd struct {
-	version string
-	target  string
-	config  string // alternative to positional argument
+	version          string
+	target           string
+	filenameTemplate string
+	config           string // alternative to positional argument
+	printScripts     bool
+	noCache          bool
+	cacheDir         string
+}
+
+// buildOptions are the settings used to build each config file
+type buildOptions struct {
+	version          string
+	target           string
+	filenameTemplate string
+	printScripts     bool
+	cacheDir         string // empty disables the cache
+	onProgress       func(deb.Progress)
 }
 
 func (*BuildCmd) Name() string     { return "build" }
 func (*BuildCmd) Synopsis() string { return "build a package based on the specified config file" }
 func (*BuildCmd) Usage() string {
-	return `build -version=1.2.0 [-config] config.json
+	return `build -version=1.2.0 [-config] config.json [config.json...]
 By default the build artifact
 
-The build command will change to the directory where the config file is
-located, so paths should always be specified relative to the config file.
+Several config files may be given to build their packages at the same time.
+
+Paths in the config file are relative to the directory where the config file
+is located, and files in the package must be inside that directory. Sources in
+the files map can't be absolute paths or start with ../, so copy or link files
+from elsewhere into the directory before building.
+
+The version is required. Use -version=git to compute it from the nearest git
+tag, like git describe: v1.2.3 becomes 1.2.3, four commits later it becomes
+1.2.3+4.gabcdef0, and pre-release tags like v1.3.0-rc1 become 1.3.0~rc1 so
+they sort before the release. Use -version=file:VERSION to read it from a file
+relative to the config file.
+
+Packages are named package_version_arch.deb. Use -filename-template or the
+filenameTemplate config option to change this, e.g. to build into a pool:
+
+  -filename-template='pool/main/{{pool .Package}}/{{.Package}}/{{.Package}}_{{.Version}}_{{.Architecture}}.deb'
+
+If the config file lists several architectures one package is built for each
+of them, in parallel.
+
+When run in a terminal a progress bar is shown while files are hashed and
+compressed. Interrupting the build removes any partially written packages.
+
+Maintainer scripts are checked for syntax errors and bash extensions before
+the package is built. Warnings are printed for scripts that do not use set -e
+or do not check the action they are called with.
+
+Use -print-scripts to show the maintainer scripts, debconf files, and triggers
+that would be included in the package, after snippets and generated code are
+assembled, instead of building.
+
+Built packages are cached, and reused when the config and the files in the
+package have not changed. Cached packages are only reused by the same version
+of mkdeb. Use -no-cache to always build the package, and "mkdeb cache prune"
+to remove old packages from the cache.
 
 `
 }
 
 func (b *BuildCmd) SetFlags(f *flag.FlagSet) {
-	f.StringVar(&b.version, "version", "1.0", "Package version")
+	f.StringVar(&b.version, "version", "", "Package version, \"git\" to use git describe, or \"file:PATH\" to read it from a file")
 	f.StringVar(&b.target, "target", "", "Target folder with generated filename")
+	f.StringVar(&b.filenameTemplate, "filename-template", "", "Template for the package path in the target folder")
 	f.StringVar(&b.config, "config", "", "Config file (alternative to positional argument)")
+	f.BoolVar(&b.printScripts, "print-scripts", false, "Print maintainer scripts instead of building")
+	f.BoolVar(&b.noCache, "no-cache", false, "Build packages even if they are cached")
+	f.StringVar(&b.cacheDir, "cache-dir", "", "Directory to cache packages in (default is mkdeb in the user cache directory)")
 }
 
-func (b *BuildCmd) Execute(_ context.Context, f *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
+func (b *BuildCmd) Execute(ctx context.Context, f *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
 	if err := flagenv.ParseSet(flagenv.Prefix, f); err != nil {
 		log.Fatal(err)
 	}
 
-	var config string
-	if f.NArg() > 0 {
-		config = f.Arg(0)
+	configs, err := configArgs(f, b.config)
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		return subcommands.ExitFailure
 	}
-	if b.config != "" {
-		if config != "" {
-			fmt.Println("error: only use one of positional or -config argument for config file")
+
+	// Stop the build and remove partial packages on ^C
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	opts := buildOptions{
+		version:          b.version,
+		target:           b.target,
+		filenameTemplate: b.filenameTemplate,
+		printScripts:     b.printScripts,
+	}
+	if !b.noCache {
+		if opts.cacheDir, err = cacheDir(b.cacheDir); err != nil {
+			fmt.Printf("Error: %s\n", err)
 			return subcommands.ExitFailure
 		}
-		config = b.config
 	}
-	if config == "" {
-		fmt.Println("Error: config file not specified")
-		return subcommands.ExitFailure
+	bar := newProgressBar(os.Stderr)
+	if bar != nil && !b.printScripts {
+		opts.onProgress = bar.update
 	}
 
-	if err := build(config, b.version, b.target); err != nil {
-		fmt.Printf("Error: %s\n", err)
+	results := runConfigs(configs, func(config string, out io.Writer) error {
+		return build(ctx, config, out, opts)
+	})
+	if bar != nil {
+		bar.finish()
+	}
+	if !printResults(configs, results) {
 		return subcommands.ExitFailure
 	}
-
 	return subcommands.ExitSuccess
 }
 
+// configArgs returns the config files from the positional arguments or the
+// -config flag
+func configArgs(f *flag.FlagSet, config string) ([]string, error) {
+	configs := f.Args()
+	if config != "" {
+		if len(configs) > 0 {
+			return nil, fmt.Errorf("only use one of positional or -config argument for config file")
+		}
+		configs = []string{config}
+	}
+	if len(configs) == 0 {
+		return nil, fmt.Errorf("config file not specified")
+	}
+	return configs, nil
+}
+
+// configResult is the output of a command for one config file
+type configResult struct {
+	output bytes.Buffer
+	err    error
+}
+
+// runConfigs calls fn for each config file at the same time. Output is
+// buffered so it can be printed in order instead of interleaved.
+func runConfigs(configs []string, fn func(config string, out io.Writer) error) []configResult {
+	results := make([]configResult, len(configs))
+	var wg sync.WaitGroup
+	for i, config := range configs {
+		wg.Add(1)
+		go func(i int, config string) {
+			defer wg.Done()
+			results[i].err = fn(config, &results[i].output)
+		}(i, config)
+	}
+	wg.Wait()
+	return results
+}
+
+// printResults prints the output of each config file in order. It returns
+// false if any of them failed.
+func printResults(configs []string, results []configResult) bool {
+	ok := true
+	for i, config := range configs {
+		os.Stdout.Write(results[i].output.Bytes())
+		if results[i].err == nil {
+			continue
+		}
+		ok = false
+		if len(configs) > 1 {
+			fmt.Printf("Error: %s: %s\n", config, results[i].err)
+		} else {
+			fmt.Printf("Error: %s\n", results[i].err)
+		}
+	}
+	return ok
+}
+
 // getAbsPaths takes a relative path to a file and returns both the containing
 // directory and the absolute path to the file.
 //
@@ -82,31 +211,39 @@ func getAbsPaths(filename string) (string, string) {
 	return dir, path
 }
 
-func build(config, version, target string) error {
-	// Change to config path
-	back, err := os.Getwd()
+func build(ctx context.Context, config string, out io.Writer, opts buildOptions) error {
+	// Get the directory containing the config file and the absolute path to it
+	workdir, abspath := getAbsPaths(config)
+
+	p, err := deb.NewPackageSpecFromFile(abspath)
 	if err != nil {
 		return err
 	}
 
-	// Get the working directory to cd into and the absolute path to the file
-	workdir, abspath := getAbsPaths(config)
-	if err := os.Chdir(workdir); err != nil {
+	// Package files are read relative to the config file. Other paths are on
+	// the host, so resolve them against the config directory as well.
+	p.Source = os.DirFS(workdir)
+	p.BaseDir = workdir
+	p.OnProgress = opts.onProgress
+	p.CacheDir = opts.cacheDir
+
+	// Set version
+	if p.Version, err = deb.ResolveVersion(opts.version, workdir); err != nil {
 		return err
 	}
-	defer os.Chdir(back)
 
-	p, err := deb.NewPackageSpecFromFile(abspath)
-	if err != nil {
-		return err
+	if opts.filenameTemplate != "" {
+		p.FilenameTemplate = opts.filenameTemplate
 	}
-	// Set version
-	p.Version = version
 
 	// Set target filename
+	target := opts.target
 	if target == "" {
 		target = workdir
 	} else {
+		if !filepath.IsAbs(target) {
+			target = filepath.Join(workdir, target)
+		}
 		info, err := os.Stat(target)
 		if !(err == nil && info.IsDir()) {
 			return fmt.Errorf("%q is not a directory", target)
@@ -114,16 +251,84 @@ func build(config, version, target string) error {
 		}
 	}
 
-	// Validate
-	if err := p.Validate(true); err != nil {
+	specs, err := p.ArchitectureSpecs()
+	if err != nil {
 		return err
 	}
 
-	// Build
-	if err := p.Build(target); err != nil {
+	// Validate everything up front so we don't leave partial builds behind
+	for _, spec := range specs {
+		if err := spec.ResolveArchitecture(); err != nil {
+			return err
+		}
+		if err := spec.Validate(true); err != nil {
+			return err
+		}
+	}
+
+	// Scripts are the same for every architecture, so only warn once
+	diagnostics, err := specs[0].LintControlScripts()
+	if err != nil {
 		return err
 	}
+	for _, d := range diagnostics {
+		fmt.Fprintf(out, "%s\n", d)
+	}
 
-	fmt.Printf("Built package %s\n", path.Join(target, p.Filename()))
+	if opts.printScripts {
+		for _, spec := range specs {
+			if err := printControlScripts(out, spec, len(specs) > 1); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	// Build
+	errs := make([]error, len(specs))
+	var wg sync.WaitGroup
+	for i, spec := range specs {
+		wg.Add(1)
+		go func(i int, spec *deb.PackageSpec) {
+			defer wg.Done()
+			errs[i] = spec.BuildContext(ctx, target)
+		}(i, spec)
+	}
+	wg.Wait()
+
+	if ctx.Err() != nil {
+		return fmt.Errorf("Build was cancelled")
+	}
+	for i, spec := range specs {
+		if errs[i] != nil {
+			return fmt.Errorf("%s: %s", spec.Architecture, errs[i])
+		}
+		action := "Built"
+		if spec.CacheHit() {
+			action = "Using cached"
+		}
+		fmt.Fprintf(out, "%s package %s\n", action, path.Join(target, spec.Filename()))
+		if dbg := spec.DebugFilename(); dbg != "" {
+			fmt.Fprintf(out, "%s package %s\n", action, path.Join(target, dbg))
+		}
+	}
+	return nil
+}
+
+// printControlScripts prints the rendered maintainer scripts for a spec, along
+// with the triggers and debconf files that are added to the package with them
+func printControlScripts(out io.Writer, p *deb.PackageSpec, showArch bool) error {
+	files, err := p.RenderControlFiles()
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if showArch {
+			fmt.Fprintf(out, "==> %s (%s) <==\n", file.Name, p.Architecture)
+		} else {
+			fmt.Fprintf(out, "==> %s <==\n", file.Name)
+		}
+		fmt.Fprintf(out, "%s\n", file.Data)
+	}
 	return nil
 }
@@ -0,0 +1,74 @@
+package commands
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"path/filepath"
+	"time"
+
+	"github.com/cbednarski/mkdeb/deb"
+	"github.com/facebookgo/flagenv"
+	"github.com/google/subcommands"
+)
+
+type CacheCmd struct {
+	cacheDir  string
+	olderThan time.Duration
+}
+
+func (*CacheCmd) Name() string     { return "cache" }
+func (*CacheCmd) Synopsis() string { return "manage the cache of built packages" }
+func (*CacheCmd) Usage() string {
+	return `cache prune [-older-than=720h] [-cache-dir=DIR]
+
+mkdeb build caches the packages it builds, and reuses them when the config and
+the files in the package have not changed. Prune removes the packages that have
+not been used for -older-than, or all of them with -older-than=0.
+
+`
+}
+
+func (c *CacheCmd) SetFlags(f *flag.FlagSet) {
+	f.StringVar(&c.cacheDir, "cache-dir", "", "Directory packages are cached in (default is mkdeb in the user cache directory)")
+	f.DurationVar(&c.olderThan, "older-than", 30*24*time.Hour, "Remove packages that have not been used for this long")
+}
+
+func (c *CacheCmd) Execute(_ context.Context, f *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
+	if err := flagenv.ParseSet(flagenv.Prefix, f); err != nil {
+		log.Fatal(err)
+	}
+
+	if f.NArg() == 0 || f.Arg(0) != "prune" {
+		fmt.Println("Error: expected a cache command: prune")
+		return subcommands.ExitUsageError
+	}
+	// Flags may also follow the command, e.g. cache prune -older-than=0
+	if err := f.Parse(f.Args()[1:]); err != nil || f.NArg() > 0 {
+		fmt.Println("Error: unexpected arguments after prune")
+		return subcommands.ExitUsageError
+	}
+
+	dir, err := cacheDir(c.cacheDir)
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		return subcommands.ExitFailure
+	}
+	removed, freed, err := deb.PruneCache(dir, c.olderThan)
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		return subcommands.ExitFailure
+	}
+	fmt.Printf("Removed %d packages (%s) from %s\n", removed, formatBytes(freed), dir)
+	return subcommands.ExitSuccess
+}
+
+// cacheDir returns the absolute path of the cache directory, which defaults to
+// deb.DefaultCacheDir
+func cacheDir(dir string) (string, error) {
+	if dir == "" {
+		return deb.DefaultCacheDir()
+	}
+	return filepath.Abs(dir)
+}
@@ -0,0 +1,121 @@
+package commands
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/cbednarski/mkdeb/deb"
+	"github.com/facebookgo/flagenv"
+	"github.com/google/subcommands"
+)
+
+// ChangelogCmd .
+type ChangelogCmd struct {
+	version string
+	from    string
+	to      string
+	output  string
+	config  string // alternative to positional argument
+}
+
+func (*ChangelogCmd) Name() string { return "changelog" }
+func (*ChangelogCmd) Synopsis() string {
+	return "generate a debian changelog entry from the git history"
+}
+func (*ChangelogCmd) Usage() string {
+	return `changelog -version=1.2.0 -from=v1.1.0 [-to=HEAD] [-output=changelog] [-config] config.json
+Generate a changelog entry for the new version from the subjects of the commits
+between two revisions of the git repository containing the config file, like
+git log v1.1.0..HEAD. The entry uses the package name and maintainer from the
+config file, and is dated with the time of the newest commit.
+
+The -version flag accepts the same values as for build.
+
+The entry is printed, or added to the top of the file given by -output. Point
+the changelog file option at that file to include it in the package.
+
+Alternatively, set "git": {"from": "v1.1.0"} in the changelog options to
+generate the entry every time the package is built.
+
+`
+}
+
+func (c *ChangelogCmd) SetFlags(f *flag.FlagSet) {
+	f.StringVar(&c.version, "version", "", "Package version, \"git\" to use git describe, or \"file:PATH\" to read it from a file")
+	f.StringVar(&c.from, "from", "", "Revision of the previous release, e.g. a tag. Defaults to all history.")
+	f.StringVar(&c.to, "to", "HEAD", "Revision of this release")
+	f.StringVar(&c.output, "output", "", "Changelog file to add the entry to")
+	f.StringVar(&c.config, "config", "", "Config file (alternative to positional argument)")
+}
+
+func (c *ChangelogCmd) Execute(_ context.Context, f *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
+	if err := flagenv.ParseSet(flagenv.Prefix, f); err != nil {
+		log.Fatal(err)
+	}
+
+	configs, err := configArgs(f, c.config)
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		return subcommands.ExitFailure
+	}
+	if len(configs) > 1 {
+		fmt.Println("Error: only one config file can be given")
+		return subcommands.ExitFailure
+	}
+
+	if err := changelog(configs[0], c.version, c.from, c.to, c.output); err != nil {
+		fmt.Printf("Error: %s\n", err)
+		return subcommands.ExitFailure
+	}
+	return subcommands.ExitSuccess
+}
+
+func changelog(config, version, from, to, output string) error {
+	workdir, abspath := getAbsPaths(config)
+	p, err := deb.NewPackageSpecFromFile(abspath)
+	if err != nil {
+		return err
+	}
+	if p.Version, err = deb.ResolveVersion(version, workdir); err != nil {
+		return err
+	}
+	if p.Version == "" {
+		return fmt.Errorf("Version not specified")
+	}
+
+	entry, err := p.GitChangelogEntry(workdir, from, to)
+	if err != nil {
+		return err
+	}
+	p.Changelog = &deb.Changelog{Entries: []deb.ChangelogEntry{entry}}
+	data, err := p.RenderChangelog()
+	if err != nil {
+		return err
+	}
+
+	if output == "" {
+		fmt.Print(string(data))
+		return nil
+	}
+
+	if !filepath.IsAbs(output) {
+		output = filepath.Join(workdir, output)
+	}
+	existing, err := ioutil.ReadFile(output)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if len(existing) > 0 {
+		data = append(append(data, '\n'), existing...)
+	}
+	if err := ioutil.WriteFile(output, data, 0644); err != nil {
+		return err
+	}
+	fmt.Printf("Added %s %s to %s\n", p.Package, p.Version, output)
+	return nil
+}
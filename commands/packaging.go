@@ -33,7 +33,11 @@ const packagingHelp = `PACKAGING CONFIGURATION
 
   - package: The name of your package
   - version: Must adhere to debian version syntax.
-  - architecture: CPU arch for your binaries, or "all"
+  - architecture: CPU arch for your binaries, "all", or "auto" to detect it from
+    the ELF binaries in your package
+  - architectures: List of CPU archs, used instead of architecture to build one
+    package per arch (setting both is an error). Use {{.Arch}} in files source
+    paths to pick per-arch binaries, e.g. "dist/{{.Arch}}/foo": "/usr/bin/foo"
   - maintainer: Your Name <email@example.com>
   - description: Brief explanation of your package
 
@@ -65,7 +69,9 @@ PACKAGING LAYOUT
     deb-pkg/usr/bin/mysqld      -> /usr/bin/mysqld
 
   You can override this behavior by setting autoPath to - (dash character) and /
-  or by using the Files map to create a custom source -> dest mapping.
+  or by using the Files map to create a custom source -> dest mapping. Sources
+  are relative to the config file and must be inside its directory; absolute
+  paths and paths starting with ../ are rejected.
 
   Control Scripts
 
@@ -82,6 +88,166 @@ PACKAGING LAYOUT
 
   You can override this behavior by setting the relevant fields in your config.
 
+  Packages that ask questions with debconf can also ship a config script and a
+  templates file, either at the top of deb-pkg or using the config and templates
+  fields. Templates are checked for valid debconf syntax, including translated
+  fields like Description-de.UTF-8. Add debconf to depends when using them.
+
+  Options like systemd generate additional script fragments. These replace a
+  line containing #MKDEB# in your script, or are appended to it otherwise,
+  before the exit on the last line if there is one.
+
+  Instead of a single file, each script can be assembled from a list of
+  snippets. The assembled script starts with #!/bin/sh and set -e, and rejects
+  unknown actions. Generated fragments can be placed explicitly, otherwise they
+  are added at the end. Use mkdeb build -print-scripts to see the result.
+
+    "scripts": {
+      "postinst": [
+        {"file": "scripts/setup.sh", "actions": ["configure"]},
+        {"generated": "systemd"},
+        {"inline": "echo done"}
+      ]
+    }
+
+SYSTEMD
+
+  systemd lists units to install into /lib/systemd/system. The scripts needed to
+  enable, start, and stop them are generated automatically.
+
+    "systemd": [
+      {"unit": "foo.service", "enable": true, "start": true}
+    ]
+
+  - unit: Path to the unit file
+  - name: Name to install the unit as. Defaults to the name of the unit file.
+  - enable: Enable the unit when the package is installed
+  - start: Start the unit on install and stop it on removal
+  - restartOnUpgrade: Restart the unit after upgrading instead of stopping it
+    before the upgrade
+
+USERS AND GROUPS
+
+  users and groups list accounts that postinst creates if they do not exist.
+  Accounts are created using adduser, so add it to depends.
+
+    "groups": [
+      {"name": "foo-admins", "system": true}
+    ],
+    "users": [
+      {"name": "foo", "system": true, "home": "/var/lib/foo", "groups": ["adm"]}
+    ]
+
+  - name: The user or group name
+  - system: Create a system account
+  - home: Home directory of the user. System users default to /nonexistent.
+  - shell: Login shell of the user. System users default to /usr/sbin/nologin.
+  - groups: Supplementary groups to add the user to
+
+  Set sysusers to true to also install /usr/lib/sysusers.d/<package>.conf. Only
+  system users are supported by sysusers.
+
+  Files in the package are owned by root. Use owners to change the owner of an
+  installed path to user:group, or just user for the user's primary group:
+
+    "owners": {
+      "/var/lib/foo": "foo:foo"
+    }
+
+DOCUMENTATION
+
+  copyright and changelog are installed in /usr/share/doc/<package>. Either can
+  point to an existing file, or be written from your config. The changelog is
+  compressed as changelog.Debian.gz.
+
+    "copyright": {
+      "source": "https://github.com/example/foo",
+      "files": [{"files": ["*"], "copyright": ["2020 Foo Bar"], "license": "MIT"}],
+      "licenses": [{"name": "MIT", "file": "LICENSE"}]
+    },
+    "changelog": {
+      "entries": [
+        {"version": "1.0.0", "date": "2020-01-31", "changes": ["Initial release"]}
+      ]
+    }
+
+  - copyright.file: An existing copyright file, instead of the fields below
+  - upstreamName, upstreamContact, source: DEP-5 header fields
+  - files: Copyright holders and license for files matching the patterns
+  - licenses: License texts, either as text or read from a file
+  - changelog.file: An existing changelog in debian/changelog format
+  - entries: Changelog entries, newest first. Each has a version, date, and list
+    of changes, and optionally distribution, urgency, and maintainer.
+  - git: Generate an entry for the version being built from the git history,
+    e.g. {"from": "v1.0.0"} for the commits since that tag. "to" defaults to
+    HEAD. See also mkdeb changelog.
+
+MANPAGES
+
+  manpages lists manual pages, which are compressed and installed in
+  /usr/share/man/man<N>. Name each source after the page and its section, like
+  foo.1 or foo.3pm. The section in the .TH header must match the filename.
+
+    "manpages": ["docs/foo.1", "docs/foo.conf.5.md"],
+    "infoPages": ["docs/foo.info"]
+
+  Sources ending in .md are written in markdown and rendered to troff. Start the
+  page with a title like "# foo(1) -- do things" to set the NAME section, and
+  use ## headings for sections like SYNOPSIS and OPTIONS. Paragraphs, lists,
+  code blocks, **bold**, *italic*, and definition lists are supported:
+
+    **-v**, **--verbose**
+    : Print more output
+
+  infoPages lists info files produced by makeinfo, which are compressed and
+  installed in /usr/share/info. The info directory is updated by dpkg triggers.
+
+ALTERNATIVES AND DIVERSIONS
+
+  alternatives registers files with update-alternatives so several packages or
+  versions can provide the same command. diversions moves files owned by other
+  packages out of the way using dpkg-divert. The scripts that add and remove
+  them, including when an install or upgrade is aborted, are generated.
+
+    "alternatives": [
+      {
+        "link": "/usr/bin/foo", "name": "foo", "path": "/usr/bin/foo-2",
+        "priority": 20,
+        "slaves": [
+          {"link": "/usr/share/man/man1/foo.1.gz", "name": "foo.1.gz",
+           "path": "/usr/share/man/man1/foo-2.1.gz"}
+        ]
+      }
+    ],
+    "diversions": [
+      {"path": "/etc/foo.conf", "divertTo": "/etc/foo.conf.distrib", "since": "1.2.0"}
+    ]
+
+  - link, name, path, priority: Arguments to update-alternatives --install
+  - slaves: Links that follow the alternative, each with a link, name and path
+  - path: The file to divert
+  - divertTo: Where the original file is moved to. Defaults to path.distrib.
+  - since: The version that added the diversion. It is removed again if an
+    upgrade from an older version is aborted.
+
+TRIGGERS
+
+  triggers declares dpkg triggers, which are written to the triggers control
+  file. Entries are trigger names like "ldconfig", or absolute paths for file
+  triggers that fire when other packages install files under that path.
+
+    "triggers": {
+      "interestNoawait": ["/usr/lib/foo/plugins"],
+      "triggered": "foo --rebuild-index"
+    }
+
+  - interest, interestNoawait: Triggers that cause postinst to be called with
+    "triggered"
+  - activate, activateNoawait: Triggers this package activates when it is
+    installed or removed
+  - triggered: Shell code postinst runs when it is triggered. The activated
+    triggers are passed in $2.
+
 BUILD OPTIONS
 
   The following options change how mkdeb runs when building packages.
@@ -89,10 +255,27 @@ BUILD OPTIONS
   - tempPath: Controls where intermediate files are written during the build.
     This defaults to the system temp directory.
 
+  - filenameTemplate: Path of the package in the build target. Defaults to
+    {{.Package}}_{{.Version}}_{{.Architecture}}.deb, where the version does not
+    include the epoch. {{.Epoch}}, {{.Upstream}}, and {{.Revision}} are also
+    available, and {{pool .Package}} gives the directory in a Debian pool.
+
   - upgradeConfigs: Indicates whether apt should replace files under /etc when
     installing a new package version. By default these files are not upgraded.
 
   - preserveSymlinks: By default contents of symlink targets are copied. This
     option writes symlinks to the archive instead.
 
+  - shlibDepends: Adds the packages providing the shared libraries your ELF
+    binaries link against to depends. Libraries shipped in your package are
+    skipped.
+
+  - shlibs: A dpkg shlibs or symbols file used by shlibDepends to map shared
+    libraries to packages. Defaults to the dpkg database of the build machine.
+
+  - stripDebug: Strips debug info from ELF binaries. Debug info from binaries
+    with a build id is moved to a separate <package>-dbgsym package.
+
+  - objcopy: Path to the objcopy used by stripDebug. Defaults to "objcopy".
+
 `
@@ -0,0 +1,102 @@
+package commands
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cbednarski/mkdeb/deb"
+)
+
+const (
+	progressWidth    = 30
+	progressInterval = 100 * time.Millisecond
+)
+
+// progressBar shows the combined progress of the packages being built on a
+// terminal. Packages report their progress from their own goroutines.
+type progressBar struct {
+	mu       sync.Mutex
+	out      *os.File
+	packages map[string]deb.Progress
+	latest   deb.Progress
+	drawn    time.Time
+}
+
+// newProgressBar returns a progress bar that draws to out, or nil if out is
+// not a terminal
+func newProgressBar(out *os.File) *progressBar {
+	info, err := out.Stat()
+	if err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		return nil
+	}
+	return &progressBar{out: out, packages: map[string]deb.Progress{}}
+}
+
+// update records the progress of a package and redraws the bar, at most every
+// progressInterval
+func (b *progressBar) update(progress deb.Progress) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.packages[progress.Package+" "+progress.Architecture] = progress
+	b.latest = progress
+	if time.Since(b.drawn) < progressInterval {
+		return
+	}
+	b.drawn = time.Now()
+	b.draw()
+}
+
+// draw writes the bar over the current line. Every package reads its files
+// once per stage, so the stages each count for an equal part of the bar.
+func (b *progressBar) draw() {
+	var done, total float64
+	for _, progress := range b.packages {
+		stage := 0
+		for i, s := range deb.ProgressStages {
+			if s == progress.Stage {
+				stage = i
+			}
+		}
+		done += float64(stage)*float64(progress.TotalBytes) + float64(progress.Bytes)
+		total += float64(len(deb.ProgressStages)) * float64(progress.TotalBytes)
+	}
+	fraction := 0.0
+	if total > 0 {
+		fraction = done / total
+	}
+	filled := int(fraction * progressWidth)
+	bar := strings.Repeat("=", filled) + strings.Repeat(" ", progressWidth-filled)
+
+	latest := b.latest
+	fmt.Fprintf(b.out, "\r[%s] %3.0f%% %s %s: %s %s of %s\x1b[K", bar, fraction*100,
+		latest.Package, latest.Architecture, latest.Stage,
+		formatBytes(latest.Bytes), formatBytes(latest.TotalBytes))
+}
+
+// finish clears the bar so other output can be printed
+func (b *progressBar) finish() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.drawn.IsZero() {
+		fmt.Fprint(b.out, "\r\x1b[K")
+	}
+}
+
+// formatBytes formats a size like 1.5 MB
+func formatBytes(size int64) string {
+	const unit = 1000
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	value := float64(size)
+	prefixes := "kMGTPE"
+	i := -1
+	for value >= unit && i < len(prefixes)-1 {
+		value /= unit
+		i++
+	}
+	return fmt.Sprintf("%.1f %cB", value, prefixes[i])
+}
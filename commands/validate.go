@@ -4,8 +4,8 @@ import (
 	"context"
 	"flag"
 	"fmt"
+	"io"
 	"log"
-	"os"
 
 	"github.com/cbednarski/mkdeb/deb"
 	"github.com/facebookgo/flagenv"
@@ -19,7 +19,7 @@ type ValidateCmd struct {
 func (*ValidateCmd) Name() string     { return "validate" }
 func (*ValidateCmd) Synopsis() string { return "validate config file" }
 func (*ValidateCmd) Usage() string {
-	return `validate [-config] mkdeb.json:
+	return `validate [-config] mkdeb.json [mkdeb.json...]:
 `
 }
 
@@ -32,47 +32,27 @@ func (p *ValidateCmd) Execute(_ context.Context, f *flag.FlagSet, _ ...interface
 		log.Fatal(err)
 	}
 
-	var config string
-	if f.NArg() > 0 {
-		config = f.Arg(0)
-	}
-	if p.config != "" {
-		if config != "" {
-			fmt.Println("error: only use one of positional or -config argument for config file")
-			return subcommands.ExitFailure
-		}
-		config = p.config
-	}
-	if config == "" {
-		fmt.Println("Error: config file not specified")
+	configs, err := configArgs(f, p.config)
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
 		return subcommands.ExitFailure
 	}
 
-	if err := validate(config); err != nil {
-		fmt.Printf("Error: %s\n", err)
+	if !printResults(configs, runConfigs(configs, validate)) {
 		return subcommands.ExitFailure
 	}
 	return subcommands.ExitSuccess
 }
 
-func validate(config string) error {
-	// Change to config path
-	back, err := os.Getwd()
-	if err != nil {
-		return err
-	}
+func validate(config string, out io.Writer) error {
 	workdir, filename := getAbsPaths(config)
-	err = os.Chdir(workdir)
-	if err != nil {
-		return err
-	}
-	defer os.Chdir(back)
-	fmt.Println(workdir, filename)
+	fmt.Fprintln(out, workdir, filename)
 	// Validate
 	p, err := deb.NewPackageSpecFromFile(filename)
 	if err != nil {
 		return err
 	}
+	p.BaseDir = workdir
 	err = p.Validate(false)
 	if err != nil {
 		return err
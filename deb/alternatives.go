@@ -0,0 +1,112 @@
+package deb
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"text/template"
+)
+
+var reAlternativeName = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9._+-]*$`)
+
+// Alternative registers a file with update-alternatives, so several packages
+// (or several versions of the same program) can provide the same command. See
+// update-alternatives(1) for details.
+//
+// Link is the generic name, e.g. /usr/bin/editor. Name is the name of the link
+// group, e.g. editor. Path is the file in this package that Link points to when
+// this alternative is selected. The alternative with the highest Priority is
+// used in automatic mode.
+//
+// Slaves are links that follow the master link, such as a manpage.
+type Alternative struct {
+	Link     string             `json:"link"`
+	Name     string             `json:"name"`
+	Path     string             `json:"path"`
+	Priority int                `json:"priority"`
+	Slaves   []AlternativeSlave `json:"slaves,omitempty"`
+}
+
+// AlternativeSlave is a link that is switched along with an Alternative
+type AlternativeSlave struct {
+	Link string `json:"link"`
+	Name string `json:"name"`
+	Path string `json:"path"`
+}
+
+// validateAlternatives checks alternatives have the required fields
+func (p *PackageSpec) validateAlternatives() error {
+	for _, alt := range p.Alternatives {
+		if err := validateAlternativeLink(alt.Link, alt.Name, alt.Path); err != nil {
+			return err
+		}
+		for _, slave := range alt.Slaves {
+			if err := validateAlternativeLink(slave.Link, slave.Name, slave.Path); err != nil {
+				return fmt.Errorf("Slave of alternative %q is invalid: %s", alt.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+func validateAlternativeLink(link, name, target string) error {
+	if !reAlternativeName.MatchString(name) {
+		return fmt.Errorf("Alternative name %q is invalid; expected a name matching %q", name, reAlternativeName.String())
+	}
+	if !path.IsAbs(link) || !path.IsAbs(target) {
+		return fmt.Errorf("Alternative %q must have an absolute link and path", name)
+	}
+	if path.Clean(link) == path.Clean(target) {
+		return fmt.Errorf("Alternative %q must have a link that is different from its path", name)
+	}
+	return nil
+}
+
+// alternativesScripts renders the maintainer script fragments that register
+// and unregister the alternatives.
+//
+// Alternatives are installed by postinst, including when an upgrade or removal
+// is aborted, since update-alternatives --install is idempotent. They are only
+// removed by prerm on remove and deconfigure so the current selection survives
+// upgrades. When every file of the package has been replaced by other
+// packages dpkg calls postrm with disappear instead of prerm, so postrm removes
+// them in that case.
+func (p *PackageSpec) alternativesScripts() (map[string][]byte, error) {
+	return renderScriptTemplates(alternativesTemplates, len(p.Alternatives) > 0, p.Alternatives)
+}
+
+var alternativesTemplates = map[string]*template.Template{
+	"postinst": template.Must(template.New("postinst").Funcs(scriptTemplateFuncs).Parse(alternativesPostinstTemplate)),
+	"prerm":    template.Must(template.New("prerm").Funcs(scriptTemplateFuncs).Parse(alternativesPrermTemplate)),
+	"postrm":   template.Must(template.New("postrm").Funcs(scriptTemplateFuncs).Parse(alternativesPostrmTemplate)),
+}
+
+const alternativesPostinstTemplate = `
+# alternatives
+if [ "$1" = "configure" ] || [ "$1" = "abort-upgrade" ] || [ "$1" = "abort-deconfigure" ] || [ "$1" = "abort-remove" ] ; then
+{{- range . }}
+	update-alternatives --install {{ quote .Link }} {{ quote .Name }} {{ quote .Path }} {{ .Priority }}
+{{- range .Slaves }} \
+		--slave {{ quote .Link }} {{ quote .Name }} {{ quote .Path }}
+{{- end }}
+{{- end }}
+fi
+`
+
+const alternativesPrermTemplate = `
+# alternatives
+if [ "$1" = "remove" ] || [ "$1" = "deconfigure" ] ; then
+{{- range . }}
+	update-alternatives --remove {{ quote .Name }} {{ quote .Path }}
+{{- end }}
+fi
+`
+
+const alternativesPostrmTemplate = `
+# alternatives
+if [ "$1" = "disappear" ] ; then
+{{- range . }}
+	update-alternatives --remove {{ quote .Name }} {{ quote .Path }} || true
+{{- end }}
+fi
+`
@@ -0,0 +1,94 @@
+package deb
+
+import (
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestValidateAlternatives(t *testing.T) {
+	p := PackageSpecFixture(t, "alternatives.json")
+	if err := p.Validate(false); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := map[string]Alternative{
+		"Alternative name \"a/b\"":   {Link: "/usr/bin/a", Name: "a/b", Path: "/usr/bin/a-1"},
+		"absolute link and path":     {Link: "usr/bin/a", Name: "a", Path: "/usr/bin/a-1"},
+		"different from its path":    {Link: "/usr/bin/a", Name: "a", Path: "/usr/bin/a"},
+		"Slave of alternative \"a\"": {Link: "/usr/bin/a", Name: "a", Path: "/usr/bin/a-1", Slaves: []AlternativeSlave{{Link: "/a.1", Name: "a.1"}}},
+	}
+	for expected, alt := range cases {
+		p := PackageSpecFixture(t)
+		p.Alternatives = []Alternative{alt}
+		err := p.Validate(false)
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("Expected error containing %q; found %+v", expected, err)
+		}
+	}
+}
+
+func TestAlternativesScripts(t *testing.T) {
+	p := PackageSpecFixture(t, "alternatives.json")
+
+	scripts, err := p.alternativesScripts()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `
+# alternatives
+if [ "$1" = "configure" ] || [ "$1" = "abort-upgrade" ] || [ "$1" = "abort-deconfigure" ] || [ "$1" = "abort-remove" ] ; then
+	update-alternatives --install '/usr/bin/package1' 'package1' '/usr/local/bin/package1' 20 \
+		--slave '/etc/package1/default' 'package1-config' '/etc/package1/config'
+fi
+`
+	if postinst := string(scripts["postinst"]); postinst != expected {
+		t.Errorf("--Expected postinst--\n%s\n--Found--\n%s\n", expected, postinst)
+	}
+
+	if prerm := string(scripts["prerm"]); !strings.Contains(prerm, `[ "$1" = "remove" ] || [ "$1" = "deconfigure" ]`) ||
+		!strings.Contains(prerm, "update-alternatives --remove 'package1' '/usr/local/bin/package1'") {
+		t.Errorf("Unexpected prerm:\n%s", prerm)
+	}
+	if postrm := string(scripts["postrm"]); !strings.Contains(postrm, `[ "$1" = "disappear" ]`) {
+		t.Errorf("Unexpected postrm:\n%s", postrm)
+	}
+	if _, ok := scripts["preinst"]; ok {
+		t.Errorf("Expected no preinst")
+	}
+}
+
+func TestAlternativesAndDiversionsLint(t *testing.T) {
+	p := PackageSpecFixture(t, "alternatives.json")
+	p.Diversions = []Diversion{{Path: "/etc/package1/config", Since: "1.0"}}
+	p.AutoPath = "-"
+
+	scripts, err := p.RenderControlScripts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"preinst", "postinst", "prerm", "postrm"} {
+		if _, ok := scripts[name]; !ok {
+			t.Errorf("Expected %s to be generated", name)
+		}
+	}
+
+	diagnostics, err := p.LintControlScripts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diagnostics) != 0 {
+		t.Errorf("Unexpected diagnostics: %+v", diagnostics)
+	}
+
+	if _, err := exec.LookPath("sh"); err == nil {
+		for name, script := range scripts {
+			cmd := exec.Command("sh", "-n")
+			cmd.Stdin = strings.NewReader(string(script))
+			if output, err := cmd.CombinedOutput(); err != nil {
+				t.Errorf("%s has a syntax error: %s\n%s\n%s", name, err, output, script)
+			}
+		}
+	}
+}
@@ -0,0 +1,248 @@
+package deb
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/laher/argo/ar"
+)
+
+// Builder writes a package to an io.Writer instead of a file, for example to
+// upload it while it is being built.
+//
+// The files in the package are read once. Each file is hashed for md5sums
+// while it is compressed into the data archive, which is kept in the
+// workspace until the control archive is written in front of it. The
+// workspace also holds files generated from the spec and stripped binaries.
+//
+// Call Close to remove the workspace when you are done with the Builder.
+type Builder struct {
+	spec      *PackageSpec
+	workspace string
+	modTime   time.Time
+	checksums []FileChecksum
+}
+
+// NewBuilder validates the spec and prepares the files that are generated
+// during the build, such as stripped binaries and compressed changelogs.
+func (p *PackageSpec) NewBuilder() (*Builder, error) {
+	return p.NewBuilderContext(context.Background())
+}
+
+// NewBuilderContext is like NewBuilder, but stops when ctx is cancelled
+func (p *PackageSpec) NewBuilderContext(ctx context.Context) (*Builder, error) {
+	if err := p.prepare(); err != nil {
+		return nil, err
+	}
+	return p.newBuilder(ctx)
+}
+
+// prepare resolves and validates the spec before it is built. Files from an
+// earlier build are forgotten.
+func (p *PackageSpec) prepare() error {
+	p.generated = nil
+	p.stripped, p.debugFiles, p.buildIDs = nil, nil, nil
+
+	if err := p.ResolveArchitecture(); err != nil {
+		return err
+	}
+	if err := p.Validate(true); err != nil {
+		return err
+	}
+	if p.ShlibDepends {
+		if err := p.AddShlibDepends(); err != nil {
+			return fmt.Errorf("Failed to detect shared library dependencies: %s", err)
+		}
+	}
+	return nil
+}
+
+// newBuilder writes the generated files and stripped binaries for a prepared
+// spec into a new workspace
+func (p *PackageSpec) newBuilder(ctx context.Context) (*Builder, error) {
+	tempPath := p.TempPath
+	if tempPath != "" {
+		tempPath = p.hostPath(tempPath)
+	}
+	ws, err := ioutil.TempDir(tempPath, "mkdeb")
+	if err != nil {
+		return nil, fmt.Errorf("Could not create build workspace: %v", err)
+	}
+	b := &Builder{spec: p, workspace: ws, modTime: time.Now()}
+	p.workspace = ws
+
+	if err := ctx.Err(); err != nil {
+		b.Close()
+		return nil, err
+	}
+	if err := p.writeGeneratedFiles(ws); err != nil {
+		b.Close()
+		return nil, fmt.Errorf("Failed to generate files: %s", err)
+	}
+
+	if p.StripDebug {
+		if err := p.stripBinaries(ctx, ws); err != nil {
+			b.Close()
+			return nil, fmt.Errorf("Failed to strip debug info: %s", err)
+		}
+	}
+	return b, nil
+}
+
+// WriteTo writes the .deb to w. It implements io.WriterTo.
+func (b *Builder) WriteTo(w io.Writer) (int64, error) {
+	return b.WriteToContext(context.Background(), w)
+}
+
+// WriteToContext is like WriteTo, but stops when ctx is cancelled
+func (b *Builder) WriteToContext(ctx context.Context, w io.Writer) (int64, error) {
+	return b.writePackage(ctx, w)
+}
+
+// Checksums returns the md5 and sha256 checksums of the files in the package.
+// They are calculated by WriteTo.
+func (b *Builder) Checksums() []FileChecksum {
+	return b.checksums
+}
+
+// HasDebugPackage reports whether StripDebug found debug symbols, which are
+// written by WriteDebugTo.
+func (b *Builder) HasDebugPackage() bool {
+	return len(b.spec.debugFiles) > 0
+}
+
+// WriteDebugTo writes the -dbgsym package to w. It is named DebugFilename().
+func (b *Builder) WriteDebugTo(w io.Writer) (int64, error) {
+	return b.WriteDebugToContext(context.Background(), w)
+}
+
+// WriteDebugToContext is like WriteDebugTo, but stops when ctx is cancelled
+func (b *Builder) WriteDebugToContext(ctx context.Context, w io.Writer) (int64, error) {
+	if !b.HasDebugPackage() {
+		return 0, fmt.Errorf("No debug symbols were found")
+	}
+	dbg, err := b.spec.debugSymbolsSpec().NewBuilderContext(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer dbg.Close()
+	return dbg.WriteToContext(ctx, w)
+}
+
+// Close removes the build workspace
+func (b *Builder) Close() error {
+	b.spec.workspace = ""
+	if err := os.RemoveAll(b.workspace); err != nil {
+		log.Printf("Error cleaning up build workspace '%v': %v", b.workspace, err)
+		return err
+	}
+	return nil
+}
+
+// WriteTo builds the package and writes the .deb to w. The -dbgsym package is
+// not written; use a Builder to get both.
+func (p *PackageSpec) WriteTo(w io.Writer) (int64, error) {
+	return p.WriteToContext(context.Background(), w)
+}
+
+// WriteToContext is like WriteTo, but stops when ctx is cancelled
+func (p *PackageSpec) WriteToContext(ctx context.Context, w io.Writer) (int64, error) {
+	b, err := p.NewBuilderContext(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer b.Close()
+	return b.WriteToContext(ctx, w)
+}
+
+// writePackage writes the ar archive containing debian-binary, the control
+// archive, and the data archive.
+func (b *Builder) writePackage(ctx context.Context, w io.Writer) (int64, error) {
+	p := b.spec
+	payload, err := p.walkPayload()
+	if err != nil {
+		return 0, err
+	}
+
+	// Compress the data archive first, since the control archive contains
+	// the checksums of the files and is written in front of it
+	dataFile, err := os.Create(filepath.Join(b.workspace, "data.tar.gz"))
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		dataFile.Close()
+		os.Remove(dataFile.Name())
+	}()
+	if err := p.readPayload(ctx, dataFile, payload, ProgressCompressing); err != nil {
+		return 0, fmt.Errorf("Failed to compress data files: %s", err)
+	}
+	b.checksums = payload.checksums()
+
+	if p.InstalledSize, err = p.installedSize(payload); err != nil {
+		return 0, err
+	}
+
+	out := &countingWriter{w: w}
+	archive := ar.NewWriter(out)
+	header := ar.Header{
+		ModTime: b.modTime,
+		Uid:     0,
+		Gid:     0,
+		Mode:    0600,
+	}
+
+	// Write the debian binary version (hard-coded to 2.0)
+	if err := writeBytesToAr(archive, header, "debian-binary", []byte("2.0\n")); err != nil {
+		return out.n, fmt.Errorf("Failed to write debian-binary: %s", err)
+	}
+
+	// The control archive is small enough to keep in memory
+	control := &bytes.Buffer{}
+	if err := p.writeControlArchive(control, payload, b.modTime); err != nil {
+		return out.n, fmt.Errorf("Failed to compress control files: %s", err)
+	}
+	if err := writeBytesToAr(archive, header, "control.tar.gz", control.Bytes()); err != nil {
+		return out.n, err
+	}
+
+	size, err := dataFile.Seek(0, io.SeekEnd)
+	if err != nil {
+		return out.n, err
+	}
+	if _, err := dataFile.Seek(0, io.SeekStart); err != nil {
+		return out.n, err
+	}
+	header.Name = "data.tar.gz"
+	header.Size = size
+	if err := archive.WriteHeader(&header); err != nil {
+		return out.n, fmt.Errorf("Failed writing ar header for %q: %s", header.Name, err)
+	}
+	if _, err := io.Copy(archive, dataFile); err != nil {
+		return out.n, err
+	}
+
+	if err := archive.Close(); err != nil {
+		return out.n, err
+	}
+	return out.n, nil
+}
+
+// countingWriter counts the bytes written to w
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(b []byte) (int, error) {
+	n, err := c.w.Write(b)
+	c.n += int64(n)
+	return n, err
+}
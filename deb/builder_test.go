@@ -0,0 +1,182 @@
+package deb
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// readArMembers returns the names and contents of the members of an ar archive
+func readArMembers(t *testing.T, data []byte) ([]string, map[string][]byte) {
+	if !bytes.HasPrefix(data, []byte("!<arch>\n")) {
+		t.Fatalf("Missing ar magic")
+	}
+	data = data[8:]
+	names := []string{}
+	members := map[string][]byte{}
+	for len(data) > 0 {
+		if len(data) < 60 {
+			t.Fatalf("Truncated ar header")
+		}
+		name := strings.TrimSuffix(strings.TrimSpace(string(data[:16])), "/")
+		size, err := strconv.ParseInt(strings.TrimSpace(string(data[48:58])), 10, 64)
+		if err != nil {
+			t.Fatalf("Invalid ar size for %s: %s", name, err)
+		}
+		data = data[60:]
+		if int64(len(data)) < size {
+			t.Fatalf("Truncated ar member %s", name)
+		}
+		names = append(names, name)
+		members[name] = data[:size]
+		data = data[size:]
+		if size%2 == 1 && len(data) > 0 {
+			data = data[1:]
+		}
+	}
+	return names, members
+}
+
+// readTarGz returns the names of the entries in a compressed tar archive
+func readTarGz(t *testing.T, data []byte) []string {
+	zipreader, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	archive := tar.NewReader(zipreader)
+	names := []string{}
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, header.Name)
+	}
+	return names
+}
+
+func TestWriteTo(t *testing.T) {
+	p := PackageSpecFixture(t)
+	p.Version = "0.1.0"
+
+	buf := &bytes.Buffer{}
+	n, err := p.WriteTo(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(buf.Len()) {
+		t.Errorf("Expected WriteTo to return %d; found %d", buf.Len(), n)
+	}
+
+	names, members := readArMembers(t, buf.Bytes())
+	if strings.Join(names, ",") != "debian-binary,control.tar.gz,data.tar.gz" {
+		t.Fatalf("Unexpected ar members %v", names)
+	}
+	if string(members["debian-binary"]) != "2.0\n" {
+		t.Errorf("Unexpected debian-binary %q", members["debian-binary"])
+	}
+	control := strings.Join(readTarGz(t, members["control.tar.gz"]), ",")
+	if !strings.Contains(control, "control") || !strings.Contains(control, "md5sums") {
+		t.Errorf("Unexpected control archive %s", control)
+	}
+	files, err := p.ListFiles(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data := readTarGz(t, members["data.tar.gz"]); len(data) != len(files) {
+		t.Errorf("Expected %d files in data archive; found %v", len(files), data)
+	}
+
+	if _, err := exec.LookPath("dpkg-deb"); err == nil {
+		dir, err := ioutil.TempDir("", "mkdeb-writeto")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		filename := filepath.Join(dir, p.Filename())
+		if err := ioutil.WriteFile(filename, buf.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if output, err := exec.Command("dpkg-deb", "--info", filename).CombinedOutput(); err != nil {
+			t.Errorf("dpkg-deb rejected the package: %s\n%s", err, output)
+		}
+	}
+}
+
+// failingWriter fails after accepting limit bytes
+type failingWriter struct {
+	limit int
+}
+
+func (f *failingWriter) Write(b []byte) (int, error) {
+	if len(b) > f.limit {
+		n := f.limit
+		f.limit = 0
+		return n, errors.New("upload failed")
+	}
+	f.limit -= len(b)
+	return len(b), nil
+}
+
+func TestWriteToError(t *testing.T) {
+	p := PackageSpecFixture(t)
+	p.Version = "0.1.0"
+
+	size, err := p.WriteTo(ioutil.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, limit := range []int{0, 100, int(size) - 10} {
+		_, err := p.WriteTo(&failingWriter{limit: limit})
+		if err == nil || !strings.Contains(err.Error(), "upload failed") {
+			t.Errorf("Expected write error after %d bytes; found %v", limit, err)
+		}
+	}
+}
+
+func TestBuilder(t *testing.T) {
+	p := PackageSpecFixture(t)
+	p.Version = "0.1.0"
+
+	b, err := p.NewBuilder()
+	if err != nil {
+		t.Fatal(err)
+	}
+	workspace := b.workspace
+
+	first, second := &bytes.Buffer{}, &bytes.Buffer{}
+	if _, err := b.WriteTo(first); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.WriteTo(second); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(first.Bytes(), second.Bytes()) {
+		t.Errorf("Expected the same package from both writes")
+	}
+	if b.HasDebugPackage() {
+		t.Errorf("Expected no debug package")
+	}
+	if _, err := b.WriteDebugTo(ioutil.Discard); err == nil {
+		t.Errorf("Expected WriteDebugTo to fail without debug symbols")
+	}
+
+	if err := b.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if FileExists(workspace) {
+		t.Errorf("Expected workspace to be removed")
+	}
+}
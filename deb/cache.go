@@ -0,0 +1,343 @@
+package deb
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+)
+
+const (
+	// cacheFormat is part of every cache key, so changing it invalidates
+	// packages built by older versions of mkdeb. Increase it whenever the
+	// packages mkdeb writes change, e.g. the tar headers or the file names.
+	cacheFormat = "mkdeb-cache-2"
+
+	// cachePackage and cacheDebugPackage are the names of the packages in a
+	// cache entry
+	cachePackage      = "package.deb"
+	cacheDebugPackage = "dbgsym.deb"
+)
+
+var (
+	reCacheKey    = regexp.MustCompile(`^[0-9a-f]{64}$`)
+	reCachePrefix = regexp.MustCompile(`^[0-9a-f]{2}$`)
+
+	executableDigest     string
+	executableDigestErr  error
+	executableDigestOnce sync.Once
+)
+
+// DefaultCacheDir returns the directory packages are cached in by default,
+// which is mkdeb in the user's cache directory
+func DefaultCacheDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "mkdeb"), nil
+}
+
+// CacheHit reports whether the last call to Build reused a package from
+// CacheDir instead of building it
+func (p *PackageSpec) CacheHit() bool {
+	return p.cacheHit
+}
+
+// cacheKey returns a digest of everything that goes into the package: the
+// resolved spec, the path, mode, and contents of each file, the inputs of the
+// files generated during the build, and the control files. It is calculated
+// before anything is generated or stripped, so a cached package is restored
+// without that work. Stripped binaries and debug symbols only depend on the
+// files and objcopy, so objcopy is included when StripDebug is set.
+func (p *PackageSpec) cacheKey(ctx context.Context) (string, error) {
+	h := sha256.New()
+	digest, err := toolDigest()
+	if err != nil {
+		return "", err
+	}
+	fmt.Fprintf(h, "%s\ntool %s\n", cacheFormat, digest)
+
+	// Version is not part of the JSON
+	spec, err := json.Marshal(p)
+	if err != nil {
+		return "", err
+	}
+	fmt.Fprintf(h, "spec %s\nversion %s\n", spec, p.Version)
+
+	if err := hashPayload(ctx, h, p); err != nil {
+		return "", err
+	}
+	if p.StripDebug {
+		filename, err := exec.LookPath(p.objcopy())
+		if err != nil {
+			return "", fmt.Errorf("Failed to find objcopy: %s", err)
+		}
+		digest, err := fileDigest(filename)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(h, "objcopy %s\n", digest)
+	}
+
+	// Generated files are rendered from the spec and these inputs
+	copyright, err := p.RenderCopyright()
+	if err != nil {
+		return "", err
+	}
+	changelog, err := p.RenderChangelog()
+	if err != nil {
+		return "", err
+	}
+	fmt.Fprintf(h, "copyright %x\nchangelog %x\n", sha256.Sum256(copyright), sha256.Sum256(changelog))
+	for _, source := range append(append([]string{}, p.Manpages...), p.InfoPages...) {
+		data, err := p.readFile(source)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(h, "doc %s %x\n", source, sha256.Sum256(data))
+	}
+
+	files, err := p.RenderControlFiles()
+	if err != nil {
+		return "", err
+	}
+	for _, file := range files {
+		fmt.Fprintf(h, "control %s %o %x\n", file.Name, file.Mode, sha256.Sum256(file.Data))
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// toolDigest returns the checksum of the running executable. It is part of
+// every cache key, so packages built by another version of mkdeb are never
+// reused, even if cacheFormat was not increased.
+func toolDigest() (string, error) {
+	executableDigestOnce.Do(func() {
+		filename, err := os.Executable()
+		if err != nil {
+			executableDigestErr = err
+			return
+		}
+		executableDigest, executableDigestErr = fileDigest(filename)
+	})
+	if executableDigestErr != nil {
+		return "", fmt.Errorf("Failed to checksum mkdeb for the build cache: %s", executableDigestErr)
+	}
+	return executableDigest, nil
+}
+
+// fileDigest returns the sha256 checksum of a file on the host
+func fileDigest(filename string) (string, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// hashPayload writes the path, mode, and checksum of every file in the
+// package to w, and the hard links between them
+func hashPayload(ctx context.Context, w io.Writer, p *PackageSpec) error {
+	payload, err := p.walkPayload()
+	if err != nil {
+		return err
+	}
+	if err := p.readPayload(ctx, nil, payload, ProgressHashing); err != nil {
+		return err
+	}
+	for _, file := range payload.files {
+		fmt.Fprintf(w, "file %s %s %s\n", file.target, file.info.Mode(), file.sha256)
+		if file.link != nil {
+			fmt.Fprintf(w, "link %s %s\n", file.target, file.link.target)
+		}
+	}
+	return nil
+}
+
+// cacheEntry returns the directory a package with key is cached in
+func (p *PackageSpec) cacheEntry(key string) string {
+	return filepath.Join(p.hostPath(p.CacheDir), key[:2], key)
+}
+
+// restoreFromCache copies the cached packages to target, like Build writes
+// them. It returns false if the package is not in the cache.
+func (p *PackageSpec) restoreFromCache(key, target string) (bool, error) {
+	entry := p.cacheEntry(key)
+	if !FileExists(filepath.Join(entry, cachePackage)) {
+		return false, nil
+	}
+	files := map[string]string{cachePackage: path.Join(target, p.Filename())}
+	if FileExists(filepath.Join(entry, cacheDebugPackage)) {
+		files[cacheDebugPackage] = path.Join(target, p.debugSymbolsSpec().Filename())
+	}
+
+	restored := []string{}
+	for name, target := range files {
+		err := writePackageFile(target, func(w io.Writer) (int64, error) {
+			file, err := os.Open(filepath.Join(entry, name))
+			if err != nil {
+				return 0, err
+			}
+			defer file.Close()
+			return io.Copy(w, file)
+		})
+		if err != nil {
+			for _, filename := range restored {
+				os.Remove(filename)
+			}
+			return false, fmt.Errorf("Failed to copy cached package: %s", err)
+		}
+		restored = append(restored, target)
+	}
+
+	// Mark the entry as used so it is not pruned
+	now := time.Now()
+	os.Chtimes(entry, now, now)
+	p.cachedDebug = files[cacheDebugPackage] != ""
+	return true, nil
+}
+
+// storeInCache copies the packages that were built to the cache. files maps
+// the names in the cache entry to the packages. The entry is written to a
+// temporary directory first so other builds never see part of it.
+func (p *PackageSpec) storeInCache(key string, files map[string]string) error {
+	entry := p.cacheEntry(key)
+	if err := os.MkdirAll(filepath.Dir(entry), 0755); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempDir(filepath.Dir(entry), "tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+
+	for name, filename := range files {
+		if err := copyFile(filename, filepath.Join(tmp, name)); err != nil {
+			return err
+		}
+	}
+	// The temporary directory is private to this process
+	if err := os.Chmod(tmp, 0755); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, entry); err != nil && !FileExists(entry) {
+		return err
+	}
+	return nil
+}
+
+// buildWithCache restores the packages for a prepared spec from the cache if
+// they exist, or builds them and adds them to the cache
+func (p *PackageSpec) buildWithCache(ctx context.Context, target string) error {
+	key, err := p.cacheKey(ctx)
+	if err != nil {
+		return err
+	}
+	if p.cacheHit, err = p.restoreFromCache(key, target); err != nil || p.cacheHit {
+		return err
+	}
+
+	files, err := p.buildPackages(ctx, target)
+	if err != nil {
+		return err
+	}
+	// A broken cache should not fail the build
+	if err := p.storeInCache(key, files); err != nil {
+		log.Printf("Error adding %s to the build cache: %s", p.Filename(), err)
+	}
+	return nil
+}
+
+// PruneCache removes the packages in the cache dir that have not been used
+// for olderThan, or all of them if olderThan is 0. It returns the number of
+// packages removed and the number of bytes freed.
+func PruneCache(dir string, olderThan time.Duration) (int, int64, error) {
+	removed, freed := 0, int64(0)
+	prefixes, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return 0, 0, nil
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+
+	cutoff := time.Now().Add(-olderThan)
+	for _, prefix := range prefixes {
+		// Only touch directories that look like cache entries, in case dir
+		// is pointed somewhere else by mistake
+		if !prefix.IsDir() || !reCachePrefix.MatchString(prefix.Name()) {
+			continue
+		}
+		entries, err := ioutil.ReadDir(filepath.Join(dir, prefix.Name()))
+		if err != nil {
+			return removed, freed, err
+		}
+		for _, entry := range entries {
+			if !entry.IsDir() || !reCacheKey.MatchString(entry.Name()) {
+				continue
+			}
+			if olderThan > 0 && entry.ModTime().After(cutoff) {
+				continue
+			}
+			filename := filepath.Join(dir, prefix.Name(), entry.Name())
+			size, err := dirSize(filename)
+			if err != nil {
+				return removed, freed, err
+			}
+			if err := os.RemoveAll(filename); err != nil {
+				return removed, freed, err
+			}
+			removed++
+			freed += size
+		}
+	}
+	return removed, freed, nil
+}
+
+// dirSize returns the size of the files in a directory
+func dirSize(dir string) (int64, error) {
+	size := int64(0)
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return 0, err
+	}
+	for _, file := range files {
+		size += file.Size()
+	}
+	return size, nil
+}
+
+// copyFile copies a file on the host
+func copyFile(src, dest string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,160 @@
+package deb
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestBuildCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mkdeb-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cacheDir := filepath.Join(dir, "cache")
+
+	spec := func(cacheDir string) *PackageSpec {
+		p := PackageSpecFixture(t, "source.json")
+		p.Version = "0.1.0"
+		p.CacheDir = cacheDir
+		// Some tests replace files, so copy the source
+		source := fstest.MapFS{}
+		for name, file := range sourceFixture {
+			source[name] = file
+		}
+		p.Source = source
+		return p
+	}
+
+	build := func(p *PackageSpec, target string) []byte {
+		target = filepath.Join(dir, target)
+		if err := p.Build(target); err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadFile(filepath.Join(target, p.Filename()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+
+	p := spec(cacheDir)
+	first := build(p, "first")
+	if p.CacheHit() {
+		t.Errorf("Expected the first build not to be cached")
+	}
+
+	p = spec(cacheDir)
+	second := build(p, "second")
+	if !p.CacheHit() {
+		t.Errorf("Expected the second build to be cached")
+	}
+	if !bytes.Equal(first, second) {
+		t.Errorf("Expected the cached package to be the same as the first build")
+	}
+
+	// A cached package is restored without creating a build workspace
+	tmpdir := os.Getenv("TMPDIR")
+	os.Setenv("TMPDIR", filepath.Join(dir, "missing"))
+	p = spec(cacheDir)
+	build(p, "workspace")
+	os.Setenv("TMPDIR", tmpdir)
+	if !p.CacheHit() {
+		t.Errorf("Expected the package to be restored without a workspace")
+	}
+
+	changes := map[string]func(p *PackageSpec){
+		"description": func(p *PackageSpec) { p.Description = "changed" },
+		"version":     func(p *PackageSpec) { p.Version = "0.2.0" },
+		"content": func(p *PackageSpec) {
+			p.Source.(fstest.MapFS)["build/tool"] = &fstest.MapFile{Data: []byte("tool 2"), Mode: 0755}
+		},
+		"mode": func(p *PackageSpec) {
+			p.Source.(fstest.MapFS)["build/tool"] = &fstest.MapFile{Data: []byte("tool"), Mode: 0700}
+		},
+		"script": func(p *PackageSpec) {
+			p.Source.(fstest.MapFS)["deb-pkg/postinst"] = &fstest.MapFile{Data: []byte("#!/bin/sh\nset -e\n"), Mode: 0755}
+		},
+	}
+	for name, change := range changes {
+		p := spec(cacheDir)
+		change(p)
+		build(p, name)
+		if p.CacheHit() {
+			t.Errorf("Expected a change to the %s not to be cached", name)
+		}
+	}
+
+	// Without a cache dir nothing is cached
+	p = spec("")
+	build(p, "nocache")
+	if p.CacheHit() {
+		t.Errorf("Expected no cache hit without CacheDir")
+	}
+}
+
+func TestPruneCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mkdeb-prune")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	old := strings.Repeat("a", 64)
+	recent := strings.Repeat("b", 64)
+	for _, key := range []string{old, recent} {
+		entry := filepath.Join(dir, key[:2], key)
+		if err := os.MkdirAll(entry, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(entry, cachePackage), []byte("package"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	lastWeek := time.Now().Add(-7 * 24 * time.Hour)
+	if err := os.Chtimes(filepath.Join(dir, old[:2], old), lastWeek, lastWeek); err != nil {
+		t.Fatal(err)
+	}
+	// Other files are left alone
+	if err := os.MkdirAll(filepath.Join(dir, "other"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	removed, freed, err := PruneCache(dir, 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed != 1 || freed != int64(len("package")) {
+		t.Errorf("Expected 1 package of 7 bytes to be removed; found %d of %d bytes", removed, freed)
+	}
+	if FileExists(filepath.Join(dir, old[:2], old)) || !FileExists(filepath.Join(dir, recent[:2], recent)) {
+		t.Errorf("Expected only the old package to be removed")
+	}
+
+	if removed, _, err := PruneCache(dir, 0); err != nil || removed != 1 {
+		t.Errorf("Expected the remaining package to be removed; found %d, %v", removed, err)
+	}
+	if !FileExists(filepath.Join(dir, "other")) {
+		t.Errorf("Expected other files to be left alone")
+	}
+
+	if _, _, err := PruneCache(filepath.Join(dir, "missing"), 0); err != nil {
+		t.Errorf("Expected a missing cache to be empty; found %s", err)
+	}
+}
+
+func TestToolDigest(t *testing.T) {
+	digest, err := toolDigest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reCacheKey.MatchString(digest) {
+		t.Errorf("Expected a sha256 checksum of the executable; found %q", digest)
+	}
+}
@@ -0,0 +1,229 @@
+package deb
+
+import (
+	"encoding/binary"
+	"fmt"
+	"path"
+	"strings"
+	"text/template"
+)
+
+// capabilityXattr is the PAX record for the security.capability extended
+// attribute, as written by GNU tar and bsdtar
+const capabilityXattr = "SCHILY.xattr.security.capability"
+
+// capabilityNames are the Linux capabilities, indexed by their number
+var capabilityNames = []string{
+	"cap_chown",
+	"cap_dac_override",
+	"cap_dac_read_search",
+	"cap_fowner",
+	"cap_fsetid",
+	"cap_kill",
+	"cap_setgid",
+	"cap_setuid",
+	"cap_setpcap",
+	"cap_linux_immutable",
+	"cap_net_bind_service",
+	"cap_net_broadcast",
+	"cap_net_admin",
+	"cap_net_raw",
+	"cap_ipc_lock",
+	"cap_ipc_owner",
+	"cap_sys_module",
+	"cap_sys_rawio",
+	"cap_sys_chroot",
+	"cap_sys_ptrace",
+	"cap_sys_pacct",
+	"cap_sys_admin",
+	"cap_sys_boot",
+	"cap_sys_nice",
+	"cap_sys_resource",
+	"cap_sys_time",
+	"cap_sys_tty_config",
+	"cap_mknod",
+	"cap_lease",
+	"cap_audit_write",
+	"cap_audit_control",
+	"cap_setfcap",
+	"cap_mac_override",
+	"cap_mac_admin",
+	"cap_syslog",
+	"cap_wake_alarm",
+	"cap_block_suspend",
+	"cap_audit_read",
+	"cap_perfmon",
+	"cap_bpf",
+	"cap_checkpoint_restore",
+}
+
+// capabilitySets are the file capability sets, with one bit per capability
+type capabilitySets struct {
+	effective   uint64
+	permitted   uint64
+	inheritable uint64
+}
+
+// parseCapabilities parses capabilities in the text format used by setcap,
+// e.g. "cap_net_bind_service=+ep" or "cap_net_raw,cap_net_admin+ep". See
+// cap_from_text(3) for details.
+func parseCapabilities(text string) (capabilitySets, error) {
+	sets := capabilitySets{}
+	clauses := strings.Fields(text)
+	if len(clauses) == 0 {
+		return sets, fmt.Errorf("expected something like 'cap_net_bind_service=+ep'")
+	}
+	for _, clause := range clauses {
+		i := strings.IndexAny(clause, "=+-")
+		if i < 0 {
+			return sets, fmt.Errorf("expected an operator (=, +, or -) in %q", clause)
+		}
+		caps, err := parseCapabilityList(clause[:i])
+		if err != nil {
+			return sets, err
+		}
+		actions := clause[i:]
+		for len(actions) > 0 {
+			op := actions[0]
+			j := 1
+			for j < len(actions) && strings.IndexByte("eip", actions[j]) >= 0 {
+				j++
+			}
+			flags := actions[1:j]
+			actions = actions[j:]
+			if op != '=' && op != '+' && op != '-' {
+				return sets, fmt.Errorf("unexpected %q in %q; expected flags e, i, or p", op, clause)
+			}
+			if flags == "" && op != '=' {
+				return sets, fmt.Errorf("expected flags e, i, or p after %c in %q", op, clause)
+			}
+			if op == '=' {
+				sets.effective &^= caps
+				sets.permitted &^= caps
+				sets.inheritable &^= caps
+			}
+			for _, flag := range flags {
+				set := &sets.effective
+				switch flag {
+				case 'p':
+					set = &sets.permitted
+				case 'i':
+					set = &sets.inheritable
+				}
+				if op == '-' {
+					*set &^= caps
+				} else {
+					*set |= caps
+				}
+			}
+		}
+	}
+
+	if sets.permitted|sets.inheritable == 0 {
+		return sets, fmt.Errorf("%q does not set any permitted or inheritable capabilities", text)
+	}
+	// The kernel stores a single effective bit for a file
+	if sets.effective != 0 && sets.effective != sets.permitted|sets.inheritable {
+		return sets, fmt.Errorf("effective capabilities in %q must be empty or match the permitted and inheritable capabilities", text)
+	}
+	return sets, nil
+}
+
+// parseCapabilityList parses a comma separated list of capability names. An
+// empty list or "all" is every capability.
+func parseCapabilityList(list string) (uint64, error) {
+	if list == "" || strings.ToLower(list) == "all" {
+		return 1<<uint(len(capabilityNames)) - 1, nil
+	}
+	caps := uint64(0)
+	for _, name := range strings.Split(list, ",") {
+		found := false
+		for i, known := range capabilityNames {
+			if strings.ToLower(name) == known {
+				caps |= 1 << uint(i)
+				found = true
+			}
+		}
+		if !found {
+			return 0, fmt.Errorf("unknown capability %q; expected a name like cap_net_bind_service", name)
+		}
+	}
+	return caps, nil
+}
+
+// xattr returns the security.capability extended attribute for the sets, in
+// the revision 2 format of struct vfs_cap_data
+func (s capabilitySets) xattr() []byte {
+	data := make([]byte, 20)
+	magic := uint32(0x02000000)
+	if s.effective != 0 {
+		magic |= 0x000001
+	}
+	binary.LittleEndian.PutUint32(data[0:], magic)
+	binary.LittleEndian.PutUint32(data[4:], uint32(s.permitted))
+	binary.LittleEndian.PutUint32(data[8:], uint32(s.inheritable))
+	binary.LittleEndian.PutUint32(data[12:], uint32(s.permitted>>32))
+	binary.LittleEndian.PutUint32(data[16:], uint32(s.inheritable>>32))
+	return data
+}
+
+// validateCapabilities checks the paths and syntax of file capabilities
+func (p *PackageSpec) validateCapabilities() error {
+	for target, caps := range p.Capabilities {
+		if !path.IsAbs(target) || path.Clean(target) != target {
+			return fmt.Errorf("Capabilities of %q are invalid; expected a clean absolute path like %q", target, path.Clean("/"+target))
+		}
+		if _, err := parseCapabilities(caps); err != nil {
+			return fmt.Errorf("Capabilities of %q are invalid: %s", target, err)
+		}
+	}
+	return nil
+}
+
+// checkCapabilityFiles checks that the files with capabilities are regular
+// files in the payload
+func (p *PackageSpec) checkCapabilityFiles(payload *payload) error {
+	files := map[string]bool{}
+	for _, file := range payload.files {
+		files["/"+file.target] = file.info.Mode().IsRegular()
+	}
+	for _, target := range sortedKeys(p.Capabilities) {
+		if !files[target] {
+			return fmt.Errorf("Capabilities of %q are invalid; expected a file in the package", target)
+		}
+	}
+	return nil
+}
+
+// capabilitiesScripts renders the postinst fragment that sets the file
+// capabilities. dpkg does not apply extended attributes from the data
+// archive, so they are set with setcap after the files are unpacked. This
+// runs after ownership is changed, since chown clears capabilities. If setcap
+// is not installed the package is still configured, without capabilities.
+func (p *PackageSpec) capabilitiesScripts() (map[string][]byte, error) {
+	// Sort the files so the script is the same every build
+	files := []map[string]string{}
+	for _, target := range sortedKeys(p.Capabilities) {
+		files = append(files, map[string]string{"Path": target, "Capabilities": p.Capabilities[target]})
+	}
+	return renderScriptTemplates(capabilitiesTemplates, len(files) > 0, files)
+}
+
+var capabilitiesTemplates = map[string]*template.Template{
+	"postinst": template.Must(template.New("postinst").Funcs(scriptTemplateFuncs).Parse(capabilitiesPostinstTemplate)),
+}
+
+const capabilitiesPostinstTemplate = `
+# capabilities
+if [ "$1" = "configure" ]; then
+	if command -v setcap >/dev/null; then
+{{- range . }}
+		if [ -f {{ quote .Path }} ] && ! setcap {{ quote .Capabilities }} {{ quote .Path }}; then
+			echo {{ quote (printf "Failed to set capabilities on %s" .Path) }} >&2
+		fi
+{{- end }}
+	else
+		echo 'setcap is not installed; file capabilities were not set' >&2
+	fi
+fi
+`
@@ -0,0 +1,182 @@
+package deb
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+// capabilitiesFixture holds the files for test-fixtures/capabilities.json
+var capabilitiesFixture = fstest.MapFS{
+	"deb-pkg/usr/sbin/food": {Data: []byte("#!/bin/sh\necho food\n"), Mode: 0755},
+	"deb-pkg/usr/bin/ping":  {Data: []byte("#!/bin/sh\necho ping\n"), Mode: 0755},
+}
+
+func TestParseCapabilities(t *testing.T) {
+	// The xattrs written by setcap for the same capabilities
+	valid := map[string]string{
+		"cap_net_bind_service=+ep":                        "0100000200040000000000000000000000000000",
+		"CAP_NET_BIND_SERVICE=ep":                         "0100000200040000000000000000000000000000",
+		"cap_net_raw,cap_bpf+p":                           "0000000200200000000000008000000000000000",
+		"cap_chown+i cap_chown+p":                         "0000000201000000010000000000000000000000",
+		"cap_setfcap,cap_checkpoint_restore=eip":          "0100000200000080000000800001000000010000",
+		"cap_net_raw,cap_net_admin=eip cap_net_admin-eip": "0100000200200000002000000000000000000000",
+	}
+	for text, expected := range valid {
+		sets, err := parseCapabilities(text)
+		if err != nil {
+			t.Errorf("Expected %q to be valid; found %s", text, err)
+			continue
+		}
+		if xattr := hex.EncodeToString(sets.xattr()); xattr != expected {
+			t.Errorf("Expected xattr %s for %q; found %s", expected, text, xattr)
+		}
+	}
+
+	invalid := map[string]string{
+		"":                           "expected something like",
+		"cap_net_bind_service":       "expected an operator",
+		"cap_net_bind_servce+ep":     "unknown capability",
+		"cap_net_raw+":               "expected flags",
+		"cap_net_raw+ex":             "unexpected 'x'",
+		"cap_net_raw=":               "does not set any",
+		"cap_net_raw+p cap_chown+ep": "effective capabilities",
+	}
+	for text, expected := range invalid {
+		_, err := parseCapabilities(text)
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("Expected error containing %q for %q; found %+v", expected, text, err)
+		}
+	}
+}
+
+func TestValidateCapabilities(t *testing.T) {
+	p := PackageSpecFixture(t, "capabilities.json")
+	p.Version = "0.1.0"
+	p.Source = capabilitiesFixture
+	if err := p.Validate(false); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := map[string]map[string]string{
+		"clean absolute path": {"usr/sbin/food": "cap_net_bind_service=+ep"},
+		"unknown capability":  {"/usr/sbin/food": "cap_bind=+ep"},
+	}
+	for expected, caps := range cases {
+		p := PackageSpecFixture(t, "capabilities.json")
+		p.Version = "0.1.0"
+		p.Source = capabilitiesFixture
+		p.Capabilities = caps
+		err := p.Validate(false)
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("Expected error containing %q; found %+v", expected, err)
+		}
+	}
+
+	// Files with capabilities must be in the package
+	p = PackageSpecFixture(t, "capabilities.json")
+	p.Version = "0.1.0"
+	p.Source = capabilitiesFixture
+	p.Capabilities["/usr/sbin/missing"] = "cap_net_raw+ep"
+	if _, err := p.WriteTo(ioutil.Discard); err == nil || !strings.Contains(err.Error(), "expected a file in the package") {
+		t.Errorf("Expected missing file error; found %+v", err)
+	}
+}
+
+func TestCapabilitiesScripts(t *testing.T) {
+	p := PackageSpecFixture(t, "capabilities.json")
+	p.Version = "0.1.0"
+	p.Source = capabilitiesFixture
+
+	scripts, err := p.capabilitiesScripts()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `
+# capabilities
+if [ "$1" = "configure" ]; then
+	if command -v setcap >/dev/null; then
+		if [ -f '/usr/bin/ping' ] && ! setcap 'cap_net_raw+ep' '/usr/bin/ping'; then
+			echo 'Failed to set capabilities on /usr/bin/ping' >&2
+		fi
+		if [ -f '/usr/sbin/food' ] && ! setcap 'cap_net_bind_service=+ep' '/usr/sbin/food'; then
+			echo 'Failed to set capabilities on /usr/sbin/food' >&2
+		fi
+	else
+		echo 'setcap is not installed; file capabilities were not set' >&2
+	fi
+fi
+`
+	if postinst := string(scripts["postinst"]); postinst != expected {
+		t.Errorf("--Expected postinst--\n%s\n--Found--\n%s\n", expected, postinst)
+	}
+
+	// Capabilities are set after ownership is changed, since chown clears them
+	p.Users = []User{{Name: "food", System: true}}
+	p.Owners = map[string]string{"/usr/sbin/food": "food"}
+	all, err := p.RenderControlScripts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	postinst := string(all["postinst"])
+	if strings.Index(postinst, "chown") > strings.Index(postinst, "setcap") {
+		t.Errorf("Expected chown before setcap\n%s", postinst)
+	}
+}
+
+func TestBuildCapabilityXattrs(t *testing.T) {
+	p := PackageSpecFixture(t, "capabilities.json")
+	p.Version = "0.1.0"
+	p.Source = capabilitiesFixture
+	p.CapabilityXattrs = true
+
+	buf := &bytes.Buffer{}
+	if _, err := p.WriteTo(buf); err != nil {
+		t.Fatal(err)
+	}
+	_, members := readArMembers(t, buf.Bytes())
+	headers := readTarHeaders(t, members["data.tar.gz"])
+
+	food := headers["usr/sbin/food"]
+	if xattr := hex.EncodeToString([]byte(food.PAXRecords[capabilityXattr])); xattr != "0100000200040000000000000000000000000000" {
+		t.Errorf("Expected the capability xattr on usr/sbin/food; found %q", xattr)
+	}
+	if _, ok := headers["usr/sbin"].PAXRecords[capabilityXattr]; ok {
+		t.Errorf("Expected no capability xattr on usr/sbin")
+	}
+
+	// Without CapabilityXattrs they are only set by postinst
+	p = PackageSpecFixture(t, "capabilities.json")
+	p.Version = "0.1.0"
+	p.Source = capabilitiesFixture
+	buf2 := &bytes.Buffer{}
+	if _, err := p.WriteTo(buf2); err != nil {
+		t.Fatal(err)
+	}
+	_, members = readArMembers(t, buf2.Bytes())
+	if _, ok := readTarHeaders(t, members["data.tar.gz"])["usr/sbin/food"].PAXRecords[capabilityXattr]; ok {
+		t.Errorf("Expected no capability xattr without CapabilityXattrs")
+	}
+
+	if _, err := exec.LookPath("dpkg-deb"); err == nil {
+		dir, err := ioutil.TempDir("", "mkdeb-caps")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		filename := filepath.Join(dir, p.Filename())
+		if err := ioutil.WriteFile(filename, buf.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if output, err := exec.Command("dpkg-deb", "--contents", filename).CombinedOutput(); err != nil {
+			t.Errorf("dpkg-deb rejected the package: %s\n%s", err, output)
+		}
+	}
+}
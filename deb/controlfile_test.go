@@ -5,6 +5,7 @@ package deb
 
 import (
 	"path"
+	"strings"
 	"testing"
 )
 
@@ -126,3 +127,64 @@ Description: A CLI tool for building debian packages
 		t.Fatalf("Control file did not match expected\n%s\n--Found--\n%s\n", expected, string(buf))
 	}
 }
+
+func TestRenderControlFileDebugSymbols(t *testing.T) {
+	p, err := NewPackageSpecFromFile(path.Join("test-fixtures", "example-basic.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Version = "0.1.0"
+	p.buildIDs = []string{"c8edf12017f8f5a7044137959030d86b03c0e4ac", "0123456789abcdef"}
+
+	expected := `Package: mkdeb-dbgsym
+Version: 0.1.0
+Architecture: amd64
+Maintainer: Chris Bednarski <banzaimonkey@gmail.com>
+Installed-Size: 0
+Depends: mkdeb (= 0.1.0)
+Auto-Built-Package: debug-symbols
+Build-Ids: c8edf12017f8f5a7044137959030d86b03c0e4ac 0123456789abcdef
+Section: debug
+Priority: optional
+Homepage: https://github.com/cbednarski/mkdeb
+Description: debug symbols for mkdeb
+`
+	buf, err := p.debugSymbolsSpec().RenderControlFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(buf) != expected {
+		t.Fatalf("Control file did not match expected\n%s\n--Found--\n%s\n", expected, string(buf))
+	}
+}
+
+func TestRenderControlFileBuildIDs(t *testing.T) {
+	p, err := NewPackageSpecFromFile(path.Join("test-fixtures", "example-basic.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Version = "0.1.0"
+	p.PreDepends = []string{"dpkg (>= 1.17.14)"}
+	p.Conflicts = []string{"mkdeb-legacy"}
+	p.Breaks = []string{"mkdeb-plugins (<< 0.1.0)"}
+	p.Replaces = []string{"mkdeb-legacy"}
+	p.BuildIDs = []string{"c8edf12017f8f5a7044137959030d86b03c0e4ac"}
+
+	buf, err := p.RenderControlFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		"Pre-Depends: dpkg (>= 1.17.14)\n",
+		"Conflicts: mkdeb-legacy\n",
+		"Breaks: mkdeb-plugins (<< 0.1.0)\n",
+		"Replaces: mkdeb-legacy\n",
+		"Auto-Built-Package: debug-symbols\n",
+		"Build-Ids: c8edf12017f8f5a7044137959030d86b03c0e4ac\n",
+	} {
+		if !strings.Contains(string(buf), line) {
+			t.Errorf("Expected %q in the control file\n%s", line, buf)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package deb
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"strings"
+)
+
+var (
+	// debconfFiles are control files used by debconf. Since config is a common
+	// filename these are only picked up from the top level of AutoPath.
+	debconfFiles = []string{"config", "templates"}
+
+	debconfTypes = []string{
+		"string", "password", "boolean", "select", "multiselect", "note", "text", "error", "title",
+	}
+
+	reTemplateField = regexp.MustCompile(`^([A-Za-z][A-Za-z0-9_.-]*):(.*)$`)
+	reTemplateName  = regexp.MustCompile(`^[a-z0-9][a-z0-9.+-]*/[^\s]+$`)
+
+	// Translated fields have a language suffix, e.g. Description-de.UTF-8
+	reTemplateTranslation = regexp.MustCompile(`^(Description|Choices|Default)-[a-zA-Z]{2,3}(_[a-zA-Z]{2})?(\.[a-zA-Z0-9-]+)?$`)
+)
+
+// debconfTemplate is a single stanza in a debconf templates file
+type debconfTemplate struct {
+	line   int
+	fields map[string]string
+}
+
+// isControlFile reports whether a file found in AutoPath is a control file
+// rather than a file to install.
+func (p *PackageSpec) isControlFile(filename string) bool {
+	name := path.Base(filename)
+	if hasString(debconfFiles, name) {
+		return path.Dir(filename) == path.Clean(p.AutoPath)
+	}
+	return hasString(controlFiles, name)
+}
+
+// validateTemplates checks the syntax of the debconf templates file, if there
+// is one.
+func (p *PackageSpec) validateTemplates() error {
+	filename, ok := p.MapControlFiles()["templates"]
+	if !ok {
+		return nil
+	}
+	file, err := p.openFile(filename)
+	if err != nil {
+		return fmt.Errorf("Failed reading templates %q: %s", filename, err)
+	}
+	defer file.Close()
+
+	if _, err := parseTemplates(file); err != nil {
+		return fmt.Errorf("Templates %q are invalid: %s", filename, err)
+	}
+	return nil
+}
+
+// parseTemplates parses and validates debconf templates, as described in
+// debconf-devel(7). Stanzas are separated by blank lines and each one must
+// have a Template, Type, and Description field. Description may continue on
+// following lines that start with a space.
+func parseTemplates(r io.Reader) ([]debconfTemplate, error) {
+	templates := []debconfTemplate{}
+	names := map[string]int{}
+	var current *debconfTemplate
+	var lastField string
+
+	finish := func() error {
+		if current == nil {
+			return nil
+		}
+		t := current
+		current = nil
+		for _, field := range []string{"Template", "Type", "Description"} {
+			if _, ok := t.fields[field]; !ok {
+				return fmt.Errorf("line %d: template is missing the %s field", t.line, field)
+			}
+		}
+		name := t.fields["Template"]
+		if !reTemplateName.MatchString(name) {
+			return fmt.Errorf("line %d: template name %q is invalid; expected something like 'package/question'", t.line, name)
+		}
+		if line, ok := names[name]; ok {
+			return fmt.Errorf("line %d: template %q is already defined on line %d", t.line, name, line)
+		}
+		names[name] = t.line
+		kind := t.fields["Type"]
+		if !hasString(debconfTypes, kind) {
+			return fmt.Errorf("line %d: type %q is invalid; expected one of %s", t.line, kind, strings.Join(debconfTypes, ", "))
+		}
+		if kind == "select" || kind == "multiselect" {
+			if _, ok := t.fields["Choices"]; !ok {
+				if _, ok := t.fields["Choices-C"]; !ok {
+					return fmt.Errorf("line %d: %s template %q is missing the Choices field", t.line, kind, name)
+				}
+			}
+		}
+		templates = append(templates, *t)
+		return nil
+	}
+
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := scanner.Text()
+		switch {
+		case strings.TrimSpace(text) == "":
+			if err := finish(); err != nil {
+				return nil, err
+			}
+		case text[0] == ' ' || text[0] == '\t':
+			if current == nil || !strings.HasPrefix(lastField, "Description") {
+				return nil, fmt.Errorf("line %d: unexpected continuation line; only Description may span several lines", line)
+			}
+			current.fields[lastField] += "\n" + text
+		case text[0] == '#':
+			// Comments are allowed between fields
+		default:
+			match := reTemplateField.FindStringSubmatch(text)
+			if match == nil {
+				return nil, fmt.Errorf("line %d: expected a field like 'Type: string' but found %q", line, text)
+			}
+			field, value := match[1], strings.TrimSpace(match[2])
+			if current == nil {
+				if field != "Template" {
+					return nil, fmt.Errorf("line %d: template must start with a Template field, found %s", line, field)
+				}
+				current = &debconfTemplate{line: line, fields: map[string]string{}}
+			}
+			switch field {
+			case "Template", "Type", "Default", "Choices", "Choices-C", "Description", "Indices":
+			default:
+				if !reTemplateTranslation.MatchString(field) {
+					return nil, fmt.Errorf("line %d: unknown field %q", line, field)
+				}
+			}
+			if _, ok := current.fields[field]; ok {
+				return nil, fmt.Errorf("line %d: duplicate field %s", line, field)
+			}
+			if value == "" && (field == "Template" || field == "Type" || strings.HasPrefix(field, "Description")) {
+				return nil, fmt.Errorf("line %d: %s must not be empty", line, field)
+			}
+			current.fields[field] = value
+			lastField = field
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if err := finish(); err != nil {
+		return nil, err
+	}
+	if len(templates) == 0 {
+		return nil, fmt.Errorf("no templates found")
+	}
+	return templates, nil
+}
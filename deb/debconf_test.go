@@ -0,0 +1,132 @@
+package deb
+
+import (
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestMapControlFilesDebconf(t *testing.T) {
+	p := PackageSpecFixture(t)
+	p.AutoPath = path.Join("test-fixtures", "debconf")
+
+	files := p.MapControlFiles()
+	for _, name := range []string{"config", "templates"} {
+		expected := path.Join("test-fixtures", "debconf", name)
+		if files[name] != expected {
+			t.Errorf("Expected %s to be %q; found %q", name, expected, files[name])
+		}
+	}
+
+	listed, err := p.ListFiles(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{path.Join("test-fixtures", "debconf", "etc", "package1", "config")}
+	if strings.Join(listed, ",") != strings.Join(expected, ",") {
+		t.Errorf("Expected only %+v in the data archive; found %+v", expected, listed)
+	}
+}
+
+func TestParseTemplates(t *testing.T) {
+	p := PackageSpecFixture(t)
+	p.AutoPath = path.Join("test-fixtures", "debconf")
+	p.Version = "1.0"
+	if err := p.Validate(true); err != nil {
+		t.Fatal(err)
+	}
+
+	templates, err := parseTemplates(strings.NewReader(`Template: foo/a
+Type: note
+Description: A note
+ With details.
+ .
+ And more.
+
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(templates) != 1 || templates[0].fields["Description"] != "A note\n With details.\n .\n And more." {
+		t.Errorf("Unexpected templates %+v", templates)
+	}
+
+	cases := map[string]string{
+		"Type: note\nDescription: x\n":                                                                 "line 1: template must start with a Template field",
+		"Template: foo/a\nType: note\n":                                                                "line 1: template is missing the Description field",
+		"Template: foo/a\nType: strange\nDescription: x\n":                                             "type \"strange\" is invalid",
+		"Template: foo/a\nType: select\nDescription: x\n":                                              "missing the Choices field",
+		"Template: foo\nType: note\nDescription: x\n":                                                  "template name \"foo\" is invalid",
+		"Template: foo/a\nType: note\nDefault: x\n more\nDescription: x\n":                             "line 4: unexpected continuation line",
+		"Template: foo/a\nType: note\nColour: red\nDescription: x\n":                                   "line 3: unknown field \"Colour\"",
+		"Template: foo/a\nType: note\nType: text\nDescription: x\n":                                    "line 3: duplicate field Type",
+		"Template: foo/a\nType: note\nDescription-xx_YY_ZZ: x\nDescription: x":                         "unknown field",
+		"Template: foo/a\nType: note\nDescription: x\n\nTemplate: foo/a\nType: note\nDescription: y\n": "line 5: template \"foo/a\" is already defined on line 1",
+		"\n\n": "no templates found",
+	}
+	for templates, expected := range cases {
+		_, err := parseTemplates(strings.NewReader(templates))
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("Expected error containing %q for:\n%s\nfound %+v", expected, templates, err)
+		}
+	}
+}
+
+func TestRenderControlScriptsConfig(t *testing.T) {
+	p := PackageSpecFixture(t)
+	p.AutoPath = path.Join("test-fixtures", "debconf")
+
+	scripts, err := p.RenderControlScripts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(scripts["config"]), "db_input") {
+		t.Errorf("Expected config script; found %+v", scripts)
+	}
+
+	diagnostics, err := p.LintControlScripts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range diagnostics {
+		if d.Script == "config" {
+			t.Errorf("Unexpected diagnostic: %s", d)
+		}
+	}
+}
+
+func TestBuildDebconf(t *testing.T) {
+	p := PackageSpecFixture(t)
+	p.AutoPath = path.Join("test-fixtures", "debconf")
+
+	files := buildArchiveFiles(t, p.CreateControlArchive)
+	for _, name := range []string{"config", "templates"} {
+		if _, ok := files[name]; !ok {
+			t.Errorf("Expected %s in the control archive", name)
+		}
+	}
+}
+
+func TestRenderControlFiles(t *testing.T) {
+	p := PackageSpecFixture(t, "triggers.json")
+	p.AutoPath = path.Join("test-fixtures", "debconf")
+
+	files, err := p.RenderControlFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	modes := map[string]int64{}
+	for _, file := range files {
+		names = append(names, file.Name)
+		modes[file.Name] = file.Mode
+	}
+	if modes["templates"] != 0644 || modes["config"] != 0755 {
+		t.Errorf("Expected templates to be 0644 and scripts 0755; found %v", modes)
+	}
+	// postinst is generated for the trigger
+	expected := "triggers,templates,postinst,config"
+	if strings.Join(names, ",") != expected {
+		t.Errorf("Expected control files %s; found %s", expected, strings.Join(names, ","))
+	}
+}
@@ -0,0 +1,216 @@
+package deb
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// debugPath is where debug symbols are installed, indexed by build id
+	debugPath = "/usr/lib/debug/.build-id"
+
+	// gnuBuildIDNote is the note type used for NT_GNU_BUILD_ID
+	gnuBuildIDNote = 3
+)
+
+// DebugFilename returns the filename of the -dbgsym package created by Build
+// when StripDebug is set, or an empty string if no debug symbols were found.
+func (p *PackageSpec) DebugFilename() string {
+	if len(p.debugFiles) == 0 && !p.cachedDebug {
+		return ""
+	}
+	return p.debugSymbolsSpec().Filename()
+}
+
+// objcopy returns the objcopy program used to strip binaries
+func (p *PackageSpec) objcopy() string {
+	if p.Objcopy != "" {
+		return p.Objcopy
+	}
+	return "objcopy"
+}
+
+// stripBinaries writes stripped copies of every ELF binary with debug info to
+// the workspace. The stripped copies are written to the data archive in place
+// of the originals. Debug info from binaries that have a build id is split
+// into separate files for the -dbgsym package.
+func (p *PackageSpec) stripBinaries(ctx context.Context, workspace string) error {
+	p.stripped = map[string]string{}
+	p.debugFiles = map[string]string{}
+	p.buildIDs = []string{}
+
+	binaries, err := p.listBinaries()
+	if err != nil {
+		return err
+	}
+
+	for _, binary := range binaries {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		fsys, name, err := p.sourceFS(binary.filename)
+		if err != nil {
+			return err
+		}
+		hasDebug, buildID, err := elfDebugInfo(fsys, name)
+		if err != nil {
+			return err
+		}
+		if !hasDebug {
+			continue
+		}
+
+		target, err := p.NormalizeFilename(binary.filename)
+		if err != nil {
+			return err
+		}
+
+		// objcopy needs the binary on the host
+		input := p.hostPath(binary.filename)
+		if p.Source != nil {
+			input = filepath.Join(workspace, "source", filepath.FromSlash(target))
+			if err := p.copyToHost(binary.filename, input); err != nil {
+				return err
+			}
+		}
+		stripped := filepath.Join(workspace, "stripped", filepath.FromSlash(target))
+		if err := os.MkdirAll(filepath.Dir(stripped), 0755); err != nil {
+			return err
+		}
+
+		args := []string{"--strip-debug", "--remove-section=.comment"}
+		if buildID != "" {
+			debugFile := filepath.Join(workspace, "debug", buildID[:2], buildID[2:]+".debug")
+			if err := os.MkdirAll(filepath.Dir(debugFile), 0755); err != nil {
+				return err
+			}
+			if err := p.runObjcopy(ctx, "--only-keep-debug", input, debugFile); err != nil {
+				return err
+			}
+			p.debugFiles[debugFile] = path.Join(debugPath, buildID[:2], buildID[2:]+".debug")
+			p.buildIDs = append(p.buildIDs, buildID)
+			args = append(args, "--add-gnu-debuglink="+debugFile)
+		}
+
+		args = append(args, input, stripped)
+		if err := p.runObjcopy(ctx, args...); err != nil {
+			return err
+		}
+
+		// Make sure the stripped copy has the same mode as the original
+		info, err := p.statFile(binary.filename)
+		if err != nil {
+			return err
+		}
+		if err := os.Chmod(stripped, info.Mode()); err != nil {
+			return err
+		}
+		p.stripped[binary.filename] = stripped
+	}
+	return nil
+}
+
+func (p *PackageSpec) runObjcopy(ctx context.Context, args ...string) error {
+	cmd := exec.CommandContext(ctx, p.objcopy(), args...)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%s %s failed: %s\n%s", p.objcopy(), strings.Join(args, " "), err, output)
+	}
+	return nil
+}
+
+// debugSymbolsSpec creates the spec for the -dbgsym package that contains the
+// debug info split from this package's binaries.
+func (p *PackageSpec) debugSymbolsSpec() *PackageSpec {
+	dbg := DefaultPackageSpec()
+	dbg.Package = p.Package + "-dbgsym"
+	dbg.Version = p.Version
+	dbg.Architecture = p.Architecture
+	dbg.Maintainer = p.Maintainer
+	dbg.Description = "debug symbols for " + p.Package
+	dbg.Homepage = p.Homepage
+	dbg.Section = "debug"
+	dbg.Priority = "optional"
+	dbg.Depends = []string{fmt.Sprintf("%s (= %s)", p.Package, p.Version)}
+	dbg.AutoPath = "-"
+	dbg.TempPath = p.TempPath
+	dbg.BaseDir = p.BaseDir
+	dbg.OnProgress = p.OnProgress
+	dbg.FilenameTemplate = p.FilenameTemplate
+	dbg.BuildIDs = p.buildIDs
+	for src, dest := range p.debugFiles {
+		dbg.Files[src] = dest
+	}
+	return dbg
+}
+
+// contentPath returns the file that should be read for the contents of a file
+// in the package. This is the stripped copy for binaries that were stripped.
+func (p *PackageSpec) contentPath(filename string) string {
+	if stripped, ok := p.stripped[filename]; ok {
+		return stripped
+	}
+	return filename
+}
+
+// elfDebugInfo reports whether the specified ELF file has debug sections and
+// returns its GNU build id as a hex string, if it has one.
+func elfDebugInfo(fsys fs.FS, filename string) (bool, string, error) {
+	file, err := openELF(fsys, filename)
+	if err != nil {
+		return false, "", err
+	}
+	defer file.Close()
+
+	hasDebug := false
+	for _, section := range file.Sections {
+		if strings.HasPrefix(section.Name, ".debug_") || strings.HasPrefix(section.Name, ".zdebug_") {
+			hasDebug = true
+			break
+		}
+	}
+
+	section := file.Section(".note.gnu.build-id")
+	if section == nil {
+		return hasDebug, "", nil
+	}
+	data, err := section.Data()
+	if err != nil {
+		return false, "", fmt.Errorf("Failed to read build id from %q: %s", filename, err)
+	}
+	buildID, err := parseBuildIDNote(data, file.ByteOrder)
+	if err != nil {
+		return false, "", fmt.Errorf("Failed to read build id from %q: %s", filename, err)
+	}
+	return hasDebug, buildID, nil
+}
+
+// parseBuildIDNote extracts the build id from the contents of an ELF note
+// section. Notes are laid out as namesz, descsz, type, followed by the name
+// and descriptor, each padded to 4 bytes.
+func parseBuildIDNote(data []byte, order binary.ByteOrder) (string, error) {
+	for len(data) >= 12 {
+		namesz := int(order.Uint32(data[0:4]))
+		descsz := int(order.Uint32(data[4:8]))
+		noteType := order.Uint32(data[8:12])
+		nameEnd := 12 + (namesz+3)&^3
+		descEnd := nameEnd + (descsz+3)&^3
+		if descEnd > len(data) || nameEnd+descsz > len(data) {
+			return "", fmt.Errorf("truncated note")
+		}
+		name := bytes.TrimRight(data[12:12+namesz], "\x00")
+		if noteType == gnuBuildIDNote && string(name) == "GNU" && descsz >= 2 {
+			return hex.EncodeToString(data[nameEnd : nameEnd+descsz]), nil
+		}
+		data = data[descEnd:]
+	}
+	return "", nil
+}
@@ -0,0 +1,113 @@
+package deb
+
+import (
+	"context"
+	"debug/elf"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"testing"
+)
+
+const fixtureBuildID = "c8edf12017f8f5a7044137959030d86b03c0e4ac"
+
+func TestELFDebugInfo(t *testing.T) {
+	hasDebug, buildID, err := elfDebugInfo(hostFS{}, path.Join("test-fixtures", "package2", "usr", "bin", "hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !hasDebug {
+		t.Errorf("Expected fixture to have debug info")
+	}
+	if buildID != fixtureBuildID {
+		t.Errorf("Expected %q got %q", fixtureBuildID, buildID)
+	}
+}
+
+func TestStripBinaries(t *testing.T) {
+	if _, err := exec.LookPath("objcopy"); err != nil {
+		t.Skip("objcopy is not installed")
+	}
+	p := PackageSpecFixture(t)
+	p.Version = "0.1.0"
+	p.AutoPath = path.Join("test-fixtures", "package2")
+	p.StripDebug = true
+
+	ws, err := ioutil.TempDir("", "mkdeb-strip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(ws)
+
+	if err := p.stripBinaries(context.Background(), ws); err != nil {
+		t.Fatal(err)
+	}
+
+	source := path.Join("test-fixtures", "package2", "usr", "bin", "hello")
+	stripped := p.contentPath(source)
+	if stripped == source {
+		t.Fatalf("Expected %q to be stripped", source)
+	}
+	hasDebug, buildID, err := elfDebugInfo(hostFS{}, stripped)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hasDebug {
+		t.Errorf("Expected debug info to be removed from %q", stripped)
+	}
+	if buildID != fixtureBuildID {
+		t.Errorf("Expected stripped binary to keep build id %q, got %q", fixtureBuildID, buildID)
+	}
+
+	expected := "/usr/lib/debug/.build-id/c8/edf12017f8f5a7044137959030d86b03c0e4ac.debug"
+	found := false
+	for src, dest := range p.debugFiles {
+		if dest != expected {
+			continue
+		}
+		found = true
+		file, err := elf.Open(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if file.Section(".debug_info") == nil {
+			t.Errorf("Expected %q to contain debug info", src)
+		}
+		file.Close()
+	}
+	if !found {
+		t.Errorf("Expected debug file for %q in %+v", expected, p.debugFiles)
+	}
+}
+
+func TestBuildDebugSymbols(t *testing.T) {
+	if _, err := exec.LookPath("objcopy"); err != nil {
+		t.Skip("objcopy is not installed")
+	}
+	p := PackageSpecFixture(t)
+	p.Version = "0.1.0"
+	p.AutoPath = path.Join("test-fixtures", "package2")
+	p.StripDebug = true
+
+	target, err := ioutil.TempDir("", "mkdeb-dbgsym")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(target)
+
+	if err := p.Build(target); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "mkdeb-dbgsym_0.1.0_amd64.deb"
+	if p.DebugFilename() != expected {
+		t.Errorf("Expected %q got %q", expected, p.DebugFilename())
+	}
+	for _, filename := range []string{p.Filename(), expected} {
+		if !FileExists(filepath.Join(target, filename)) {
+			t.Errorf("Expected %q to be built", filename)
+		}
+	}
+}
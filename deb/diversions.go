@@ -0,0 +1,87 @@
+package deb
+
+import (
+	"fmt"
+	"path"
+	"text/template"
+)
+
+// Diversion diverts a file owned by another package, so this package can
+// install its own version in the same place. See dpkg-divert(1) for details.
+//
+// Path is the file to divert. The original is renamed to DivertTo, which
+// defaults to Path with a .distrib suffix.
+//
+// Since is the version of this package that introduced the diversion. If an
+// upgrade from an older version is aborted the diversion is removed again. It
+// may be left empty if the diversion has been there from the first version.
+type Diversion struct {
+	Path     string `json:"path"`
+	DivertTo string `json:"divertTo,omitempty"`
+	Since    string `json:"since,omitempty"`
+}
+
+// Target returns the path the original file is diverted to
+func (d Diversion) Target() string {
+	if d.DivertTo != "" {
+		return d.DivertTo
+	}
+	return d.Path + ".distrib"
+}
+
+// validateDiversions checks that diverted paths are absolute and unique
+func (p *PackageSpec) validateDiversions() error {
+	seen := map[string]bool{}
+	for _, d := range p.Diversions {
+		if !path.IsAbs(d.Path) || path.Clean(d.Path) != d.Path {
+			return fmt.Errorf("Diversion of %q is invalid; expected a clean absolute path like %q", d.Path, path.Clean("/"+d.Path))
+		}
+		if !path.IsAbs(d.Target()) || path.Clean(d.Target()) == d.Path {
+			return fmt.Errorf("Diversion of %q is invalid; divertTo must be a different absolute path", d.Path)
+		}
+		if seen[d.Path] {
+			return fmt.Errorf("Diversion of %q is specified more than once", d.Path)
+		}
+		seen[d.Path] = true
+	}
+	return nil
+}
+
+// diversionsScripts renders the maintainer script fragments that add and
+// remove the diversions.
+//
+// Diversions are added by preinst so they are in place before the package's
+// files are unpacked. dpkg-divert --add does nothing if the diversion already
+// exists, so this is safe on upgrades. They are removed by postrm when the
+// package is removed, when a fresh install is aborted, when the package
+// disappears, and when an upgrade from a version before Since is aborted.
+func (p *PackageSpec) diversionsScripts() (map[string][]byte, error) {
+	return renderScriptTemplates(diversionsTemplates, len(p.Diversions) > 0, map[string]interface{}{
+		"Package":    p.Package,
+		"Diversions": p.Diversions,
+	})
+}
+
+var diversionsTemplates = map[string]*template.Template{
+	"preinst": template.Must(template.New("preinst").Funcs(scriptTemplateFuncs).Parse(diversionsPreinstTemplate)),
+	"postrm":  template.Must(template.New("postrm").Funcs(scriptTemplateFuncs).Parse(diversionsPostrmTemplate)),
+}
+
+const diversionsPreinstTemplate = `
+# diversions
+if [ "$1" = "install" ] || [ "$1" = "upgrade" ] ; then
+{{- range .Diversions }}
+	dpkg-divert --package {{ quote $.Package }} --add --rename --divert {{ quote .Target }} {{ quote .Path }} >/dev/null
+{{- end }}
+fi
+`
+
+const diversionsPostrmTemplate = `
+# diversions
+{{- range .Diversions }}
+if [ "$1" = "remove" ] || [ "$1" = "abort-install" ] || [ "$1" = "disappear" ]{{ if .Since }} ||
+	{ [ "$1" = "abort-upgrade" ] && dpkg --compare-versions "$2" lt-nl {{ quote .Since }} ; }{{ end }} ; then
+	dpkg-divert --package {{ quote $.Package }} --remove --rename --divert {{ quote .Target }} {{ quote .Path }} >/dev/null
+fi
+{{- end }}
+`
@@ -0,0 +1,69 @@
+package deb
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateDiversions(t *testing.T) {
+	p := PackageSpecFixture(t)
+	p.Diversions = []Diversion{{Path: "/etc/package1/config"}}
+	if err := p.Validate(false); err != nil {
+		t.Fatal(err)
+	}
+	if target := p.Diversions[0].Target(); target != "/etc/package1/config.distrib" {
+		t.Errorf("Expected default target; found %q", target)
+	}
+
+	cases := map[string][]Diversion{
+		"clean absolute path":     {{Path: "etc/foo"}},
+		"different absolute path": {{Path: "/etc/foo", DivertTo: "/etc/foo"}},
+		"more than once":          {{Path: "/etc/foo"}, {Path: "/etc/foo", DivertTo: "/etc/foo.orig"}},
+	}
+	for expected, diversions := range cases {
+		p := PackageSpecFixture(t)
+		p.Diversions = diversions
+		err := p.Validate(false)
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("Expected error containing %q; found %+v", expected, err)
+		}
+	}
+}
+
+func TestDiversionsScripts(t *testing.T) {
+	p := PackageSpecFixture(t)
+	p.Diversions = []Diversion{
+		{Path: "/etc/package1/config"},
+		{Path: "/usr/bin/tool", DivertTo: "/usr/bin/tool.real", Since: "1.2.0"},
+	}
+
+	scripts, err := p.diversionsScripts()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `
+# diversions
+if [ "$1" = "install" ] || [ "$1" = "upgrade" ] ; then
+	dpkg-divert --package 'mkdeb' --add --rename --divert '/etc/package1/config.distrib' '/etc/package1/config' >/dev/null
+	dpkg-divert --package 'mkdeb' --add --rename --divert '/usr/bin/tool.real' '/usr/bin/tool' >/dev/null
+fi
+`
+	if preinst := string(scripts["preinst"]); preinst != expected {
+		t.Errorf("--Expected preinst--\n%s\n--Found--\n%s\n", expected, preinst)
+	}
+
+	expected = `
+# diversions
+if [ "$1" = "remove" ] || [ "$1" = "abort-install" ] || [ "$1" = "disappear" ] ; then
+	dpkg-divert --package 'mkdeb' --remove --rename --divert '/etc/package1/config.distrib' '/etc/package1/config' >/dev/null
+fi
+if [ "$1" = "remove" ] || [ "$1" = "abort-install" ] || [ "$1" = "disappear" ] ||
+	{ [ "$1" = "abort-upgrade" ] && dpkg --compare-versions "$2" lt-nl '1.2.0' ; } ; then
+	dpkg-divert --package 'mkdeb' --remove --rename --divert '/usr/bin/tool.real' '/usr/bin/tool' >/dev/null
+fi
+`
+	if postrm := string(scripts["postrm"]); postrm != expected {
+		t.Errorf("--Expected postrm--\n%s\n--Found--\n%s\n", expected, postrm)
+	}
+}
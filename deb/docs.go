@@ -0,0 +1,417 @@
+package deb
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+)
+
+const (
+	// docPath is where package documentation is installed
+	docPath = "/usr/share/doc"
+
+	// dep5Format identifies machine-readable copyright files
+	dep5Format = "https://www.debian.org/doc/packaging-manuals/copyright-format/1.0/"
+)
+
+var (
+	changelogUrgencies = []string{"low", "medium", "high", "emergency", "critical"}
+
+	// changelogDateFormats are accepted for ChangelogEntry.Date. Changelogs are
+	// written using the first one.
+	changelogDateFormats = []string{time.RFC1123Z, "2006-01-02T15:04:05Z07:00", "2006-01-02"}
+)
+
+// Copyright describes the copyright file installed as
+// /usr/share/doc/<package>/copyright. Either File is the path to an existing
+// copyright file, or the remaining fields are used to write a machine-readable
+// (DEP-5) copyright file.
+//
+// UpstreamName defaults to the package name. Files lists the copyright and
+// license of groups of files, and Licenses contains the full text of the
+// licenses they refer to.
+type Copyright struct {
+	File            string             `json:"file,omitempty"`
+	UpstreamName    string             `json:"upstreamName,omitempty"`
+	UpstreamContact string             `json:"upstreamContact,omitempty"`
+	Source          string             `json:"source,omitempty"`
+	Files           []CopyrightFiles   `json:"files,omitempty"`
+	Licenses        []CopyrightLicense `json:"licenses,omitempty"`
+}
+
+// CopyrightFiles is a files paragraph in a DEP-5 copyright file. Files are
+// glob patterns, e.g. "*".
+type CopyrightFiles struct {
+	Files     []string `json:"files"`
+	Copyright []string `json:"copyright"`
+	License   string   `json:"license"`
+}
+
+// CopyrightLicense is a standalone license paragraph. The license text is
+// either given as Text or read from File.
+type CopyrightLicense struct {
+	Name string `json:"name"`
+	Text string `json:"text,omitempty"`
+	File string `json:"file,omitempty"`
+}
+
+// Changelog describes the changelog installed as
+// /usr/share/doc/<package>/changelog.Debian.gz. Either File is the path to an
+// existing changelog in debian/changelog format, or it is written from Entries,
+// newest first. If Git is set an entry for the version being built is
+// generated from the git history and added before Entries.
+type Changelog struct {
+	File    string           `json:"file,omitempty"`
+	Entries []ChangelogEntry `json:"entries,omitempty"`
+	Git     *ChangelogGit    `json:"git,omitempty"`
+}
+
+// ChangelogGit generates a changelog entry from the commits in From..To of the
+// git repository containing Repo, as in git log From..To. From is usually the
+// tag of the previous release; if it is empty all of the history is used. To
+// defaults to HEAD and Repo to the current directory.
+type ChangelogGit struct {
+	From string `json:"from,omitempty"`
+	To   string `json:"to,omitempty"`
+	Repo string `json:"repo,omitempty"`
+}
+
+// ChangelogEntry is a single version in a Debian changelog. Distribution
+// defaults to "unstable", Urgency to "medium", and Maintainer to the package
+// maintainer. Date is required so the changelog is the same every build, and
+// may be in RFC 2822 format or YYYY-MM-DD.
+type ChangelogEntry struct {
+	Version      string   `json:"version"`
+	Distribution string   `json:"distribution,omitempty"`
+	Urgency      string   `json:"urgency,omitempty"`
+	Maintainer   string   `json:"maintainer,omitempty"`
+	Date         string   `json:"date"`
+	Changes      []string `json:"changes"`
+}
+
+// validateDocs checks the copyright and changelog options
+func (p *PackageSpec) validateDocs() error {
+	if c := p.Copyright; c != nil {
+		structured := c.UpstreamName != "" || c.UpstreamContact != "" || c.Source != "" ||
+			len(c.Files) > 0 || len(c.Licenses) > 0
+		if c.File != "" && structured {
+			return fmt.Errorf("Copyright must be either a file or DEP-5 fields, not both")
+		}
+		if c.File == "" && len(c.Files) == 0 {
+			return fmt.Errorf("Copyright must have a file or at least one entry in files")
+		}
+		for _, files := range c.Files {
+			if len(files.Files) == 0 || len(files.Copyright) == 0 || files.License == "" {
+				return fmt.Errorf("Copyright files entries must have files, copyright, and license")
+			}
+		}
+		for _, license := range c.Licenses {
+			if license.Name == "" || (license.Text == "") == (license.File == "") {
+				return fmt.Errorf("Copyright license %q must have a name and exactly one of text or file", license.Name)
+			}
+		}
+	}
+	if c := p.Changelog; c != nil {
+		if (c.File == "") == (len(c.Entries) == 0 && c.Git == nil) {
+			return fmt.Errorf("Changelog must have exactly one of file or entries and git")
+		}
+		for _, entry := range c.Entries {
+			if entry.Version == "" {
+				return fmt.Errorf("Changelog entry is missing the version")
+			}
+			if len(entry.Changes) == 0 {
+				return fmt.Errorf("Changelog entry for %s has no changes", entry.Version)
+			}
+			if entry.Urgency != "" && !hasString(changelogUrgencies, entry.Urgency) {
+				return fmt.Errorf("Changelog urgency %q for %s is invalid; expected one of %s",
+					entry.Urgency, entry.Version, strings.Join(changelogUrgencies, ", "))
+			}
+			if _, err := parseChangelogDate(entry.Date); err != nil {
+				return fmt.Errorf("Changelog date %q for %s is invalid; expected something like %q",
+					entry.Date, entry.Version, changelogDateFormats[0])
+			}
+		}
+	}
+	return nil
+}
+
+// RenderCopyright returns the contents of the copyright file, or nil if the
+// package does not specify one.
+func (p *PackageSpec) RenderCopyright() ([]byte, error) {
+	c := p.Copyright
+	if c == nil {
+		return nil, nil
+	}
+	if c.File != "" {
+		data, err := p.readFile(c.File)
+		if err != nil {
+			return nil, fmt.Errorf("Failed reading copyright %q: %s", c.File, err)
+		}
+		return data, nil
+	}
+
+	licenses := []map[string]string{}
+	for _, license := range c.Licenses {
+		text := license.Text
+		if license.File != "" {
+			data, err := p.readFile(license.File)
+			if err != nil {
+				return nil, fmt.Errorf("Failed reading license %q: %s", license.File, err)
+			}
+			text = string(data)
+		}
+		licenses = append(licenses, map[string]string{"Name": license.Name, "Text": text})
+	}
+
+	upstreamName := c.UpstreamName
+	if upstreamName == "" {
+		upstreamName = p.Package
+	}
+
+	buf := &bytes.Buffer{}
+	err := copyrightTemplate.Execute(buf, map[string]interface{}{
+		"Format":          dep5Format,
+		"UpstreamName":    upstreamName,
+		"UpstreamContact": c.UpstreamContact,
+		"Source":          c.Source,
+		"Files":           c.Files,
+		"Licenses":        licenses,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// RenderChangelog returns the uncompressed contents of the Debian changelog, or
+// nil if the package does not specify one.
+func (p *PackageSpec) RenderChangelog() ([]byte, error) {
+	c := p.Changelog
+	if c == nil {
+		return nil, nil
+	}
+	if c.File != "" {
+		data, err := p.readFile(c.File)
+		if err != nil {
+			return nil, fmt.Errorf("Failed reading changelog %q: %s", c.File, err)
+		}
+		return data, nil
+	}
+
+	changes := c.Entries
+	if c.Git != nil {
+		entry, err := p.GitChangelogEntry(p.hostPath(c.Git.Repo), c.Git.From, c.Git.To)
+		if err != nil {
+			return nil, err
+		}
+		changes = append([]ChangelogEntry{entry}, changes...)
+	}
+
+	entries := []map[string]interface{}{}
+	for _, entry := range changes {
+		date, err := parseChangelogDate(entry.Date)
+		if err != nil {
+			return nil, err
+		}
+		distribution, urgency, maintainer := entry.Distribution, entry.Urgency, entry.Maintainer
+		if distribution == "" {
+			distribution = "unstable"
+		}
+		if urgency == "" {
+			urgency = "medium"
+		}
+		if maintainer == "" {
+			maintainer = p.Maintainer
+		}
+		entries = append(entries, map[string]interface{}{
+			"Version":      entry.Version,
+			"Distribution": distribution,
+			"Urgency":      urgency,
+			"Maintainer":   maintainer,
+			"Date":         date.Format(changelogDateFormats[0]),
+			"Changes":      entry.Changes,
+		})
+	}
+
+	buf := &bytes.Buffer{}
+	err := changelogTemplate.Execute(buf, map[string]interface{}{
+		"Package": p.Package,
+		"Entries": entries,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// GitChangelogEntry creates a changelog entry for the package version from the
+// subjects of the commits in from..to in the git repository containing dir.
+// Merge commits are skipped. The entry is dated with the time of the newest
+// commit, so it is the same every time it is generated.
+func (p *PackageSpec) GitChangelogEntry(dir, from, to string) (ChangelogEntry, error) {
+	if p.Version == "" {
+		return ChangelogEntry{}, fmt.Errorf("Version is required to generate a changelog entry")
+	}
+	if dir == "" {
+		dir = "."
+	}
+	if to == "" {
+		to = "HEAD"
+	}
+	repo, err := openGitRepo(dir)
+	if err != nil {
+		return ChangelogEntry{}, err
+	}
+	commits, err := repo.log(from, to)
+	if err != nil {
+		return ChangelogEntry{}, fmt.Errorf("Failed to read git history: %s", err)
+	}
+
+	entry := ChangelogEntry{Version: p.Version}
+	for _, commit := range commits {
+		if len(commit.parents) > 1 || commit.subject() == "" {
+			continue
+		}
+		entry.Changes = append(entry.Changes, commit.subject())
+	}
+	if len(entry.Changes) == 0 {
+		return ChangelogEntry{}, fmt.Errorf("No commits found in %s..%s", from, to)
+	}
+	entry.Date = commits[0].committed.Format(changelogDateFormats[0])
+	return entry, nil
+}
+
+// writeDocs writes the copyright file and compressed changelog into the
+// workspace so they can be added to the package.
+func (p *PackageSpec) writeDocs(workspace string) error {
+	copyright, err := p.RenderCopyright()
+	if err != nil {
+		return err
+	}
+	if copyright != nil {
+		// Policy requires the copyright file to be uncompressed
+		if err := p.writeDocFile(workspace, "copyright", copyright); err != nil {
+			return err
+		}
+	}
+
+	changelog, err := p.RenderChangelog()
+	if err != nil {
+		return err
+	}
+	if changelog != nil {
+		compressed, err := gzipDeterministic(changelog)
+		if err != nil {
+			return err
+		}
+		if err := p.writeDocFile(workspace, "changelog.Debian.gz", compressed); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *PackageSpec) writeDocFile(workspace, name string, data []byte) error {
+	filename := filepath.Join(workspace, "generated", "doc", name)
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filename, data, 0644); err != nil {
+		return err
+	}
+	p.addGeneratedFile(filename, path.Join(docPath, p.Package, name))
+	return nil
+}
+
+// gzipDeterministic compresses data like gzip -9n, without a filename or
+// timestamp in the header, so the output only depends on the input.
+func gzipDeterministic(data []byte) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	writer, err := gzip.NewWriterLevel(buf, gzip.BestCompression)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := writer.Write(data); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func parseChangelogDate(date string) (time.Time, error) {
+	var err error
+	for _, format := range changelogDateFormats {
+		var t time.Time
+		if t, err = time.Parse(format, date); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, err
+}
+
+// indent formats text as the continuation lines of a control file field. Empty
+// lines are written as " ." as required by the format.
+func indent(text string) string {
+	lines := strings.Split(strings.Trim(text, "\n"), "\n")
+	for i, line := range lines {
+		if strings.TrimSpace(line) == "" {
+			lines[i] = " ."
+		} else {
+			lines[i] = " " + line
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+var copyrightTemplate = template.Must(template.New("copyright").Funcs(template.FuncMap{
+	"join":   strings.Join,
+	"indent": indent,
+}).Parse(`Format: {{ .Format }}
+Upstream-Name: {{ .UpstreamName }}
+{{- if .UpstreamContact }}
+Upstream-Contact: {{ .UpstreamContact }}
+{{- end }}
+{{- if .Source }}
+Source: {{ .Source }}
+{{- end }}
+{{ range .Files }}
+Files: {{ join .Files " " }}
+Copyright: {{ join .Copyright "\n           " }}
+License: {{ .License }}
+{{ end }}
+{{- range .Licenses }}
+License: {{ .Name }}
+{{ indent .Text }}
+{{ end -}}
+`))
+
+// changelogItem formats a change as a bullet point, indenting any following
+// lines to line up with the first
+func changelogItem(change string) string {
+	return "  * " + strings.Replace(strings.TrimSpace(change), "\n", "\n    ", -1)
+}
+
+var changelogTemplate = template.Must(template.New("changelog").Funcs(template.FuncMap{
+	"item": changelogItem,
+}).Parse(`
+{{- $package := .Package }}
+{{- range $i, $entry := .Entries }}
+{{- if $i }}
+{{ end -}}
+{{ $package }} ({{ .Version }}) {{ .Distribution }}; urgency={{ .Urgency }}
+{{ range .Changes }}
+{{ item . }}
+{{- end }}
+
+ -- {{ .Maintainer }}  {{ .Date }}
+{{ end -}}
+`))
@@ -0,0 +1,182 @@
+package deb
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValidateDocs(t *testing.T) {
+	p := PackageSpecFixture(t, "docs.json")
+	if err := p.Validate(false); err != nil {
+		t.Fatal(err)
+	}
+
+	copyrights := map[string]*Copyright{
+		"not both":                {File: "LICENSE", Source: "https://example.com"},
+		"at least one entry":      {Source: "https://example.com"},
+		"files, copyright":        {Files: []CopyrightFiles{{Files: []string{"*"}, License: "MIT"}}},
+		"exactly one of text or ": {Files: []CopyrightFiles{{Files: []string{"*"}, Copyright: []string{"me"}, License: "MIT"}}, Licenses: []CopyrightLicense{{Name: "MIT"}}},
+	}
+	for expected, copyright := range copyrights {
+		p := PackageSpecFixture(t)
+		p.Copyright = copyright
+		err := p.Validate(false)
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("Expected error containing %q; found %+v", expected, err)
+		}
+	}
+
+	changelogs := map[string]*Changelog{
+		"exactly one of file":      {},
+		"missing the version":      {Entries: []ChangelogEntry{{Date: "2017-01-01", Changes: []string{"x"}}}},
+		"has no changes":           {Entries: []ChangelogEntry{{Version: "1.0", Date: "2017-01-01"}}},
+		"urgency \"urgent\"":       {Entries: []ChangelogEntry{{Version: "1.0", Urgency: "urgent", Date: "2017-01-01", Changes: []string{"x"}}}},
+		"date \"yesterday\" for 1": {Entries: []ChangelogEntry{{Version: "1.0", Date: "yesterday", Changes: []string{"x"}}}},
+	}
+	for expected, changelog := range changelogs {
+		p := PackageSpecFixture(t)
+		p.Changelog = changelog
+		err := p.Validate(false)
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("Expected error containing %q; found %+v", expected, err)
+		}
+	}
+}
+
+func TestRenderCopyright(t *testing.T) {
+	p := PackageSpecFixture(t, "docs.json")
+
+	copyright, err := p.RenderCopyright()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `Format: https://www.debian.org/doc/packaging-manuals/copyright-format/1.0/
+Upstream-Name: mkdeb
+Upstream-Contact: Chris Bednarski <banzaimonkey@gmail.com>
+Source: https://github.com/cbednarski/mkdeb
+
+Files: *
+Copyright: 2016 Chris Bednarski
+           2017 Contributors
+License: MIT
+
+License: MIT
+ Permission is hereby granted...
+ .
+ THE SOFTWARE IS PROVIDED "AS IS"
+`
+	if string(copyright) != expected {
+		t.Errorf("--Expected--\n%s\n--Found--\n%s\n", expected, copyright)
+	}
+}
+
+func TestRenderChangelog(t *testing.T) {
+	p := PackageSpecFixture(t, "docs.json")
+
+	changelog, err := p.RenderChangelog()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `mkdeb (1.1) unstable; urgency=medium
+
+  * Add a feature
+  * Fix a bug that was
+    hard to describe
+
+ -- Chris Bednarski <banzaimonkey@gmail.com>  Fri, 03 Feb 2017 00:00:00 +0000
+
+mkdeb (1.0) stable; urgency=low
+
+  * Initial release
+
+ -- Chris Bednarski <banzaimonkey@gmail.com>  Wed, 01 Feb 2017 10:30:00 -0800
+`
+	if string(changelog) != expected {
+		t.Errorf("--Expected--\n%s\n--Found--\n%s\n", expected, changelog)
+	}
+}
+
+func TestWriteDocs(t *testing.T) {
+	workspace, err := ioutil.TempDir("", "mkdeb-docs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(workspace)
+
+	p := PackageSpecFixture(t, "docs.json")
+	if err := p.writeGeneratedFiles(workspace); err != nil {
+		t.Fatal(err)
+	}
+
+	targets := map[string]string{}
+	for src, dest := range p.installedFiles() {
+		targets[dest] = src
+	}
+	if _, ok := targets["/usr/share/doc/mkdeb/copyright"]; !ok {
+		t.Errorf("copyright is missing: %+v", targets)
+	}
+	changelog, ok := targets["/usr/share/doc/mkdeb/changelog.Debian.gz"]
+	if !ok {
+		t.Fatalf("changelog.Debian.gz is missing: %+v", targets)
+	}
+
+	compressed, err := ioutil.ReadFile(changelog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reader.Name != "" || !reader.ModTime.IsZero() {
+		t.Errorf("Expected no name or timestamp in gzip header; found %q %s", reader.Name, reader.ModTime)
+	}
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(data), "mkdeb (1.1)") {
+		t.Errorf("Unexpected changelog:\n%s", data)
+	}
+
+	// Building twice gives the same output
+	again := filepath.Join(workspace, "again")
+	if err := p.writeDocs(again); err != nil {
+		t.Fatal(err)
+	}
+	second, err := ioutil.ReadFile(filepath.Join(again, "generated", "doc", "changelog.Debian.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(compressed, second) {
+		t.Errorf("Expected changelog.Debian.gz to be deterministic")
+	}
+}
+
+func TestBuildDocs(t *testing.T) {
+	p := PackageSpecFixture(t, "docs.json")
+	p.Version = "1.1"
+
+	workspace, err := ioutil.TempDir("", "mkdeb-docs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(workspace)
+	if err := p.writeGeneratedFiles(workspace); err != nil {
+		t.Fatal(err)
+	}
+
+	files := buildArchiveFiles(t, p.CreateDataArchive)
+	for _, name := range []string{"usr/share/doc/mkdeb/copyright", "usr/share/doc/mkdeb/changelog.Debian.gz"} {
+		if _, ok := files[name]; !ok {
+			t.Errorf("Expected %s in the data archive", name)
+		}
+	}
+}
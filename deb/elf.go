@@ -0,0 +1,225 @@
+package deb
+
+import (
+	"bytes"
+	"debug/elf"
+	"fmt"
+	"io"
+	"io/fs"
+	"strings"
+)
+
+const (
+	// autoArchitecture asks Build to detect Architecture from ELF binaries
+	autoArchitecture = "auto"
+
+	// ARM EABI float ABI flags from e_flags. These are not exposed by
+	// debug/elf so we read them from the header ourselves.
+	elfARMABIFloatSoft = 0x200
+	elfARMABIFloatHard = 0x400
+)
+
+// elfBinary is an ELF file found in the package payload along with the debian
+// architectures it is able to run on.
+type elfBinary struct {
+	filename      string
+	architectures []string
+}
+
+// DetectArchitecture inspects every ELF binary in the package and returns the
+// debian architecture they were compiled for. Packages that do not contain any
+// ELF binaries are detected as "all".
+func (p *PackageSpec) DetectArchitecture() (string, error) {
+	binaries, err := p.listBinaries()
+	if err != nil {
+		return "", err
+	}
+	if len(binaries) == 0 {
+		return "all", nil
+	}
+	archs, err := commonArchitectures(binaries)
+	if err != nil {
+		return "", err
+	}
+	if len(archs) > 1 {
+		return "", fmt.Errorf("Unable to tell which of %s binaries like %q are built for; please specify architecture",
+			strings.Join(archs, ", "), binaries[0].filename)
+	}
+	return archs[0], nil
+}
+
+// ResolveArchitecture replaces an Architecture of "auto" with the architecture
+// detected from the binaries in the package. Otherwise it does nothing.
+func (p *PackageSpec) ResolveArchitecture() error {
+	if p.Architecture != autoArchitecture {
+		return nil
+	}
+	arch, err := p.DetectArchitecture()
+	if err != nil {
+		return err
+	}
+	p.Architecture = arch
+	return nil
+}
+
+// verifyArchitecture checks that every ELF binary in the package agrees with
+// the other binaries and with Architecture.
+func (p *PackageSpec) verifyArchitecture() error {
+	binaries, err := p.listBinaries()
+	if err != nil {
+		return err
+	}
+	if len(binaries) == 0 {
+		return nil
+	}
+	archs, err := commonArchitectures(binaries)
+	if err != nil {
+		return err
+	}
+	if !hasString(archs, p.Architecture) {
+		return fmt.Errorf("Arch is %q but binaries like %q are built for %s",
+			p.Architecture, binaries[0].filename, strings.Join(archs, " or "))
+	}
+	return nil
+}
+
+// listBinaries returns all of the ELF files that will be included in the
+// archive. Other files are ignored.
+func (p *PackageSpec) listBinaries() ([]elfBinary, error) {
+	files, err := p.ListFiles(false)
+	if err != nil {
+		return nil, err
+	}
+	binaries := []elfBinary{}
+	for _, file := range files {
+		fsys, name, err := p.sourceFS(file)
+		if err != nil {
+			return nil, err
+		}
+		archs, err := elfArchitectures(fsys, name)
+		if err != nil {
+			return nil, err
+		}
+		if archs != nil {
+			binaries = append(binaries, elfBinary{filename: file, architectures: archs})
+		}
+	}
+	return binaries, nil
+}
+
+// commonArchitectures returns the architectures that all of the binaries can
+// run on. It is an error if there are none.
+func commonArchitectures(binaries []elfBinary) ([]string, error) {
+	common := binaries[0].architectures
+	for _, binary := range binaries[1:] {
+		next := []string{}
+		for _, arch := range common {
+			if hasString(binary.architectures, arch) {
+				next = append(next, arch)
+			}
+		}
+		common = next
+	}
+	if len(common) == 0 {
+		found := []string{}
+		for _, binary := range binaries {
+			found = append(found, fmt.Sprintf("%s (%s)", binary.filename, strings.Join(binary.architectures, " or ")))
+		}
+		return nil, fmt.Errorf("Binaries are built for different architectures: %s", strings.Join(found, ", "))
+	}
+	return common, nil
+}
+
+// elfArchitectures returns the debian architectures the specified ELF file can
+// run on, or nil if the file is not an ELF file. Most binaries map to a single
+// architecture, but ARM binaries that do not declare a float ABI may run on
+// both armel and armhf.
+func elfArchitectures(fsys fs.FS, filename string) ([]string, error) {
+	file, closer, err := openReaderAt(fsys, filename)
+	if err != nil {
+		return nil, err
+	}
+	defer closer.Close()
+
+	magic := make([]byte, len(elf.ELFMAG))
+	if _, err := file.ReadAt(magic, 0); err != nil || !bytes.Equal(magic, []byte(elf.ELFMAG)) {
+		return nil, nil
+	}
+
+	binary, err := elf.NewFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read ELF file %q: %s", filename, err)
+	}
+
+	le := binary.Data == elf.ELFDATA2LSB
+	is64 := binary.Class == elf.ELFCLASS64
+
+	switch {
+	case binary.Machine == elf.EM_X86_64 && is64 && le:
+		return []string{"amd64"}, nil
+	case binary.Machine == elf.EM_386 && !is64 && le:
+		return []string{"i386"}, nil
+	case binary.Machine == elf.EM_AARCH64 && is64 && le:
+		return []string{"arm64"}, nil
+	case binary.Machine == elf.EM_ARM && !is64 && le:
+		// e_flags is at offset 36 in the 32-bit ELF header
+		flags := make([]byte, 4)
+		if _, err := file.ReadAt(flags, 36); err != nil {
+			return nil, fmt.Errorf("Failed to read ELF flags from %q: %s", filename, err)
+		}
+		switch abi := binary.ByteOrder.Uint32(flags); {
+		case abi&elfARMABIFloatHard != 0:
+			return []string{"armhf"}, nil
+		case abi&elfARMABIFloatSoft != 0:
+			return []string{"armel"}, nil
+		}
+		return []string{"armel", "armhf"}, nil
+	case binary.Machine == elf.EM_MIPS && !is64 && !le:
+		return []string{"mips"}, nil
+	case binary.Machine == elf.EM_MIPS && !is64 && le:
+		return []string{"mipsel"}, nil
+	case binary.Machine == elf.EM_PPC && !is64 && !le:
+		return []string{"powerpc"}, nil
+	case binary.Machine == elf.EM_PPC64 && is64 && le:
+		return []string{"ppc64el"}, nil
+	case binary.Machine == elf.EM_S390 && is64 && !le:
+		return []string{"s390x"}, nil
+	}
+
+	return nil, fmt.Errorf("%q is built for %s (%s, %s) which is not a supported architecture",
+		filename, binary.Machine, binary.Class, binary.Data)
+}
+
+// elfFile is an ELF file opened from a file system
+type elfFile struct {
+	*elf.File
+	closer io.Closer
+}
+
+// Close closes the underlying file
+func (f *elfFile) Close() error {
+	return f.closer.Close()
+}
+
+// openELF opens an ELF file in fsys
+func openELF(fsys fs.FS, filename string) (*elfFile, error) {
+	r, closer, err := openReaderAt(fsys, filename)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read ELF file %q: %s", filename, err)
+	}
+	file, err := elf.NewFile(r)
+	if err != nil {
+		closer.Close()
+		return nil, fmt.Errorf("Failed to read ELF file %q: %s", filename, err)
+	}
+	return &elfFile{File: file, closer: closer}, nil
+}
+
+// openELF opens an ELF file from the package source
+func (p *PackageSpec) openELF(filename string) (*elfFile, error) {
+	fsys, name, err := p.sourceFS(filename)
+	if err != nil {
+		return nil, err
+	}
+	return openELF(fsys, name)
+}
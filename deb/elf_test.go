@@ -0,0 +1,163 @@
+package deb
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeELF creates a minimal ELF file that has a header but no sections.
+func writeELF(t *testing.T, filename string, class elf.Class, data elf.Data, machine elf.Machine, flags uint32) {
+	var order binary.ByteOrder = binary.LittleEndian
+	if data == elf.ELFDATA2MSB {
+		order = binary.BigEndian
+	}
+	ident := [elf.EI_NIDENT]byte{0x7f, 'E', 'L', 'F', byte(class), byte(data), byte(elf.EV_CURRENT)}
+
+	buf := &bytes.Buffer{}
+	var header interface{}
+	if class == elf.ELFCLASS64 {
+		header = elf.Header64{Ident: ident, Type: uint16(elf.ET_EXEC), Machine: uint16(machine),
+			Version: uint32(elf.EV_CURRENT), Flags: flags, Ehsize: 64}
+	} else {
+		header = elf.Header32{Ident: ident, Type: uint16(elf.ET_EXEC), Machine: uint16(machine),
+			Version: uint32(elf.EV_CURRENT), Flags: flags, Ehsize: 52}
+	}
+	if err := binary.Write(buf, order, header); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filename, buf.Bytes(), 0755); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestELFArchitectures(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mkdeb-elf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cases := []struct {
+		class    elf.Class
+		data     elf.Data
+		machine  elf.Machine
+		flags    uint32
+		expected string
+	}{
+		{elf.ELFCLASS64, elf.ELFDATA2LSB, elf.EM_X86_64, 0, "amd64"},
+		{elf.ELFCLASS32, elf.ELFDATA2LSB, elf.EM_386, 0, "i386"},
+		{elf.ELFCLASS64, elf.ELFDATA2LSB, elf.EM_AARCH64, 0, "arm64"},
+		{elf.ELFCLASS32, elf.ELFDATA2LSB, elf.EM_ARM, 0x5000400, "armhf"},
+		{elf.ELFCLASS32, elf.ELFDATA2LSB, elf.EM_ARM, 0x5000200, "armel"},
+		{elf.ELFCLASS32, elf.ELFDATA2LSB, elf.EM_ARM, 0x5000000, "armel armhf"},
+		{elf.ELFCLASS32, elf.ELFDATA2MSB, elf.EM_MIPS, 0, "mips"},
+		{elf.ELFCLASS32, elf.ELFDATA2LSB, elf.EM_MIPS, 0, "mipsel"},
+		{elf.ELFCLASS32, elf.ELFDATA2MSB, elf.EM_PPC, 0, "powerpc"},
+		{elf.ELFCLASS64, elf.ELFDATA2LSB, elf.EM_PPC64, 0, "ppc64el"},
+		{elf.ELFCLASS64, elf.ELFDATA2MSB, elf.EM_S390, 0, "s390x"},
+	}
+
+	for _, c := range cases {
+		filename := filepath.Join(dir, "binary")
+		writeELF(t, filename, c.class, c.data, c.machine, c.flags)
+		archs, err := elfArchitectures(hostFS{}, filename)
+		if err != nil {
+			t.Errorf("%s: %s", c.machine, err)
+			continue
+		}
+		if found := strings.Join(archs, " "); found != c.expected {
+			t.Errorf("%s: Expected %q got %q", c.machine, c.expected, found)
+		}
+	}
+
+	// Big endian ppc64 is not a supported debian architecture
+	filename := filepath.Join(dir, "binary")
+	writeELF(t, filename, elf.ELFCLASS64, elf.ELFDATA2MSB, elf.EM_PPC64, 0)
+	if _, err := elfArchitectures(hostFS{}, filename); err == nil {
+		t.Errorf("Expected unsupported architecture error for big endian ppc64")
+	}
+
+	// Non-ELF files are ignored
+	archs, err := elfArchitectures(hostFS{}, filepath.Join("test-fixtures", "package1", "preinst"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if archs != nil {
+		t.Errorf("Expected no architectures for shell script, got %+v", archs)
+	}
+}
+
+func TestDetectArchitecture(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mkdeb-elf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	p := PackageSpecFixture(t)
+	p.Version = "0.1.0"
+	p.AutoPath = dir
+
+	arch, err := p.DetectArchitecture()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if arch != "all" {
+		t.Errorf("Expected %q for package without binaries, got %q", "all", arch)
+	}
+
+	writeELF(t, filepath.Join(dir, "usr", "bin", "one"), elf.ELFCLASS64, elf.ELFDATA2LSB, elf.EM_AARCH64, 0)
+	writeELF(t, filepath.Join(dir, "usr", "bin", "two"), elf.ELFCLASS64, elf.ELFDATA2LSB, elf.EM_AARCH64, 0)
+
+	p.Architecture = "auto"
+	if err := p.ResolveArchitecture(); err != nil {
+		t.Fatal(err)
+	}
+	if p.Architecture != "arm64" {
+		t.Errorf("Expected %q got %q", "arm64", p.Architecture)
+	}
+
+	writeELF(t, filepath.Join(dir, "usr", "bin", "three"), elf.ELFCLASS64, elf.ELFDATA2LSB, elf.EM_X86_64, 0)
+	_, err = p.DetectArchitecture()
+	if err == nil || !strings.Contains(err.Error(), "different architectures") {
+		t.Fatalf("Expected mismatched architecture error; found %+v", err)
+	}
+}
+
+func TestValidateBinaryArchitecture(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mkdeb-elf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	p := PackageSpecFixture(t)
+	p.Version = "0.1.0"
+	p.AutoPath = dir
+
+	writeELF(t, filepath.Join(dir, "usr", "bin", "arm"), elf.ELFCLASS32, elf.ELFDATA2LSB, elf.EM_ARM, 0)
+
+	p.Architecture = "armhf"
+	if err := p.Validate(true); err != nil {
+		t.Fatal(err)
+	}
+
+	p.Architecture = "amd64"
+	err = p.Validate(true)
+	if err == nil || !strings.Contains(err.Error(), "armel or armhf") {
+		t.Fatalf("Expected architecture mismatch error; found %+v", err)
+	}
+
+	p.Architecture = "auto"
+	if err := p.Validate(false); err != nil {
+		t.Fatalf("Expected auto to pass validation; found %s", err)
+	}
+}
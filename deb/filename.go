@@ -0,0 +1,104 @@
+package deb
+
+import (
+	"bytes"
+	"fmt"
+	"path"
+	"strings"
+	"text/template"
+)
+
+// DefaultFilenameTemplate names packages package_version_arch.deb like
+// dpkg-deb and the Debian archive do. The epoch is not part of the name.
+const DefaultFilenameTemplate = "{{.Package}}_{{.Version}}_{{.Architecture}}.deb"
+
+// FilenameData is passed to FilenameTemplate. Version is the package version
+// without the epoch, which is available separately. Upstream and Revision are
+// the parts of Version before and after the last hyphen.
+type FilenameData struct {
+	Package      string
+	Version      string
+	Epoch        string
+	Upstream     string
+	Revision     string
+	Architecture string
+}
+
+var filenameTemplateFuncs = template.FuncMap{
+	"pool": poolPrefix,
+}
+
+// filenameData splits the package version for use in FilenameTemplate
+func (p *PackageSpec) filenameData() FilenameData {
+	data := FilenameData{
+		Package:      p.Package,
+		Version:      p.Version,
+		Architecture: p.Architecture,
+	}
+	if i := strings.Index(data.Version, ":"); i >= 0 {
+		data.Epoch, data.Version = data.Version[:i], data.Version[i+1:]
+	}
+	data.Upstream = data.Version
+	if i := strings.LastIndex(data.Version, "-"); i >= 0 {
+		data.Upstream, data.Revision = data.Version[:i], data.Version[i+1:]
+	}
+	return data
+}
+
+// RenderFilename returns the path of the package relative to the build target,
+// using FilenameTemplate or DefaultFilenameTemplate.
+func (p *PackageSpec) RenderFilename() (string, error) {
+	text := p.FilenameTemplate
+	if text == "" {
+		text = DefaultFilenameTemplate
+	}
+	tmpl, err := template.New("filename").Funcs(filenameTemplateFuncs).Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("Filename template %q is invalid: %s", text, err)
+	}
+	buf := &bytes.Buffer{}
+	if err := tmpl.Execute(buf, p.filenameData()); err != nil {
+		return "", fmt.Errorf("Filename template %q is invalid: %s", text, err)
+	}
+
+	filename := buf.String()
+	switch {
+	case !strings.HasSuffix(filename, ".deb"):
+		return "", fmt.Errorf("Filename %q is invalid; expected it to end in .deb", filename)
+	case strings.ContainsAny(filename, ":\\\n"):
+		return "", fmt.Errorf("Filename %q is invalid; it must not contain colons or backslashes", filename)
+	case path.IsAbs(filename) || path.Clean(filename) != filename || strings.HasPrefix(filename, "../"):
+		return "", fmt.Errorf("Filename %q is invalid; expected a relative path inside the build target", filename)
+	}
+	return filename, nil
+}
+
+// validateFilename checks that FilenameTemplate renders a valid filename.
+// Outside of builds the version and architecture may not be known yet, so
+// placeholders are used for them.
+func (p *PackageSpec) validateFilename(buildTime bool) error {
+	spec := *p
+	if !buildTime {
+		if spec.Version == "" {
+			spec.Version = "0"
+		}
+		if spec.Architecture == "" || spec.Architecture == autoArchitecture {
+			spec.Architecture = "all"
+		}
+	}
+	_, err := spec.RenderFilename()
+	return err
+}
+
+// poolPrefix returns the directory packages are grouped by in a Debian pool,
+// which is the first letter of the name, or the first four for libraries, e.g.
+// pool/main/{{pool .Package}}/{{.Package}}/ gives pool/main/libf/libfoo/.
+func poolPrefix(name string) string {
+	if strings.HasPrefix(name, "lib") && len(name) > 3 {
+		return name[:4]
+	}
+	if name == "" {
+		return ""
+	}
+	return name[:1]
+}
@@ -0,0 +1,77 @@
+package deb
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRenderFilename(t *testing.T) {
+	cases := []struct {
+		template string
+		version  string
+		expected string
+	}{
+		{"", "0.1.0", "mkdeb_0.1.0_amd64.deb"},
+		{"", "2:0.1.0-3", "mkdeb_0.1.0-3_amd64.deb"},
+		{"{{.Package}}-{{.Upstream}}-r{{.Revision}}-e{{.Epoch}}.deb", "2:0.1.0-3", "mkdeb-0.1.0-r3-e2.deb"},
+		{"pool/main/{{pool .Package}}/{{.Package}}/{{.Package}}_{{.Version}}_{{.Architecture}}.deb", "0.1.0", "pool/main/m/mkdeb/mkdeb_0.1.0_amd64.deb"},
+	}
+	for _, c := range cases {
+		p := &PackageSpec{Package: "mkdeb", Version: c.version, Architecture: "amd64", FilenameTemplate: c.template}
+		filename, err := p.RenderFilename()
+		if err != nil {
+			t.Errorf("%s: %s", c.template, err)
+			continue
+		}
+		if filename != c.expected || p.Filename() != c.expected {
+			t.Errorf("Expected %q; found %q", c.expected, filename)
+		}
+	}
+
+	if prefix := poolPrefix("libfoo"); prefix != "libf" {
+		t.Errorf("Expected libf; found %s", prefix)
+	}
+
+	invalid := map[string]string{
+		"{{.Package":       "template",
+		"{{.Name}}.deb":    "template",
+		"{{.Package}}.tar": "end in .deb",
+		"{{.Package}}_{{.Epoch}}:{{.Version}}.deb": "colons",
+		"/tmp/{{.Package}}.deb":                    "relative path",
+		"../{{.Package}}.deb":                      "relative path",
+	}
+	for template, expected := range invalid {
+		p := PackageSpecFixture(t)
+		p.FilenameTemplate = template
+		err := p.Validate(false)
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("Expected error containing %q for %s; found %v", expected, template, err)
+		}
+		// Filename falls back to the default
+		p.Version = "0.1.0"
+		if p.Filename() != "mkdeb_0.1.0_amd64.deb" {
+			t.Errorf("Unexpected fallback filename %q", p.Filename())
+		}
+	}
+}
+
+func TestBuildFilenameTemplate(t *testing.T) {
+	target, err := ioutil.TempDir("", "mkdeb-filename")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(target)
+
+	p := PackageSpecFixture(t)
+	p.Version = "1:0.1.0"
+	p.FilenameTemplate = "pool/{{pool .Package}}/{{.Package}}_{{.Version}}_{{.Architecture}}.deb"
+	if err := p.Build(target); err != nil {
+		t.Fatal(err)
+	}
+	if !FileExists(filepath.Join(target, "pool", "m", "mkdeb_0.1.0_amd64.deb")) {
+		t.Errorf("Expected package to be built in the pool")
+	}
+}
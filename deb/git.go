@@ -0,0 +1,715 @@
+package deb
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// This file contains a minimal reader for local git repositories, which is
+// enough to walk the history between two tags without running git. It reads
+// loose objects, packfiles (including deltas), loose refs, and packed-refs.
+
+var reGitHash = regexp.MustCompile(`^[0-9a-f]{40}$`)
+
+// Object types as stored in packfiles
+const (
+	gitObjCommit   = 1
+	gitObjTree     = 2
+	gitObjBlob     = 3
+	gitObjTag      = 4
+	gitObjOfsDelta = 6
+	gitObjRefDelta = 7
+)
+
+var gitObjTypes = map[int]string{
+	gitObjCommit: "commit",
+	gitObjTree:   "tree",
+	gitObjBlob:   "blob",
+	gitObjTag:    "tag",
+}
+
+// gitRepo reads objects and refs from a .git directory
+type gitRepo struct {
+	dir   string
+	packs []*gitPack
+}
+
+// gitPack is a packfile and its index
+type gitPack struct {
+	filename string
+	hashes   [][20]byte // sorted
+	offsets  []int64    // offset of the object with the same index in hashes
+}
+
+// gitCommit is a parsed commit object
+type gitCommit struct {
+	hash      string
+	parents   []string
+	author    string
+	committed time.Time
+	message   string
+}
+
+// subject returns the first line of the commit message
+func (c *gitCommit) subject() string {
+	return strings.TrimSpace(strings.SplitN(strings.TrimSpace(c.message), "\n", 2)[0])
+}
+
+// openGitRepo opens the git repository containing dir, looking in parent
+// directories for .git like git does.
+func openGitRepo(dir string) (*gitRepo, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		gitDir := filepath.Join(dir, ".git")
+		info, err := os.Stat(gitDir)
+		if err == nil {
+			if !info.IsDir() {
+				// Worktrees and submodules use a file pointing to the git dir
+				if gitDir, err = readGitDirFile(gitDir); err != nil {
+					return nil, err
+				}
+			}
+			repo := &gitRepo{dir: gitDir}
+			if err := repo.loadPacks(); err != nil {
+				return nil, err
+			}
+			return repo, nil
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return nil, fmt.Errorf("No git repository found")
+		}
+		dir = parent
+	}
+}
+
+func readGitDirFile(filename string) (string, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return "", err
+	}
+	line := strings.TrimSpace(string(data))
+	if !strings.HasPrefix(line, "gitdir: ") {
+		return "", fmt.Errorf("Failed to read %q: expected gitdir", filename)
+	}
+	dir := strings.TrimPrefix(line, "gitdir: ")
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(filepath.Dir(filename), dir)
+	}
+	return dir, nil
+}
+
+// commonDir returns the directory holding objects and shared refs. For linked
+// worktrees this is different from the git dir.
+func (r *gitRepo) commonDir() string {
+	data, err := ioutil.ReadFile(filepath.Join(r.dir, "commondir"))
+	if err != nil {
+		return r.dir
+	}
+	dir := strings.TrimSpace(string(data))
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(r.dir, dir)
+	}
+	return dir
+}
+
+// loadPacks reads the index of every packfile in the repository
+func (r *gitRepo) loadPacks() error {
+	indexes, err := filepath.Glob(filepath.Join(r.commonDir(), "objects", "pack", "pack-*.idx"))
+	if err != nil {
+		return err
+	}
+	for _, index := range indexes {
+		pack, err := readGitPackIndex(index)
+		if err != nil {
+			return fmt.Errorf("Failed to read pack index %q: %s", index, err)
+		}
+		r.packs = append(r.packs, pack)
+	}
+	return nil
+}
+
+// readGitPackIndex reads a version 2 pack index
+func readGitPackIndex(filename string) (*gitPack, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 8+256*4 || !bytes.Equal(data[:4], []byte("\377tOc")) || binary.BigEndian.Uint32(data[4:8]) != 2 {
+		return nil, fmt.Errorf("unsupported pack index version")
+	}
+	count := int(binary.BigEndian.Uint32(data[8+255*4 : 8+256*4]))
+	hashStart := 8 + 256*4
+	offsetStart := hashStart + count*20 + count*4
+	largeStart := offsetStart + count*4
+	if len(data) < largeStart {
+		return nil, fmt.Errorf("truncated pack index")
+	}
+
+	pack := &gitPack{
+		filename: strings.TrimSuffix(filename, ".idx") + ".pack",
+		hashes:   make([][20]byte, count),
+		offsets:  make([]int64, count),
+	}
+	for i := 0; i < count; i++ {
+		copy(pack.hashes[i][:], data[hashStart+i*20:])
+		offset := binary.BigEndian.Uint32(data[offsetStart+i*4:])
+		if offset&0x80000000 != 0 {
+			// Offsets over 2GB are stored in a separate table
+			large := largeStart + int(offset&0x7fffffff)*8
+			if len(data) < large+8 {
+				return nil, fmt.Errorf("truncated pack index")
+			}
+			pack.offsets[i] = int64(binary.BigEndian.Uint64(data[large:]))
+		} else {
+			pack.offsets[i] = int64(offset)
+		}
+	}
+	return pack, nil
+}
+
+// find returns the offset of an object in the pack
+func (p *gitPack) find(hash [20]byte) (int64, bool) {
+	i := sort.Search(len(p.hashes), func(i int) bool {
+		return bytes.Compare(p.hashes[i][:], hash[:]) >= 0
+	})
+	if i < len(p.hashes) && p.hashes[i] == hash {
+		return p.offsets[i], true
+	}
+	return 0, false
+}
+
+// readObject returns the type and contents of an object
+func (r *gitRepo) readObject(hash string) (string, []byte, error) {
+	if !reGitHash.MatchString(hash) {
+		return "", nil, fmt.Errorf("Invalid object id %q", hash)
+	}
+	loose := filepath.Join(r.commonDir(), "objects", hash[:2], hash[2:])
+	if file, err := os.Open(loose); err == nil {
+		defer file.Close()
+		return readLooseObject(file)
+	}
+
+	var id [20]byte
+	hex.Decode(id[:], []byte(hash))
+	for _, pack := range r.packs {
+		if offset, ok := pack.find(id); ok {
+			kind, data, err := r.readPackedObject(pack, offset)
+			if err != nil {
+				return "", nil, fmt.Errorf("Failed to read object %s: %s", hash, err)
+			}
+			return gitObjTypes[kind], data, nil
+		}
+	}
+	return "", nil, fmt.Errorf("Object %s not found", hash)
+}
+
+func readLooseObject(r io.Reader) (string, []byte, error) {
+	z, err := zlib.NewReader(r)
+	if err != nil {
+		return "", nil, err
+	}
+	defer z.Close()
+	data, err := ioutil.ReadAll(z)
+	if err != nil {
+		return "", nil, err
+	}
+	nul := bytes.IndexByte(data, 0)
+	if nul == -1 {
+		return "", nil, fmt.Errorf("invalid object header")
+	}
+	header := strings.SplitN(string(data[:nul]), " ", 2)
+	if len(header) != 2 {
+		return "", nil, fmt.Errorf("invalid object header %q", data[:nul])
+	}
+	size, err := strconv.Atoi(header[1])
+	if err != nil || size != len(data)-nul-1 {
+		return "", nil, fmt.Errorf("invalid object size %q", header[1])
+	}
+	return header[0], data[nul+1:], nil
+}
+
+// readPackedObject reads the object at offset in a packfile, resolving deltas
+func (r *gitRepo) readPackedObject(pack *gitPack, offset int64) (int, []byte, error) {
+	file, err := os.Open(pack.filename)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer file.Close()
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return 0, nil, err
+	}
+	reader := bufio.NewReader(file)
+
+	// The header is the type and the uncompressed size as a varint
+	c, err := reader.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	kind := int(c>>4) & 7
+	size := int64(c & 0x0f)
+	for shift := uint(4); c&0x80 != 0; shift += 7 {
+		if c, err = reader.ReadByte(); err != nil {
+			return 0, nil, err
+		}
+		size |= int64(c&0x7f) << shift
+	}
+
+	var base []byte
+	var baseKind int
+	switch kind {
+	case gitObjOfsDelta:
+		// The base is a negative offset from this object, in git's varint format
+		c, err := reader.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		rel := int64(c & 0x7f)
+		for c&0x80 != 0 {
+			if c, err = reader.ReadByte(); err != nil {
+				return 0, nil, err
+			}
+			rel = ((rel + 1) << 7) | int64(c&0x7f)
+		}
+		if baseKind, base, err = r.readPackedObject(pack, offset-rel); err != nil {
+			return 0, nil, err
+		}
+	case gitObjRefDelta:
+		var id [20]byte
+		if _, err := io.ReadFull(reader, id[:]); err != nil {
+			return 0, nil, err
+		}
+		var kindName string
+		if kindName, base, err = r.readObject(hex.EncodeToString(id[:])); err != nil {
+			return 0, nil, err
+		}
+		for k, name := range gitObjTypes {
+			if name == kindName {
+				baseKind = k
+			}
+		}
+	case gitObjCommit, gitObjTree, gitObjBlob, gitObjTag:
+	default:
+		return 0, nil, fmt.Errorf("unknown object type %d", kind)
+	}
+
+	z, err := zlib.NewReader(reader)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer z.Close()
+	data, err := ioutil.ReadAll(z)
+	if err != nil {
+		return 0, nil, err
+	}
+	if int64(len(data)) != size {
+		return 0, nil, fmt.Errorf("object size mismatch")
+	}
+
+	if base != nil {
+		data, err = applyGitDelta(base, data)
+		return baseKind, data, err
+	}
+	return kind, data, nil
+}
+
+// applyGitDelta rebuilds an object from its base and a delta
+func applyGitDelta(base, delta []byte) ([]byte, error) {
+	readSize := func() int {
+		size, shift := 0, uint(0)
+		for len(delta) > 0 {
+			c := delta[0]
+			delta = delta[1:]
+			size |= int(c&0x7f) << shift
+			shift += 7
+			if c&0x80 == 0 {
+				break
+			}
+		}
+		return size
+	}
+	if readSize() != len(base) {
+		return nil, fmt.Errorf("delta base size mismatch")
+	}
+	result := make([]byte, 0, readSize())
+
+	for len(delta) > 0 {
+		op := delta[0]
+		delta = delta[1:]
+		if op&0x80 != 0 {
+			// Copy from base. The low bits say which offset and size bytes follow.
+			var offset, size int
+			for i := uint(0); i < 7; i++ {
+				if op&(1<<i) == 0 {
+					continue
+				}
+				if len(delta) == 0 {
+					return nil, fmt.Errorf("truncated delta")
+				}
+				if i < 4 {
+					offset |= int(delta[0]) << (8 * i)
+				} else {
+					size |= int(delta[0]) << (8 * (i - 4))
+				}
+				delta = delta[1:]
+			}
+			if size == 0 {
+				size = 0x10000
+			}
+			if offset+size > len(base) {
+				return nil, fmt.Errorf("delta copy out of range")
+			}
+			result = append(result, base[offset:offset+size]...)
+		} else if op != 0 {
+			// Insert the next op bytes
+			if int(op) > len(delta) {
+				return nil, fmt.Errorf("truncated delta")
+			}
+			result = append(result, delta[:op]...)
+			delta = delta[op:]
+		} else {
+			return nil, fmt.Errorf("invalid delta opcode")
+		}
+	}
+	if len(result) != cap(result) {
+		return nil, fmt.Errorf("delta result size mismatch")
+	}
+	return result, nil
+}
+
+// resolve returns the object id for a revision: a full object id, HEAD, or the
+// name of a tag or branch.
+func (r *gitRepo) resolve(rev string) (string, error) {
+	if reGitHash.MatchString(rev) {
+		return rev, nil
+	}
+	candidates := []string{rev, "refs/" + rev, "refs/tags/" + rev, "refs/heads/" + rev}
+	for _, ref := range candidates {
+		if hash, ok, err := r.readRef(ref, 0); err != nil {
+			return "", err
+		} else if ok {
+			return hash, nil
+		}
+	}
+	return "", fmt.Errorf("Unknown revision %q", rev)
+}
+
+// readRef reads a loose or packed ref, following symbolic refs
+func (r *gitRepo) readRef(ref string, depth int) (string, bool, error) {
+	if depth > 5 {
+		return "", false, fmt.Errorf("Too many levels of symbolic refs for %q", ref)
+	}
+	dirs := []string{r.dir}
+	if common := r.commonDir(); common != r.dir {
+		dirs = append(dirs, common)
+	}
+	for _, dir := range dirs {
+		data, err := ioutil.ReadFile(filepath.Join(dir, filepath.FromSlash(ref)))
+		if err != nil {
+			continue
+		}
+		value := strings.TrimSpace(string(data))
+		if strings.HasPrefix(value, "ref: ") {
+			return r.readRef(strings.TrimPrefix(value, "ref: "), depth+1)
+		}
+		if reGitHash.MatchString(value) {
+			return value, true, nil
+		}
+	}
+
+	file, err := os.Open(filepath.Join(r.commonDir(), "packed-refs"))
+	if err != nil {
+		return "", false, nil
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && fields[1] == ref && reGitHash.MatchString(fields[0]) {
+			return fields[0], true, nil
+		}
+	}
+	return "", false, scanner.Err()
+}
+
+// commit reads a commit, peeling annotated tags
+func (r *gitRepo) commit(hash string) (*gitCommit, error) {
+	for depth := 0; depth < 10; depth++ {
+		kind, data, err := r.readObject(hash)
+		if err != nil {
+			return nil, err
+		}
+		switch kind {
+		case "commit":
+			return parseGitCommit(hash, data)
+		case "tag":
+			header := string(data)
+			if !strings.HasPrefix(header, "object ") || len(header) < 47 {
+				return nil, fmt.Errorf("Invalid tag object %s", hash)
+			}
+			hash = header[7:47]
+		default:
+			return nil, fmt.Errorf("Object %s is a %s, not a commit", hash, kind)
+		}
+	}
+	return nil, fmt.Errorf("Too many levels of tags at %s", hash)
+}
+
+func parseGitCommit(hash string, data []byte) (*gitCommit, error) {
+	commit := &gitCommit{hash: hash}
+	headers := string(data)
+	if i := strings.Index(headers, "\n\n"); i >= 0 {
+		commit.message = headers[i+2:]
+		headers = headers[:i]
+	}
+	for _, line := range strings.Split(headers, "\n") {
+		parts := strings.SplitN(line, " ", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		switch parts[0] {
+		case "parent":
+			commit.parents = append(commit.parents, parts[1])
+		case "author":
+			name, _, err := parseGitSignature(parts[1])
+			if err != nil {
+				return nil, fmt.Errorf("Invalid author in commit %s: %s", hash, err)
+			}
+			commit.author = name
+		case "committer":
+			_, when, err := parseGitSignature(parts[1])
+			if err != nil {
+				return nil, fmt.Errorf("Invalid committer in commit %s: %s", hash, err)
+			}
+			commit.committed = when
+		}
+	}
+	return commit, nil
+}
+
+// parseGitSignature parses "Name <email> 1234567890 +0100"
+func parseGitSignature(sig string) (string, time.Time, error) {
+	end := strings.LastIndex(sig, ">")
+	if end == -1 {
+		return "", time.Time{}, fmt.Errorf("missing email")
+	}
+	name := sig[:end+1]
+	fields := strings.Fields(sig[end+1:])
+	if len(fields) != 2 {
+		return "", time.Time{}, fmt.Errorf("missing timestamp")
+	}
+	seconds, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	zone, err := time.Parse("-0700", fields[1])
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	_, offset := zone.Zone()
+	return name, time.Unix(seconds, 0).In(time.FixedZone("", offset)), nil
+}
+
+// log returns the commits reachable from to but not from from, newest first,
+// like git log from..to. If from is empty all ancestors of to are returned.
+func (r *gitRepo) log(from, to string) ([]*gitCommit, error) {
+	exclude := map[string]bool{}
+	if from != "" {
+		hash, err := r.resolve(from)
+		if err != nil {
+			return nil, err
+		}
+		if err := r.walk(hash, func(c *gitCommit) bool {
+			exclude[c.hash] = true
+			return true
+		}); err != nil {
+			return nil, err
+		}
+	}
+
+	hash, err := r.resolve(to)
+	if err != nil {
+		return nil, err
+	}
+	commits := []*gitCommit{}
+	err = r.walk(hash, func(c *gitCommit) bool {
+		if exclude[c.hash] {
+			return false
+		}
+		commits = append(commits, c)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(commits, func(i, j int) bool {
+		return commits[i].committed.After(commits[j].committed)
+	})
+	return commits, nil
+}
+
+// walk visits every commit reachable from hash once. Parents of a commit are
+// skipped if visit returns false.
+func (r *gitRepo) walk(hash string, visit func(*gitCommit) bool) error {
+	seen := map[string]bool{}
+	queue := []string{hash}
+	for len(queue) > 0 {
+		hash, queue = queue[0], queue[1:]
+		if seen[hash] {
+			continue
+		}
+		seen[hash] = true
+		commit, err := r.commit(hash)
+		if err != nil {
+			return err
+		}
+		// Tags resolve to a different hash than the one we were given
+		if seen[commit.hash] && commit.hash != hash {
+			continue
+		}
+		seen[commit.hash] = true
+		if visit(commit) {
+			queue = append(queue, commit.parents...)
+		}
+	}
+	return nil
+}
+
+// tags returns the names of the tags in the repository, keyed by the commit
+// they point to. Annotated tags are peeled. When several tags point to the
+// same commit the best one comes first, like git describe picks it: annotated
+// tags before lightweight ones, then releases before pre-releases.
+func (r *gitRepo) tags() (map[string][]string, error) {
+	refs := map[string]string{}
+	if file, err := os.Open(filepath.Join(r.commonDir(), "packed-refs")); err == nil {
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			fields := strings.Fields(scanner.Text())
+			if len(fields) == 2 && strings.HasPrefix(fields[1], "refs/tags/") && reGitHash.MatchString(fields[0]) {
+				refs[strings.TrimPrefix(fields[1], "refs/tags/")] = fields[0]
+			}
+		}
+		file.Close()
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	// Loose refs take precedence over packed ones
+	root := filepath.Join(r.commonDir(), "refs", "tags")
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if value := strings.TrimSpace(string(data)); reGitHash.MatchString(value) {
+			name, _ := filepath.Rel(root, path)
+			refs[filepath.ToSlash(name)] = value
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	tags := map[string][]string{}
+	annotated := map[string]bool{}
+	for name, hash := range refs {
+		commit, err := r.commit(hash)
+		if err != nil {
+			// Tags may point at trees or blobs, which can't be described
+			continue
+		}
+		tags[commit.hash] = append(tags[commit.hash], name)
+		annotated[name] = commit.hash != hash
+	}
+	prerelease := func(name string) bool {
+		matches := reTagVersion.FindStringSubmatch(name[strings.LastIndex(name, "/")+1:])
+		return matches != nil && matches[2] != ""
+	}
+	for _, names := range tags {
+		sort.Slice(names, func(i, j int) bool {
+			if annotated[names[i]] != annotated[names[j]] {
+				return annotated[names[i]]
+			}
+			if prerelease(names[i]) != prerelease(names[j]) {
+				return !prerelease(names[i])
+			}
+			return names[i] > names[j]
+		})
+	}
+	return tags, nil
+}
+
+// describe finds the tag nearest to rev, like git describe --tags. It returns
+// the tag, the number of commits since the tag, and the commit id of rev.
+func (r *gitRepo) describe(rev string) (string, int, string, error) {
+	hash, err := r.resolve(rev)
+	if err != nil {
+		return "", 0, "", err
+	}
+	head, err := r.commit(hash)
+	if err != nil {
+		return "", 0, "", err
+	}
+	tags, err := r.tags()
+	if err != nil {
+		return "", 0, "", err
+	}
+
+	// Tags are found breadth first, so the first one is usually the nearest,
+	// but on merges a tag further up one branch may have fewer commits since.
+	// Like git, consider the first few candidates and pick the best one.
+	const maxCandidates = 10
+	candidates := []string{}
+	err = r.walk(head.hash, func(c *gitCommit) bool {
+		if _, ok := tags[c.hash]; ok {
+			candidates = append(candidates, c.hash)
+			return false
+		}
+		return len(candidates) < maxCandidates
+	})
+	if err != nil {
+		return "", 0, "", err
+	}
+	if len(candidates) == 0 {
+		return "", 0, "", fmt.Errorf("No tags found in the history of %s", rev)
+	}
+
+	best, distance := "", -1
+	for _, candidate := range candidates {
+		commits, err := r.log(candidate, head.hash)
+		if err != nil {
+			return "", 0, "", err
+		}
+		if distance == -1 || len(commits) < distance {
+			best, distance = candidate, len(commits)
+		}
+	}
+	return tags[best][0], distance, head.hash, nil
+}
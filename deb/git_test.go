@@ -0,0 +1,192 @@
+package deb
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// GitRepoFixture creates a git repository with a few tagged commits. It returns
+// the repository directory and a function that runs git in it.
+func GitRepoFixture(t *testing.T) (string, func(args ...string) string) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	dir, err := ioutil.TempDir("", "mkdeb-git")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	date := 1485900000
+	git := func(args ...string) string {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		cmd.Env = append(os.Environ(),
+			"GIT_AUTHOR_NAME=Chris Bednarski", "GIT_AUTHOR_EMAIL=banzaimonkey@gmail.com",
+			"GIT_COMMITTER_NAME=Chris Bednarski", "GIT_COMMITTER_EMAIL=banzaimonkey@gmail.com",
+			fmt.Sprintf("GIT_AUTHOR_DATE=%d -0800", date), fmt.Sprintf("GIT_COMMITTER_DATE=%d -0800", date),
+			"GIT_CONFIG_NOSYSTEM=1", "HOME="+dir)
+		output, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %s failed: %s\n%s", strings.Join(args, " "), err, output)
+		}
+		return strings.TrimSpace(string(output))
+	}
+	commit := func(name, message string) {
+		date += 3600
+		filename := filepath.Join(dir, name)
+		data, _ := ioutil.ReadFile(filename)
+		data = append(data, []byte(strings.Repeat("// "+message+"\n", 20))...)
+		if err := ioutil.WriteFile(filename, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		git("add", name)
+		git("commit", "-q", "-m", message)
+	}
+
+	git("init", "-q", "-b", "master")
+	commit("main.go", "Initial commit")
+	git("tag", "-a", "-m", "Release 1.0", "v1.0")
+	commit("main.go", "Add a feature\n\nWith a longer description.")
+	git("checkout", "-q", "-b", "topic")
+	commit("fix.go", "Fix a bug on a branch")
+	git("checkout", "-q", "master")
+	commit("README", "Update the docs")
+	date += 3600
+	git("merge", "-q", "--no-ff", "-m", "Merge branch 'topic'", "topic")
+	git("tag", "v1.1")
+	return dir, git
+}
+
+func TestGitLog(t *testing.T) {
+	dir, git := GitRepoFixture(t)
+	defer os.RemoveAll(dir)
+
+	check := func() {
+		repo, err := openGitRepo(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, rev := range []string{"HEAD", "master", "v1.0", "v1.1", "refs/tags/v1.0"} {
+			hash, err := repo.resolve(rev)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if expected := git("rev-parse", rev); hash != expected {
+				t.Errorf("Expected %s to be %s; found %s", rev, expected, hash)
+			}
+		}
+		if _, err := repo.resolve("v2.0"); err == nil {
+			t.Errorf("Expected unknown revision to fail")
+		}
+
+		commits, err := repo.log("v1.0", "v1.1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		subjects := []string{}
+		for _, commit := range commits {
+			subjects = append(subjects, commit.subject())
+		}
+		expected := "Merge branch 'topic',Update the docs,Fix a bug on a branch,Add a feature"
+		if strings.Join(subjects, ",") != expected {
+			t.Errorf("Expected %s; found %s", expected, strings.Join(subjects, ","))
+		}
+		if commits[0].author != "Chris Bednarski <banzaimonkey@gmail.com>" || len(commits[0].parents) != 2 {
+			t.Errorf("Unexpected commit %+v", commits[0])
+		}
+
+		all, err := repo.log("", "HEAD")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(all) != 5 {
+			t.Errorf("Expected 5 commits; found %d", len(all))
+		}
+	}
+
+	// Loose objects and refs
+	check()
+
+	// Packed objects with deltas, and packed-refs
+	git("gc", "-q", "--aggressive", "--prune=now")
+	if _, err := os.Stat(filepath.Join(dir, ".git", "packed-refs")); err != nil {
+		t.Fatalf("Expected refs to be packed: %s", err)
+	}
+	check()
+}
+
+func TestGitReadObjects(t *testing.T) {
+	dir, git := GitRepoFixture(t)
+	defer os.RemoveAll(dir)
+	git("gc", "-q", "--aggressive", "--prune=now")
+
+	repo, err := openGitRepo(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Every object should read back with contents matching its hash
+	objects := strings.Split(git("cat-file", "--batch-all-objects", "--batch-check=%(objectname) %(objecttype)"), "\n")
+	for _, object := range objects {
+		fields := strings.Fields(object)
+		kind, data, err := repo.readObject(fields[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if kind != fields[1] {
+			t.Errorf("Expected %s to be a %s; found %s", fields[0], fields[1], kind)
+		}
+		sum := sha1.Sum(append([]byte(fmt.Sprintf("%s %d\x00", kind, len(data))), data...))
+		if hex.EncodeToString(sum[:]) != fields[0] {
+			t.Errorf("Object %s has the wrong contents", fields[0])
+		}
+	}
+}
+
+func TestGitChangelogEntry(t *testing.T) {
+	dir, _ := GitRepoFixture(t)
+	defer os.RemoveAll(dir)
+
+	p := PackageSpecFixture(t)
+	p.Version = "1.1"
+	p.Changelog = &Changelog{
+		Git: &ChangelogGit{From: "v1.0", To: "v1.1", Repo: dir},
+		Entries: []ChangelogEntry{
+			{Version: "1.0", Date: "2017-01-31", Changes: []string{"Initial release"}},
+		},
+	}
+	if err := p.Validate(false); err != nil {
+		t.Fatal(err)
+	}
+
+	changelog, err := p.RenderChangelog()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `mkdeb (1.1) unstable; urgency=medium
+
+  * Update the docs
+  * Fix a bug on a branch
+  * Add a feature
+
+ -- Chris Bednarski <banzaimonkey@gmail.com>  Tue, 31 Jan 2017 19:00:00 -0800
+
+mkdeb (1.0) unstable; urgency=medium
+`
+	if !strings.HasPrefix(string(changelog), expected) {
+		t.Errorf("--Expected--\n%s\n--Found--\n%s\n", expected, changelog)
+	}
+
+	if _, err := p.GitChangelogEntry(dir, "v1.1", "v1.1"); err == nil || !strings.Contains(err.Error(), "No commits") {
+		t.Errorf("Expected no commits error; found %v", err)
+	}
+}
@@ -0,0 +1,20 @@
+//go:build !windows
+// +build !windows
+
+package deb
+
+import (
+	"io/fs"
+	"syscall"
+)
+
+// hostFileID returns the device and inode of a file on the host that has more
+// than one hard link. It returns false for other files, and for files that are
+// not on the host, such as in an fstest.MapFS.
+func hostFileID(info fs.FileInfo) (fileID, bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok || stat.Nlink < 2 {
+		return fileID{}, false
+	}
+	return fileID{dev: uint64(stat.Dev), ino: uint64(stat.Ino)}, true
+}
@@ -0,0 +1,9 @@
+package deb
+
+import "io/fs"
+
+// hostFileID always returns false, since hard links are not preserved when
+// building on Windows
+func hostFileID(info fs.FileInfo) (fileID, bool) {
+	return fileID{}, false
+}
@@ -0,0 +1,200 @@
+package deb
+
+import (
+	"bytes"
+	"fmt"
+	"path"
+	"strings"
+)
+
+const (
+	// SeverityError marks a problem that causes Validate to fail
+	SeverityError = "error"
+	// SeverityWarning marks a problem that is reported but does not stop the build
+	SeverityWarning = "warning"
+)
+
+// posixShells are interpreters that are expected to run POSIX sh scripts. On
+// Debian /bin/sh is dash, so bash extensions are flagged in these scripts.
+var posixShells = []string{"sh", "dash", "ash", "posh"}
+
+// ScriptDiagnostic is a problem found in a maintainer script by
+// LintControlScripts. Line is the line number in the script as it is packaged,
+// starting at 1.
+type ScriptDiagnostic struct {
+	Script   string `json:"script"`
+	Line     int    `json:"line"`
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+}
+
+func (d ScriptDiagnostic) String() string {
+	return fmt.Sprintf("%s:%d: %s: %s", d.Script, d.Line, d.Severity, d.Message)
+}
+
+// ScriptLintError is returned by Validate when the maintainer scripts have
+// problems with SeverityError.
+type ScriptLintError struct {
+	Diagnostics []ScriptDiagnostic
+}
+
+func (e *ScriptLintError) Error() string {
+	lines := []string{}
+	for _, d := range e.Diagnostics {
+		lines = append(lines, d.String())
+	}
+	return fmt.Sprintf("Maintainer scripts have errors:\n%s", strings.Join(lines, "\n"))
+}
+
+// LintControlScripts checks the maintainer scripts that will be included in
+// the package. Scripts for /bin/sh are parsed and checked for syntax errors and
+// bash extensions. All scripts are checked for a #! line, set -e, and whether
+// they look at the action dpkg passes as $1.
+func (p *PackageSpec) LintControlScripts() ([]ScriptDiagnostic, error) {
+	scripts, err := p.RenderControlScripts()
+	if err != nil {
+		return nil, err
+	}
+	diagnostics := []ScriptDiagnostic{}
+	for _, name := range controlFiles {
+		if script, ok := scripts[name]; ok {
+			diagnostics = append(diagnostics, lintScript(name, script)...)
+		}
+	}
+	return diagnostics, nil
+}
+
+// lintControlScripts returns a *ScriptLintError if any of the maintainer
+// scripts have errors
+func (p *PackageSpec) lintControlScripts() error {
+	diagnostics, err := p.LintControlScripts()
+	if err != nil {
+		return err
+	}
+	errors := []ScriptDiagnostic{}
+	for _, d := range diagnostics {
+		if d.Severity == SeverityError {
+			errors = append(errors, d)
+		}
+	}
+	if len(errors) > 0 {
+		return &ScriptLintError{Diagnostics: errors}
+	}
+	return nil
+}
+
+// lintScript checks a single maintainer script
+func lintScript(name string, script []byte) []ScriptDiagnostic {
+	diagnostics := []ScriptDiagnostic{}
+	report := func(line int, severity, format string, args ...interface{}) {
+		diagnostics = append(diagnostics, ScriptDiagnostic{
+			Script:   name,
+			Line:     line,
+			Severity: severity,
+			Message:  fmt.Sprintf(format, args...),
+		})
+	}
+
+	if !bytes.HasPrefix(script, []byte("#!")) {
+		report(1, SeverityError, "missing #! line; maintainer scripts must start with e.g. #!/bin/sh")
+		return diagnostics
+	}
+	shebang := string(script[2:])
+	if i := strings.IndexByte(shebang, '\n'); i >= 0 {
+		shebang = shebang[:i]
+	}
+	fields := strings.Fields(shebang)
+	if len(fields) == 0 {
+		report(1, SeverityError, "#! line does not name an interpreter")
+		return diagnostics
+	}
+	if !hasString(posixShells, path.Base(fields[0])) {
+		// We can't parse bash, perl, etc. so leave those alone
+		return diagnostics
+	}
+
+	parsed, err := parseShell(string(script))
+	if err != nil {
+		if syntaxErr, ok := err.(*shellSyntaxError); ok {
+			report(syntaxErr.line, SeverityError, "syntax error: %s", syntaxErr.message)
+			return diagnostics
+		}
+		report(1, SeverityError, "%s", err)
+		return diagnostics
+	}
+
+	for _, issue := range append(parsed.bashisms, parsed.commandBashisms()...) {
+		report(issue.line, SeverityError, "%s in %s script", issue.message, fields[0])
+	}
+
+	if !usesErrexit(fields[1:], parsed) {
+		report(1, SeverityWarning, "script does not use set -e, so failed commands are ignored")
+	}
+
+	checksAction := false
+	for _, c := range parsed.cases {
+		if !isFirstArgument(c.subject) {
+			continue
+		}
+		checksAction = true
+		for _, pattern := range c.patterns {
+			action := strings.Trim(pattern.pattern, `"'`)
+			if strings.ContainsAny(action, "*?[$") {
+				continue
+			}
+			if !hasString(scriptActions[name], action) {
+				report(pattern.line, SeverityWarning, "dpkg never calls %s with %q; expected one of %s",
+					name, action, strings.Join(scriptActions[name], ", "))
+			}
+		}
+	}
+	for _, cmd := range parsed.commands {
+		for _, arg := range cmd.args {
+			if strings.Contains(arg, "$1") || strings.Contains(arg, "${1") {
+				checksAction = true
+			}
+		}
+	}
+	if !checksAction {
+		report(1, SeverityWarning, "script does not check the action dpkg passes as $1 (%s)",
+			strings.Join(scriptActions[name], ", "))
+	}
+
+	return diagnostics
+}
+
+// usesErrexit reports whether the script turns on set -e, either on the #!
+// line or with the set builtin.
+func usesErrexit(interpreterArgs []string, script *shellScript) bool {
+	for _, arg := range interpreterArgs {
+		if isErrexitFlag(arg) {
+			return true
+		}
+	}
+	for _, cmd := range script.commands {
+		if cmd.args[0] != "set" {
+			continue
+		}
+		for i, arg := range cmd.args[1:] {
+			if isErrexitFlag(arg) {
+				return true
+			}
+			if arg == "-o" && i+2 < len(cmd.args) && cmd.args[i+2] == "errexit" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func isErrexitFlag(arg string) bool {
+	return strings.HasPrefix(arg, "-") && !strings.HasPrefix(arg, "--") && strings.Contains(arg, "e")
+}
+
+func isFirstArgument(word string) bool {
+	switch word {
+	case "$1", `"$1"`, "${1}", `"${1}"`:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,99 @@
+package deb
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLintScript(t *testing.T) {
+	clean := "#!/bin/sh\nset -e\ncase \"$1\" in\nconfigure) echo hi ;;\nesac\n"
+	if diagnostics := lintScript("postinst", []byte(clean)); len(diagnostics) != 0 {
+		t.Errorf("Expected no diagnostics; found %+v", diagnostics)
+	}
+
+	cases := []struct {
+		script   string
+		expected ScriptDiagnostic
+	}{
+		{"echo hi\n", ScriptDiagnostic{"postinst", 1, SeverityError, "missing #! line"}},
+		{"#!/bin/sh -e\nif [ \"$1\" = configure ]; then\n\techo\n", ScriptDiagnostic{"postinst", 4, SeverityError, "syntax error"}},
+		{"#!/bin/sh -e\n[ \"$1\" = configure ] || exit 0\n[[ -d /etc ]]\n", ScriptDiagnostic{"postinst", 3, SeverityError, "[[ ]]"}},
+		{"#!/bin/sh\n[ \"$1\" = configure ] || exit 0\n", ScriptDiagnostic{"postinst", 1, SeverityWarning, "set -e"}},
+		{"#!/bin/sh\nset -e\necho hi\n", ScriptDiagnostic{"postinst", 1, SeverityWarning, "does not check the action"}},
+		{"#!/bin/sh\nset -e\ncase $1 in\nconfigured) ;;\nesac\n", ScriptDiagnostic{"postinst", 4, SeverityWarning, "\"configured\""}},
+	}
+	for _, c := range cases {
+		diagnostics := lintScript("postinst", []byte(c.script))
+		if len(diagnostics) != 1 {
+			t.Errorf("Expected one diagnostic for %q; found %+v", c.script, diagnostics)
+			continue
+		}
+		d := diagnostics[0]
+		if d.Script != c.expected.Script || d.Line != c.expected.Line || d.Severity != c.expected.Severity ||
+			!strings.Contains(d.Message, c.expected.Message) {
+			t.Errorf("Expected %s; found %s", c.expected, d)
+		}
+	}
+
+	// bash scripts are allowed to use bash
+	bash := "#!/bin/bash\nset -o errexit\n[[ \"$1\" == configure ]] && echo\n"
+	if diagnostics := lintScript("postinst", []byte(bash)); len(diagnostics) != 0 {
+		t.Errorf("Expected no diagnostics; found %+v", diagnostics)
+	}
+}
+
+func TestLintGeneratedScripts(t *testing.T) {
+	p := PackageSpecFixture(t, "systemd.json")
+	p.Users = []User{{Name: "package1", System: true, Groups: []string{"adm"}}}
+	p.Owners = map[string]string{"/etc/package1": "package1"}
+	p.Scripts = map[string][]ScriptSnippet{
+		"postinst": {{File: filepath.Join("test-fixtures", "setup.sh"), Actions: []string{"configure"}}},
+	}
+
+	diagnostics, err := p.LintControlScripts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range diagnostics {
+		// The fixture preinst is a plain echo
+		if d.Script != "preinst" {
+			t.Errorf("Unexpected diagnostic in generated script: %s", d)
+		}
+	}
+}
+
+func TestValidateLintsScripts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mkdeb-lint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	script := filepath.Join(dir, "postinst")
+	if err := ioutil.WriteFile(script, []byte("#!/bin/sh\nset -e\nsource /etc/default/foo\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	p := PackageSpecFixture(t)
+	p.Version = "1.0"
+	p.Postinst = script
+
+	// Scripts are only linted at build time
+	if err := p.Validate(false); err != nil {
+		t.Fatal(err)
+	}
+
+	err = p.Validate(true)
+	lintErr, ok := err.(*ScriptLintError)
+	if !ok {
+		t.Fatalf("Expected a *ScriptLintError; found %v", err)
+	}
+	if len(lintErr.Diagnostics) != 1 || lintErr.Diagnostics[0].Line != 3 {
+		t.Errorf("Expected one error on line 3; found %+v", lintErr.Diagnostics)
+	}
+	if !strings.Contains(err.Error(), "postinst:3: error: source is a bash builtin") {
+		t.Errorf("Unexpected error message: %s", err)
+	}
+}
@@ -0,0 +1,195 @@
+package deb
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+const (
+	// manPath and infoPath are where manual and info pages are installed
+	manPath  = "/usr/share/man"
+	infoPath = "/usr/share/info"
+
+	// markdownExt marks manpage sources that are rendered to troff
+	markdownExt = ".md"
+)
+
+var (
+	// reManpageName matches name.section, where the section is a digit and an
+	// optional suffix like 3pm or 1ssl
+	reManpageName = regexp.MustCompile(`^([^/]+)\.([1-9][a-z0-9]*)$`)
+
+	// reManpageHeader matches the title macro of man (.TH) and mdoc (.Dt) pages
+	reManpageHeader = regexp.MustCompile(`^\.(TH|Dt)\s+("[^"]*"|\S+)\s+("[^"]*"|\S+)`)
+
+	// reInfoName matches info files, including the parts of split info files
+	reInfoName = regexp.MustCompile(`^[^/]+\.info(-[0-9]+)?$`)
+)
+
+// manpage is a manual page source and where it is installed
+type manpage struct {
+	source   string
+	name     string
+	section  string
+	markdown bool
+}
+
+// target returns the installed path of the compressed page, e.g.
+// /usr/share/man/man1/foo.1.gz
+func (m manpage) target() string {
+	return path.Join(manPath, "man"+m.section[:1], m.name+"."+m.section+".gz")
+}
+
+// parseManpage checks the manpage filename and returns its name and section.
+// Markdown sources are named like troff pages with .md at the end.
+func parseManpage(source string) (manpage, error) {
+	m := manpage{source: source}
+	base := filepath.Base(source)
+	if strings.HasSuffix(base, markdownExt) {
+		m.markdown = true
+		base = strings.TrimSuffix(base, markdownExt)
+	}
+	matches := reManpageName.FindStringSubmatch(base)
+	if matches == nil {
+		return m, fmt.Errorf("Manpage %q is invalid; expected a name ending in the section like foo.1 or foo.1.md", source)
+	}
+	m.name, m.section = matches[1], matches[2]
+	return m, nil
+}
+
+// validateManpages checks the manpage and info page filenames. At build time
+// the section in the title of each manpage must match its filename.
+func (p *PackageSpec) validateManpages(buildTime bool) error {
+	targets := map[string]string{}
+	for _, source := range p.Manpages {
+		m, err := parseManpage(source)
+		if err != nil {
+			return err
+		}
+		if other, ok := targets[m.target()]; ok {
+			return fmt.Errorf("Manpages %q and %q are both installed as %s", other, source, m.target())
+		}
+		targets[m.target()] = source
+	}
+	for _, source := range p.InfoPages {
+		if !reInfoName.MatchString(filepath.Base(source)) {
+			return fmt.Errorf("Info page %q is invalid; expected a name like foo.info or foo.info-1", source)
+		}
+	}
+	if !buildTime {
+		return nil
+	}
+
+	for _, source := range p.Manpages {
+		m, _ := parseManpage(source)
+		data, err := p.readFile(source)
+		if err != nil {
+			return fmt.Errorf("Failed to read manpage: %s", err)
+		}
+		if m.markdown {
+			if _, err := renderMarkdownManpage(m, data, ""); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := checkManpageHeader(m, data); err != nil {
+			return err
+		}
+	}
+
+	for _, source := range p.InfoPages {
+		data, err := p.readFile(source)
+		if err != nil {
+			return fmt.Errorf("Failed to read info page: %s", err)
+		}
+		// Nodes in info files start with a unit separator
+		if !bytes.Contains(data, []byte("\x1f")) {
+			return fmt.Errorf("Info page %q is not an info file; expected the output of makeinfo", source)
+		}
+	}
+	return nil
+}
+
+// checkManpageHeader verifies that the .TH or .Dt title of a troff page
+// declares the same section as its filename
+func checkManpageHeader(m manpage, data []byte) error {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		matches := reManpageHeader.FindStringSubmatch(strings.TrimSpace(scanner.Text()))
+		if matches == nil {
+			continue
+		}
+		section := strings.Trim(matches[3], `"`)
+		if !strings.EqualFold(section, m.section) {
+			return fmt.Errorf("Manpage %q is in section %s but its %s header says %s",
+				m.source, m.section, matches[1], section)
+		}
+		return nil
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return fmt.Errorf("Manpage %q has no .TH or .Dt header", m.source)
+}
+
+// writeManpages compresses the manpages and info pages into the workspace,
+// rendering markdown sources to troff first, so they can be added to the
+// package.
+func (p *PackageSpec) writeManpages(workspace string) error {
+	for _, source := range p.Manpages {
+		m, err := parseManpage(source)
+		if err != nil {
+			return err
+		}
+		data, err := p.readFile(source)
+		if err != nil {
+			return fmt.Errorf("Failed to read manpage: %s", err)
+		}
+		if m.markdown {
+			data, err = renderMarkdownManpage(m, data, p.Package+" "+p.Version)
+			if err != nil {
+				return err
+			}
+		}
+		if err := p.writeCompressedFile(workspace, m.target(), data); err != nil {
+			return err
+		}
+	}
+
+	for _, source := range p.InfoPages {
+		data, err := p.readFile(source)
+		if err != nil {
+			return fmt.Errorf("Failed to read info page: %s", err)
+		}
+		target := path.Join(infoPath, filepath.Base(source)+".gz")
+		if err := p.writeCompressedFile(workspace, target, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeCompressedFile writes data compressed with gzipDeterministic into the
+// workspace and adds it to the package as target
+func (p *PackageSpec) writeCompressedFile(workspace, target string, data []byte) error {
+	compressed, err := gzipDeterministic(data)
+	if err != nil {
+		return err
+	}
+	filename := filepath.Join(workspace, "generated", filepath.FromSlash(target))
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filename, compressed, 0644); err != nil {
+		return err
+	}
+	p.addGeneratedFile(filename, target)
+	return nil
+}
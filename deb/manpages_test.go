@@ -0,0 +1,157 @@
+package deb
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseManpage(t *testing.T) {
+	cases := map[string]manpage{
+		"docs/foo.1":        {name: "foo", section: "1"},
+		"foo.conf.5.md":     {name: "foo.conf", section: "5", markdown: true},
+		"Foo::Bar.3pm":      {name: "Foo::Bar", section: "3pm"},
+		"docs/openssl.1ssl": {name: "openssl", section: "1ssl"},
+	}
+	for source, expected := range cases {
+		m, err := parseManpage(source)
+		if err != nil {
+			t.Errorf("%s: %s", source, err)
+			continue
+		}
+		expected.source = source
+		if m != expected {
+			t.Errorf("Expected %+v; found %+v", expected, m)
+		}
+	}
+
+	m, _ := parseManpage("Foo::Bar.3pm")
+	if m.target() != "/usr/share/man/man3/Foo::Bar.3pm.gz" {
+		t.Errorf("Unexpected target %s", m.target())
+	}
+
+	for _, source := range []string{"foo", "foo.0", "foo.1.gz", "foo.md", "foo.x"} {
+		if _, err := parseManpage(source); err == nil {
+			t.Errorf("Expected %q to be rejected", source)
+		}
+	}
+}
+
+func TestValidateManpages(t *testing.T) {
+	p := PackageSpecFixture(t, "manpages.json")
+	p.Version = "1.2.0"
+	if err := p.Validate(true); err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := ioutil.TempDir("", "mkdeb-manpages")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	write := func(name, content string) string {
+		filename := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(filename, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return filename
+	}
+
+	manpages := map[string][]string{
+		"name ending in the section": {"README.md"},
+		"both installed as":          {"a/foo.1", "b/foo.1.md"},
+		"header says 8":              {write("foo.1", ".TH FOO 8\n")},
+		"no .TH or .Dt header":       {write("bar.1", ".SH NAME\nbar\n")},
+		"title says 3":               {write("baz.1.md", "# baz(3) -- wrong\n")},
+	}
+	for expected, sources := range manpages {
+		p := PackageSpecFixture(t, "manpages.json")
+		p.Version = "1.2.0"
+		p.Manpages = sources
+		err := p.Validate(true)
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("Expected error containing %q; found %v", expected, err)
+		}
+	}
+
+	// mdoc pages use .Dt for the title
+	p.Manpages = []string{write("qux.7", ".Dd January 1, 2017\n.Dt QUX 7\n.Os\n")}
+	if err := p.Validate(true); err != nil {
+		t.Error(err)
+	}
+
+	infoPages := map[string][]string{
+		"name like foo.info": {"foo.texi"},
+		"not an info file":   {write("foo.info", "plain text\n")},
+	}
+	for expected, sources := range infoPages {
+		p := PackageSpecFixture(t, "manpages.json")
+		p.Version = "1.2.0"
+		p.InfoPages = sources
+		err := p.Validate(true)
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("Expected error containing %q; found %v", expected, err)
+		}
+	}
+}
+
+func TestWriteManpages(t *testing.T) {
+	workspace, err := ioutil.TempDir("", "mkdeb-manpages")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(workspace)
+
+	p := PackageSpecFixture(t, "manpages.json")
+	p.Version = "1.2.0"
+	if err := p.writeGeneratedFiles(workspace); err != nil {
+		t.Fatal(err)
+	}
+
+	targets := map[string]string{}
+	for src, dest := range p.installedFiles() {
+		targets[dest] = src
+	}
+	expected := map[string]string{
+		"/usr/share/man/man1/mkdeb.1.gz":      ".TH MKDEB 1",
+		"/usr/share/man/man5/mkdeb.json.5.gz": `.TH "MKDEB.JSON" "5" "" "mkdeb 1.2.0" ""`,
+		"/usr/share/info/mkdeb.info.gz":       "This is mkdeb.info",
+	}
+	for target, prefix := range expected {
+		src, ok := targets[target]
+		if !ok {
+			t.Errorf("%s is missing: %+v", target, targets)
+			continue
+		}
+		compressed, err := ioutil.ReadFile(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reader, err := gzip.NewReader(bytes.NewReader(compressed))
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadAll(reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(data), prefix) {
+			t.Errorf("Expected %s to contain %q; found\n%s", target, prefix, data)
+		}
+	}
+}
+
+func TestBuildManpages(t *testing.T) {
+	p := PackageSpecFixture(t, "manpages.json")
+	p.Version = "1.2.0"
+
+	err := p.Build("output")
+	defer os.Remove(filepath.Join("output", p.Filename()))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
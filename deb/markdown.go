@@ -0,0 +1,299 @@
+package deb
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// This file renders a subset of markdown to troff using the man macros, so
+// manpages can be written in markdown without pandoc or ronn. It supports
+// headings, paragraphs, lists, definition lists, block quotes, fenced code
+// blocks, and bold, italic, code, and link spans.
+
+var (
+	// reMarkdownTitle matches a ronn style title, e.g. # foo(1) -- do things
+	reMarkdownTitle = regexp.MustCompile(`^(\S+)\(([^)]+)\)(?:\s+-{1,2}\s+(.*))?$`)
+
+	reMarkdownHeading    = regexp.MustCompile(`^(#{1,6})\s+(.*?)\s*#*\s*$`)
+	reMarkdownListItem   = regexp.MustCompile(`^\s*([-*+]|[0-9]+[.)])\s+(.*)$`)
+	reMarkdownDefinition = regexp.MustCompile(`^:\s+(.*)$`)
+	reMarkdownQuote      = regexp.MustCompile(`^>\s?(.*)$`)
+	reMarkdownFence      = regexp.MustCompile("^\\s*(```+|~~~+)")
+	reMarkdownRule       = regexp.MustCompile(`^\s*([-*_])(\s*([-*_]))+\s*$`)
+)
+
+// markdownBlock is a paragraph, list item, definition, or block quote that is
+// still collecting lines
+type markdownBlock struct {
+	kind   string // "p", "li", "dd", or "quote"
+	marker string // list item marker
+	term   string // defined term
+	lines  []string
+}
+
+type markdownRenderer struct {
+	out      bytes.Buffer
+	block    *markdownBlock
+	sections int // heading level of .SH
+}
+
+// renderMarkdownManpage converts a markdown manpage to troff. If the page
+// starts with a title like "# foo(1) -- do things" it is used for the title and
+// NAME section, and the section must match the filename. Otherwise the title is
+// derived from the filename. footer is shown at the bottom of the page, and is
+// usually the package name and version.
+func renderMarkdownManpage(m manpage, data []byte, footer string) ([]byte, error) {
+	lines := strings.Split(strings.Replace(string(data), "\r\n", "\n", -1), "\n")
+	r := &markdownRenderer{sections: 1}
+
+	// Skip blank lines before the title
+	for len(lines) > 0 && strings.TrimSpace(lines[0]) == "" {
+		lines = lines[1:]
+	}
+	name, description := m.name, ""
+	if len(lines) > 0 {
+		if heading := reMarkdownHeading.FindStringSubmatch(lines[0]); heading != nil && len(heading[1]) == 1 {
+			if title := reMarkdownTitle.FindStringSubmatch(heading[2]); title != nil {
+				if !strings.EqualFold(title[2], m.section) {
+					return nil, fmt.Errorf("Manpage %q is in section %s but its title says %s",
+						m.source, m.section, title[2])
+				}
+				name, description = title[1], title[3]
+				lines = lines[1:]
+				// The title is the top level heading, so sections use ##
+				r.sections = 2
+			}
+		}
+	}
+
+	fmt.Fprintf(&r.out, ".\\\" Generated by mkdeb from %s\n", filepath.Base(m.source))
+	fmt.Fprintf(&r.out, ".TH %s %s \"\" %s \"\"\n",
+		troffQuote(strings.ToUpper(name)), troffQuote(m.section), troffQuote(footer))
+	if description != "" {
+		r.macro(".SH", "NAME")
+		r.text(name + " - " + description)
+	}
+
+	fence := ""
+	for _, line := range lines {
+		line = strings.TrimRight(line, " \t")
+
+		if fence != "" {
+			if strings.HasPrefix(strings.TrimSpace(line), fence) {
+				r.out.WriteString(".fi\n.RE\n")
+				fence = ""
+				continue
+			}
+			r.line(troffEscape(line))
+			continue
+		}
+
+		if matches := reMarkdownFence.FindStringSubmatch(line); matches != nil {
+			r.flush()
+			fence = matches[1]
+			r.out.WriteString(".PP\n.RS 4\n.nf\n")
+			continue
+		}
+
+		if line == "" {
+			r.flush()
+			continue
+		}
+
+		if heading := reMarkdownHeading.FindStringSubmatch(line); heading != nil {
+			r.flush()
+			if len(heading[1]) <= r.sections {
+				r.macro(".SH", strings.ToUpper(heading[2]))
+			} else {
+				r.macro(".SS", heading[2])
+			}
+			continue
+		}
+
+		if reMarkdownRule.MatchString(line) {
+			r.flush()
+			continue
+		}
+
+		if item := reMarkdownListItem.FindStringSubmatch(line); item != nil {
+			r.flush()
+			r.block = &markdownBlock{kind: "li", marker: item[1], lines: []string{item[2]}}
+			continue
+		}
+
+		if definition := reMarkdownDefinition.FindStringSubmatch(line); definition != nil &&
+			r.block != nil && r.block.kind == "p" {
+			term := strings.Join(r.block.lines, " ")
+			r.block = &markdownBlock{kind: "dd", term: term, lines: []string{definition[1]}}
+			continue
+		}
+
+		if quote := reMarkdownQuote.FindStringSubmatch(line); quote != nil {
+			if r.block == nil || r.block.kind != "quote" {
+				r.flush()
+				r.block = &markdownBlock{kind: "quote"}
+			}
+			r.block.lines = append(r.block.lines, quote[1])
+			continue
+		}
+
+		// Anything else continues the current block, or starts a paragraph
+		if r.block == nil {
+			r.block = &markdownBlock{kind: "p"}
+		}
+		r.block.lines = append(r.block.lines, strings.TrimSpace(line))
+	}
+	if fence != "" {
+		return nil, fmt.Errorf("Manpage %q has an unterminated code block", m.source)
+	}
+	r.flush()
+	return r.out.Bytes(), nil
+}
+
+// flush writes the current block
+func (r *markdownRenderer) flush() {
+	b := r.block
+	if b == nil {
+		return
+	}
+	r.block = nil
+	text := strings.Join(b.lines, "\n")
+	switch b.kind {
+	case "p":
+		r.out.WriteString(".PP\n")
+	case "li":
+		if b.marker == "-" || b.marker == "*" || b.marker == "+" {
+			r.out.WriteString(".IP \\(bu 2\n")
+		} else {
+			fmt.Fprintf(&r.out, ".IP %s 4\n", troffQuote(b.marker))
+		}
+	case "dd":
+		r.out.WriteString(".TP\n")
+		r.text(b.term)
+	case "quote":
+		r.out.WriteString(".PP\n.RS 4\n")
+		r.text(text)
+		r.out.WriteString(".RE\n")
+		return
+	}
+	r.text(text)
+}
+
+// macro writes a macro with its argument on the following line, which avoids
+// quoting the argument
+func (r *markdownRenderer) macro(name, text string) {
+	r.out.WriteString(name + "\n")
+	r.text(text)
+}
+
+// text writes markdown text with inline formatting
+func (r *markdownRenderer) text(text string) {
+	for _, line := range strings.Split(markdownInline(text), "\n") {
+		r.line(line)
+	}
+}
+
+// line writes a line of troff text, escaping it if it would be read as a
+// request
+func (r *markdownRenderer) line(line string) {
+	if strings.HasPrefix(line, ".") || strings.HasPrefix(line, "'") {
+		line = `\&` + line
+	}
+	r.out.WriteString(line + "\n")
+}
+
+// markdownInline converts bold, italic, code, and link spans to troff font
+// changes, and escapes everything else
+func markdownInline(text string) string {
+	out := &bytes.Buffer{}
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		rest := text[i:]
+		switch {
+		case c == '\\' && i+1 < len(text) && strings.IndexByte("\\`*_{}[]()#+-.!<>", text[i+1]) >= 0:
+			i++
+			out.WriteString(troffEscape(text[i : i+1]))
+		case c == '`':
+			end := strings.IndexByte(text[i+1:], '`')
+			if end == -1 {
+				out.WriteString("`")
+				continue
+			}
+			out.WriteString(`\fB` + troffEscape(text[i+1:i+1+end]) + `\fP`)
+			i += end + 1
+		case strings.HasPrefix(rest, "**") || strings.HasPrefix(rest, "__"):
+			end := strings.Index(text[i+2:], rest[:2])
+			if end <= 0 || !markdownFlanking(text[i+2:i+2+end]) ||
+				(c == '_' && !markdownBoundary(text, i, i+2+end+2)) {
+				out.WriteString(troffEscape(rest[:2]))
+				i++
+				continue
+			}
+			out.WriteString(`\fB` + markdownInline(text[i+2:i+2+end]) + `\fP`)
+			i += end + 3
+		case c == '*' || c == '_':
+			end := strings.IndexByte(text[i+1:], c)
+			if end <= 0 || !markdownFlanking(text[i+1:i+1+end]) ||
+				(c == '_' && !markdownBoundary(text, i, i+1+end+1)) {
+				out.WriteString(troffEscape(rest[:1]))
+				continue
+			}
+			out.WriteString(`\fI` + markdownInline(text[i+1:i+1+end]) + `\fP`)
+			i += end + 1
+		case c == '[':
+			closing := strings.Index(rest, "](")
+			end := strings.IndexByte(rest, ')')
+			if closing <= 0 || end < closing {
+				out.WriteString("[")
+				continue
+			}
+			label, url := rest[1:closing], rest[closing+2:end]
+			out.WriteString(markdownInline(label))
+			if url != "" && url != label {
+				out.WriteString(" <" + troffEscape(url) + ">")
+			}
+			i += end
+		case c == '<':
+			end := strings.IndexByte(rest, '>')
+			if end == -1 || !strings.Contains(rest[:end], "://") {
+				out.WriteString("<")
+				continue
+			}
+			out.WriteString(troffEscape(rest[1:end]))
+			i += end
+		default:
+			out.WriteString(troffEscape(rest[:1]))
+		}
+	}
+	return out.String()
+}
+
+// markdownFlanking reports whether emphasized text does not start or end with
+// a space, so 2 * 3 * 4 is not italicized
+func markdownFlanking(text string) bool {
+	return strings.TrimSpace(text) == text
+}
+
+// markdownBoundary reports whether the span text[start:end] is not inside a
+// word, so snake_case names are not italicized
+func markdownBoundary(text string, start, end int) bool {
+	isWord := func(c byte) bool {
+		return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+	}
+	return (start == 0 || !isWord(text[start-1])) && (end >= len(text) || !isWord(text[end]))
+}
+
+// troffEscape escapes backslashes, and hyphens so they are rendered as minus
+// signs like in command line options
+func troffEscape(text string) string {
+	text = strings.Replace(text, `\`, `\e`, -1)
+	return strings.Replace(text, "-", `\-`, -1)
+}
+
+// troffQuote quotes a macro argument
+func troffQuote(text string) string {
+	return `"` + strings.Replace(troffEscape(text), `"`, `\(dq`, -1) + `"`
+}
@@ -0,0 +1,119 @@
+package deb
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRenderMarkdownManpage(t *testing.T) {
+	source := filepath.Join("test-fixtures", "manpages", "mkdeb.json.5.md")
+	data, err := ioutil.ReadFile(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := parseManpage(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	troff, err := renderMarkdownManpage(m, data, "mkdeb 1.2.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `.\" Generated by mkdeb from mkdeb.json.5.md
+.TH "MKDEB.JSON" "5" "" "mkdeb 1.2.0" ""
+.SH
+NAME
+mkdeb.json \- mkdeb package configuration
+.SH
+DESCRIPTION
+.PP
+The config file is a JSON object. Paths are relative
+to the config file, e.g. \fBdeb\-pkg/usr/bin\fP.
+.SH
+FIELDS
+.TP
+\fBpackage\fP
+The name of the package
+.TP
+\fIversion\fP
+Set with \fBmkdeb build \-version\fP
+.IP \(bu 2
+First
+.IP \(bu 2
+Second with a link <https://github.com/cbednarski/mkdeb>
+.PP
+.RS 4
+.nf
+{"package": "foo"}
+\&.not a request
+.fi
+.RE
+.PP
+.RS 4
+Quoted text with a file_name_here.
+.RE
+`
+	if string(troff) != expected {
+		t.Errorf("--Expected--\n%s\n--Found--\n%s\n", expected, troff)
+	}
+}
+
+func TestRenderMarkdownManpageWithoutTitle(t *testing.T) {
+	m := manpage{source: "foo.8.md", name: "foo", section: "8"}
+	troff, err := renderMarkdownManpage(m, []byte("# Synopsis\n\n**foo** [*options*]\n\n## Exit status\n\n1. Success\n2. Failure\n"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `.\" Generated by mkdeb from foo.8.md
+.TH "FOO" "8" "" "" ""
+.SH
+SYNOPSIS
+.PP
+\fBfoo\fP [\fIoptions\fP]
+.SS
+Exit status
+.IP "1." 4
+Success
+.IP "2." 4
+Failure
+`
+	if string(troff) != expected {
+		t.Errorf("--Expected--\n%s\n--Found--\n%s\n", expected, troff)
+	}
+}
+
+func TestRenderMarkdownManpageErrors(t *testing.T) {
+	m := manpage{source: "foo.1.md", name: "foo", section: "1"}
+	cases := map[string]string{
+		"# foo(8) -- wrong section\n": "title says 8",
+		"```\nnot closed\n":           "unterminated code block",
+	}
+	for markdown, expected := range cases {
+		_, err := renderMarkdownManpage(m, []byte(markdown), "")
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("Expected error containing %q; found %v", expected, err)
+		}
+	}
+}
+
+func TestMarkdownInline(t *testing.T) {
+	cases := map[string]string{
+		"plain text":                "plain text",
+		"a **bold** word":           `a \fBbold\fP word`,
+		"**bold _and italic_**":     `\fBbold \fIand italic\fP\fP`,
+		"snake_case_name":           "snake_case_name",
+		"`--flag` and C:\\path":     `\fB\-\-flag\fP and C:\epath`,
+		`not \*italic\*`:            "not *italic*",
+		"see <https://example.com>": "see https://example.com",
+		"2 * 3 * 4":                 "2 * 3 * 4",
+	}
+	for markdown, expected := range cases {
+		if found := markdownInline(markdown); found != expected {
+			t.Errorf("Expected %q to render as %q; found %q", markdown, expected, found)
+		}
+	}
+}
@@ -15,17 +15,17 @@ package deb
 import (
 	"archive/tar"
 	"bytes"
-	"crypto/md5"
-	"encoding/hex"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
+	"io/fs"
 	"io/ioutil"
-	"log"
 	"os"
 	"path"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"strings"
 	"text/template"
 	"time"
@@ -35,14 +35,15 @@ import (
 )
 
 var (
-	reDepends     = regexp.MustCompile(`^[a-zA-Z0-9.+_-]+( \((>|>=|<|<=|=) ([0-9][0-9a-zA-Z.-]*?)\))?$`)
-	reReplacesEtc = regexp.MustCompile(`^[a-zA-Z0-9.+_-]+( \(<< ([0-9][0-9a-zA-Z.-]*?)\))?$`)
+	reDepends     = regexp.MustCompile(`^[a-zA-Z0-9.+_-]+( \((>|>=|<|<=|=) (([0-9]+:)?[0-9][0-9a-zA-Z.+~-]*?)\))?$`)
+	reReplacesEtc = regexp.MustCompile(`^[a-zA-Z0-9.+_-]+( \(<< (([0-9]+:)?[0-9][0-9a-zA-Z.+~-]*?)\))?$`)
 
 	controlFiles = []string{
 		"preinst",
 		"postinst",
 		"prerm",
 		"postrm",
+		"config", // debconf
 	}
 
 	supportedArchitectures = []string{
@@ -71,11 +72,24 @@ var (
 // main program.
 //
 // Version is a debian version string. See the reference for more details.
-// This field is not currently validated except to verify that it is specified,
-// but if the syntax is invalid you will not be able to install the package.
+// It is not read from the config file; mkdeb build takes it from -version,
+// which can also compute it from git tags or read it from a file.
 //
 // Architecture is the CPU architecture your package is compiled for. If your
-// package does not include a compiled binary you can set this to "all".
+// package does not include a compiled binary you can set this to "all". When
+// set to "auto" the architecture is detected from the ELF binaries in the
+// package. Either way, the build fails if the binaries were compiled for a
+// different architecture.
+//
+// Architectures may be specified instead of Architecture to build the same
+// package for several CPU architectures at once. One .deb is produced for each
+// entry. Setting both is an error. Source paths in Files may refer to the
+// current architecture using {{.Arch}}, for example:
+//
+//	"architectures": ["amd64", "arm64", "armhf"],
+//	"files": {
+//	    "dist/{{.Arch}}/foo": "/usr/bin/foo"
+//	}
 //
 // Maintainer should indicate contact information for the package, such as
 // Chris Bednarski <chris@example.com>
@@ -110,6 +124,106 @@ var (
 // These are commonly used to create users, start or stop services, or perform
 // cleanup when a package is uninstalled.
 //
+// Some options, such as Systemd, generate additional script fragments. These
+// are inserted into your scripts in place of a line containing #MKDEB#, or
+// added to the end if there is no such line, before the exit on the last line
+// if there is one. Make sure your script does not exit any earlier.
+//
+// Alternatively, Scripts assembles each script from an ordered list of
+// snippets: files, inline shell, and generated fragments. The assembled script
+// starts with #!/bin/sh and set -e and rejects unknown actions. Snippets may be
+// limited to specific actions. See ScriptSnippet for details.
+//
+//	"scripts": {
+//	    "postinst": [
+//	        {"file": "scripts/setup.sh", "actions": ["configure"]},
+//	        {"generated": "systemd"},
+//	        {"inline": "echo done"}
+//	    ]
+//	}
+//
+// Systemd
+//
+// Systemd lists systemd units to install into /lib/systemd/system. The scripts
+// needed to enable, start, and stop them are generated automatically.
+//
+//	"systemd": [
+//	    {"unit": "foo.service", "enable": true, "start": true}
+//	]
+//
+// See SystemdUnit for all of the options.
+//
+// Users and Groups
+//
+// Users and Groups list accounts that are created by postinst if they do not
+// already exist. If Sysusers is set a sysusers.d config for them is installed
+// as well. The generated script uses adduser, so your package should depend on
+// it. See User and Group for the options.
+//
+//	"users": [
+//	    {"name": "foo", "system": true, "home": "/var/lib/foo", "groups": ["adm"]}
+//	]
+//
+// Copyright and Changelog are installed in /usr/share/doc/<package>, as
+// copyright and a compressed changelog.Debian.gz. Each is either the path to an
+// existing file, or structured data: DEP-5 fields for Copyright and a list of
+// entries for Changelog. See Copyright and Changelog for the options.
+//
+//	"copyright": {"files": [{"files": ["*"], "copyright": ["2020 Foo"], "license": "MIT"}]},
+//	"changelog": {"file": "debian/changelog"}
+//
+// Manpages lists manual pages to compress and install in
+// /usr/share/man/man<N>. Each is a troff source named after the page and its
+// section, like foo.1 or foo.3pm, and the section in its .TH header must match.
+// Sources ending in .md are rendered from markdown to troff first; see
+// renderMarkdownManpage for the supported syntax. InfoPages lists info files
+// produced by makeinfo to compress and install in /usr/share/info.
+//
+//	"manpages": ["docs/foo.1", "docs/foo.conf.5.md"],
+//	"infoPages": ["docs/foo.info"]
+//
+// Alternatives registers files in the package with update-alternatives, and
+// Diversions diverts files that belong to other packages with dpkg-divert. The
+// maintainer scripts that add and remove them are generated automatically. See
+// Alternative and Diversion for the options.
+//
+//	"alternatives": [
+//	    {"link": "/usr/bin/foo", "name": "foo", "path": "/usr/bin/foo-2", "priority": 20}
+//	],
+//	"diversions": [
+//	    {"path": "/etc/foo.conf", "since": "1.2.0"}
+//	]
+//
+// Triggers declares dpkg triggers the package is interested in or activates,
+// and optionally shell code for postinst to run when it is triggered. See
+// Triggers for the options.
+//
+//	"triggers": {
+//	    "interestNoawait": ["/usr/lib/foo/plugins"],
+//	    "triggered": "foo --rebuild-index"
+//	}
+//
+// Owners changes the owner of files in the package, which are otherwise owned
+// by root. Owners maps the installed path to user:group, or just user to use
+// the user's primary group. Since users may not exist until postinst runs the
+// ownership is applied by postinst as well.
+//
+//	"owners": {
+//	    "/var/lib/foo": "foo:foo"
+//	}
+//
+// Capabilities sets Linux file capabilities, so a daemon can e.g. bind to port
+// 80 without running as root. It maps the installed path to capabilities in
+// the format used by setcap(8). Since dpkg does not apply extended attributes
+// from the package they are set by postinst, which warns and continues if
+// setcap is not installed. Add libcap2-bin to Depends to make sure it is.
+// CapabilityXattrs also records them as SCHILY.xattr.security.capability PAX
+// records in the data archive, for other tools that extract it.
+//
+//	"capabilities": {
+//	    "/usr/sbin/food": "cap_net_bind_service=+ep"
+//	}
+//
 // AutoPath
 //
 // The Build method is designed to automatically fill in most of the build
@@ -117,12 +231,44 @@ var (
 // to a non-empty value it will be scanned for pre/post/inst/rm scripts as well
 // as configuration files and binaries to be automatically included in the .deb.
 //
+// The debconf config script and templates file are also picked up from the top
+// level of AutoPath, or may be set with Config and Templates. Templates are
+// checked against the debconf template syntax when the package is built. Remember
+// to add debconf to Depends if you use them.
+//
 // To disable the automatic behavior set AutoPath to an empty string or dash "-".
 // Whether or not AutoPath is used you may supplement the list of files to be
 // included by specifying the Files field.
 //
 // Build Time Options
 //
+// Source is the file system the files in the package are read from, such as
+// an embed.FS, fstest.MapFS, or os.DirFS of the directory containing the
+// config file. Paths in the spec must then be relative to its root. If Source
+// is not set files are read from the host, relative to BaseDir.
+//
+// BaseDir is the directory that relative paths on the host are resolved
+// against, usually the directory containing the config file. This includes
+// AutoPath, Files, and scripts when Source is not set, as well as TempPath and
+// the git repository used for the changelog. It defaults to the working
+// directory. Setting it instead of changing the working directory allows
+// several packages to be built at the same time in one process.
+//
+// OnProgress is called with the number of files and bytes read so far while
+// the package is built, which takes a while for large packages. Every file is
+// read once for each of the ProgressStages. Use BuildContext to stop a build
+// early.
+//
+// CacheDir enables a cache of built packages, such as DefaultCacheDir(). Build
+// then hashes the spec and the contents and modes of the files that go into
+// the package, and copies the package from the cache instead of building it
+// if they have not changed since it was cached. This happens before any files
+// are generated or binaries are stripped. Packages cached by a different
+// executable, such as another version of mkdeb, are not reused. CacheHit
+// reports whether the package came from the cache. The files are read once
+// more to hash them, so only use the cache if packages are rebuilt often.
+// Remove old packages with PruneCache.
+//
 // TempPath controls where intermediate files are written during the build. This
 // defaults to the system temp directory (usually /tmp).
 //
@@ -131,14 +277,39 @@ var (
 // can keep changes made to your config files, but if you want to upgrade the
 // config files themselves you will need to set UpgradeConfigs to true.
 //
+// FilenameTemplate is a Go template for the path of the .deb file inside the
+// build target. It defaults to package_version_arch.deb, as used by Debian
+// tools and repositories, with any epoch removed from the version. See
+// FilenameData for the available fields. The pool function returns the
+// directory of a package in a Debian pool, so a pool layout is:
+//
+//	"filenameTemplate": "pool/main/{{pool .Package}}/{{.Package}}/{{.Package}}_{{.Version}}_{{.Architecture}}.deb"
+//
 // PreserveSymlinks writes symlinks to the archive. By default the contents of
-// the file the symlink is pointing to is copied into the .deb package.
+// the file the symlink is pointing to is copied into the .deb package. Hard
+// links between files in the package are always preserved, so their contents
+// are only stored once.
+//
+// StripDebug strips debug info from ELF binaries before they are added to the
+// package. The debug info of binaries that have a GNU build id is moved to a
+// separate <package>-dbgsym package which is built alongside the main package.
+// Stripping is done by objcopy, which may be set to a different path (e.g. to
+// use a cross toolchain) using Objcopy.
+//
+// ShlibDepends adds the packages that provide the shared libraries used by
+// your ELF binaries to Depends, similar to dpkg-shlibdeps. Libraries are looked
+// up in the Shlibs file, which may be in shlibs or symbols format. If Shlibs
+// is not specified the shlibs and symbols files of the packages installed on
+// the build machine are used instead.
 //
 // Derived Fields
 //
 // InstalledSize is calculated based on the total size of your files and control
 // scripts. You should not specify this yourself.
 //
+// BuildIDs is set for -dbgsym packages created by StripDebug and lists the build
+// ids of the binaries the debug symbols belong to.
+//
 // For details on how to use pre/post/inst/rm and various .deb-specific fields
 // please refere to the debian package specification:
 //
@@ -153,6 +324,9 @@ type PackageSpec struct {
 	Maintainer   string `json:"maintainer"`
 	Description  string `json:"description"`
 
+	// Architectures builds one package per entry instead of using Architecture
+	Architectures []string `json:"architectures,omitempty"`
+
 	// Optional Fields
 	Depends    []string `json:"depends"`
 	PreDepends []string `json:"preDepends"`
@@ -169,15 +343,84 @@ type PackageSpec struct {
 	Prerm    string `json:"prerm"`
 	Postrm   string `json:"postrm"`
 
+	// debconf
+	Config    string `json:"config,omitempty"`
+	Templates string `json:"templates,omitempty"`
+
+	// Scripts assembled from snippets, keyed by script name
+	Scripts map[string][]ScriptSnippet `json:"scripts,omitempty"`
+
+	// Services
+	Systemd []SystemdUnit `json:"systemd,omitempty"`
+
+	// Documentation installed under /usr/share/doc/<package>
+	Copyright *Copyright `json:"copyright,omitempty"`
+	Changelog *Changelog `json:"changelog,omitempty"`
+
+	// Manual and info pages
+	Manpages  []string `json:"manpages,omitempty"`
+	InfoPages []string `json:"infoPages,omitempty"`
+
+	// Alternatives and diversions
+	Alternatives []Alternative `json:"alternatives,omitempty"`
+	Diversions   []Diversion   `json:"diversions,omitempty"`
+
+	// dpkg triggers
+	Triggers *Triggers `json:"triggers,omitempty"`
+
+	// Users, groups, and file ownership
+	Users    []User            `json:"users,omitempty"`
+	Groups   []Group           `json:"groups,omitempty"`
+	Sysusers bool              `json:"sysusers,omitempty"`
+	Owners   map[string]string `json:"owners,omitempty"`
+
+	// File capabilities
+	Capabilities     map[string]string `json:"capabilities,omitempty"`
+	CapabilityXattrs bool              `json:"capabilityXattrs,omitempty"`
+
+	// Source is the file system that AutoPath, Files, and the other paths in
+	// the spec are read from. It defaults to the host file system. When it is
+	// set, those paths must be relative and inside it.
+	Source fs.FS `json:"-"`
+
+	// BaseDir is the directory relative paths on the host are resolved
+	// against. It defaults to the working directory.
+	BaseDir string `json:"-"`
+
+	// OnProgress is called as files are read during the build
+	OnProgress func(Progress) `json:"-"`
+
+	// CacheDir is where Build caches packages. Caching is disabled if it is
+	// empty.
+	CacheDir string `json:"-"`
+
 	// Build time options
 	AutoPath         string            `json:"autoPath"` // Defaults to "deb-pkg"
 	Files            map[string]string `json:"files"`
 	TempPath         string            `json:"tempPath,omitempty"`
+	FilenameTemplate string            `json:"filenameTemplate,omitempty"`
 	PreserveSymlinks bool              `json:"preserveSymlinks,omitempty"`
 	UpgradeConfigs   bool              `json:"upgradeConfigs,omitempty"`
+	ShlibDepends     bool              `json:"shlibDepends,omitempty"`
+	Shlibs           string            `json:"shlibs,omitempty"`
+	StripDebug       bool              `json:"stripDebug,omitempty"`
+	Objcopy          string            `json:"objcopy,omitempty"` // Defaults to "objcopy"
 
 	// Derived fields
-	InstalledSize int64 `json:"-"` // Kilobytes, rounded up. Derived from file sizes.
+	InstalledSize int64    `json:"-"` // Kilobytes, rounded up. Derived from file sizes.
+	BuildIDs      []string `json:"-"` // Build ids of binaries in a -dbgsym package.
+
+	// Build state for StripDebug
+	stripped   map[string]string // source file -> stripped copy
+	debugFiles map[string]string // debug info file -> target path
+	buildIDs   []string
+
+	// Files generated during the build, such as sysusers.d config
+	generated map[string]string // generated file -> target path
+	workspace string            // build workspace holding generated files
+
+	cacheHit    bool // whether Build reused a cached package
+	cachedDebug bool // whether the cached package came with a -dbgsym package
 }
 
 // DefaultPackageSpec includes default values for package specifications. This
@@ -222,6 +465,9 @@ func NewPackageSpecFromFile(filename string) (*PackageSpec, error) {
 // Validate checks the syntax of various text fields in PackageSpec to verify
 // that they conform to the debian package specification. Errors from this call
 // should be passed to the user so they can fix errors in their config file.
+//
+// At build time the maintainer scripts are also linted, and a *ScriptLintError
+// is returned if they have errors.
 func (p *PackageSpec) Validate(buildTime bool) error {
 	// Verify required fields are specified
 	missing := []string{}
@@ -231,7 +477,7 @@ func (p *PackageSpec) Validate(buildTime bool) error {
 	if buildTime && p.Version == "" {
 		missing = append(missing, "version")
 	}
-	if p.Architecture == "" {
+	if p.Architecture == "" && (buildTime || len(p.Architectures) == 0) {
 		missing = append(missing, "architecture")
 	}
 	if p.Maintainer == "" {
@@ -243,9 +489,21 @@ func (p *PackageSpec) Validate(buildTime bool) error {
 	if len(missing) > 0 {
 		return fmt.Errorf("These required fields are missing: %s", strings.Join(missing, ", "))
 	}
-	if !hasString(supportedArchitectures, p.Architecture) {
-		return fmt.Errorf("Arch %q is not supported; expected one of %s",
-			p.Architecture, strings.Join(supportedArchitectures, ", "))
+	if p.Version != "" && !reVersion.MatchString(p.Version) {
+		return fmt.Errorf("Version %q is invalid; expected something like '1.2.3' or '1:1.2.3-1' matching %q", p.Version, reVersion.String())
+	}
+	if p.Architecture != "" && len(p.Architectures) > 0 {
+		return fmt.Errorf("Architecture %q and architectures [%s] are both set; use only one", p.Architecture, strings.Join(p.Architectures, ", "))
+	}
+	archs := p.Architectures
+	if p.Architecture != "" && !(p.Architecture == autoArchitecture && !buildTime) {
+		archs = append([]string{p.Architecture}, archs...)
+	}
+	for _, arch := range archs {
+		if !hasString(supportedArchitectures, arch) {
+			return fmt.Errorf("Arch %q is not supported; expected one of %s",
+				arch, strings.Join(supportedArchitectures, ", "))
+		}
 	}
 	for _, dep := range p.Depends {
 		if !reDepends.MatchString(dep) {
@@ -272,97 +530,225 @@ func (p *PackageSpec) Validate(buildTime bool) error {
 			return fmt.Errorf("Break %q is invalid; expected something like 'libc (<< 5.1.2)' matching %q", breaks, reReplacesEtc.String())
 		}
 	}
+	if err := p.validateSystemd(); err != nil {
+		return err
+	}
+	if err := p.validateUsers(); err != nil {
+		return err
+	}
+	if err := p.validateCapabilities(); err != nil {
+		return err
+	}
+	if err := p.validateDocs(); err != nil {
+		return err
+	}
+	if err := p.validateManpages(buildTime); err != nil {
+		return err
+	}
+	if err := p.validateAlternatives(); err != nil {
+		return err
+	}
+	if err := p.validateDiversions(); err != nil {
+		return err
+	}
+	if err := p.validateTriggers(); err != nil {
+		return err
+	}
+	if err := p.validateScripts(); err != nil {
+		return err
+	}
+	if err := p.validateFilename(buildTime); err != nil {
+		return err
+	}
+	if buildTime {
+		if err := p.verifyArchitecture(); err != nil {
+			return err
+		}
+		if err := p.validateTemplates(); err != nil {
+			return err
+		}
+		if err := p.lintControlScripts(); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
-// Filename derives the standard debian filename as package-version-arch.deb
-// based on the data specified in PackageSpec.
+// ArchitectureSpecs returns one PackageSpec for each architecture the package
+// should be built for. If Architectures is empty this is a single spec for
+// Architecture. Each spec is a copy with {{.Arch}} expanded in Files.
+func (p *PackageSpec) ArchitectureSpecs() ([]*PackageSpec, error) {
+	archs := p.Architectures
+	if len(archs) == 0 {
+		archs = []string{p.Architecture}
+	}
+	specs := []*PackageSpec{}
+	for _, arch := range archs {
+		spec, err := p.ForArchitecture(arch)
+		if err != nil {
+			return nil, err
+		}
+		specs = append(specs, spec)
+	}
+	return specs, nil
+}
+
+// ForArchitecture returns a copy of this PackageSpec for a single CPU
+// architecture. Any {{.Arch}} placeholders in the Files map are replaced with
+// arch.
+func (p *PackageSpec) ForArchitecture(arch string) (*PackageSpec, error) {
+	spec := *p
+	spec.Architecture = arch
+	spec.Architectures = nil
+	spec.Files = make(map[string]string, len(p.Files))
+	for src, dest := range p.Files {
+		archSrc, err := expandArch(src, arch)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid source path %q: %s", src, err)
+		}
+		archDest, err := expandArch(dest, arch)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid destination path %q: %s", dest, err)
+		}
+		spec.Files[archSrc] = archDest
+	}
+	return &spec, nil
+}
+
+// Filename derives the filename of the package from FilenameTemplate, which
+// defaults to the standard debian filename package_version_arch.deb. The name
+// may include directories. If the template is invalid the default is used;
+// Validate reports invalid templates.
 func (p *PackageSpec) Filename() string {
-	return fmt.Sprintf("%s-%s-%s.deb", p.Package, p.Version, p.Architecture)
+	filename, err := p.RenderFilename()
+	if err != nil {
+		spec := *p
+		spec.FilenameTemplate = ""
+		filename, _ = spec.RenderFilename()
+	}
+	return filename
 }
 
 // Build creates a .deb file in the target directory. The name is defived from
 // Filename() so you can find it with:
 //
 //	path.Join(target, PackageSpec.Filename())
+//
+// Use WriteTo or NewBuilder to write the package somewhere other than a file.
 func (p *PackageSpec) Build(target string) error {
-	err := p.Validate(true)
-	if err != nil {
+	return p.BuildContext(context.Background(), target)
+}
+
+// BuildContext is like Build, but stops when ctx is cancelled. Partially
+// written packages are removed.
+func (p *PackageSpec) BuildContext(ctx context.Context, target string) error {
+	p.cacheHit, p.cachedDebug = false, false
+	if err := p.prepare(); err != nil {
 		return err
 	}
-	ws, err := ioutil.TempDir(p.TempPath, "mkdeb")
-	if err != nil {
-		return fmt.Errorf("Could not create build workspace: %v", err)
+	if p.CacheDir == "" {
+		_, err := p.buildPackages(ctx, target)
+		return err
 	}
-	defer func() {
-		err := os.RemoveAll(ws) // clean up
-		if err != nil {
-			log.Printf("Error cleaning up build workspace '%v': %v", ws, err)
-		}
-	}()
-
-	// 1. Create binary package (tar.gz format)
-	// 2. Create control file package (tar.gz format)
-	// 3. Create .deb / package (ar archive format)
+	return p.buildWithCache(ctx, target)
+}
 
-	err = os.MkdirAll(target, 0755)
+// buildPackages builds a prepared spec into target, along with the -dbgsym
+// package if binaries were stripped. It returns the files it wrote, keyed by
+// their names in a cache entry.
+func (p *PackageSpec) buildPackages(ctx context.Context, target string) (map[string]string, error) {
+	b, err := p.newBuilder(ctx)
 	if err != nil {
-		return fmt.Errorf("Unable to create target directory %q: %s", target, err)
+		return nil, err
 	}
+	defer b.Close()
 
-	file, err := os.Create(path.Join(target, p.Filename()))
+	filename := path.Join(target, p.Filename())
+	err = writePackageFile(filename, func(w io.Writer) (int64, error) {
+		return b.WriteToContext(ctx, w)
+	})
 	if err != nil {
-		return fmt.Errorf("Failed to create build target: %s", err)
+		return nil, err
 	}
+	files := map[string]string{cachePackage: filename}
 
-	archive := ar.NewWriter(file)
-
-	archiveCreationTime := time.Now()
-
-	baseHeader := ar.Header{
-		ModTime: archiveCreationTime,
-		Uid:     0,
-		Gid:     0,
-		Mode:    0600,
+	// Build the -dbgsym package while the debug files are still in workspace
+	if b.HasDebugPackage() {
+		files[cacheDebugPackage] = path.Join(target, p.DebugFilename())
+		err := writePackageFile(files[cacheDebugPackage], func(w io.Writer) (int64, error) {
+			return b.WriteDebugToContext(ctx, w)
+		})
+		if err != nil {
+			// Don't leave half of the build behind
+			os.Remove(filename)
+			return nil, fmt.Errorf("Failed to build debug symbols package: %s", err)
+		}
 	}
+	return files, nil
+}
 
-	// Write the debian binary version (hard-coded to 2.0)
-	if err := writeBytesToAr(archive, baseHeader, "debian-binary", []byte("2.0\n")); err != nil {
-		return fmt.Errorf("Failed to write debian-binary: %s", err)
+// writePackageFile creates filename and its directory and writes a package to
+// it. The file is removed again if writing fails.
+func writePackageFile(filename string, write func(io.Writer) (int64, error)) error {
+	if err := os.MkdirAll(path.Dir(filename), 0755); err != nil {
+		return fmt.Errorf("Unable to create target directory %q: %s", path.Dir(filename), err)
 	}
-
-	controlFile := filepath.Join(ws, "control.tar.gz")
-	if err := p.CreateControlArchive(controlFile); err != nil {
-		return fmt.Errorf("Failed to compress control files: %s", err)
+	file, err := os.Create(filename)
+	if err != nil {
+		return fmt.Errorf("Failed to create build target: %s", err)
 	}
-
-	// Copy the control file archive into ar (.deb)
-	if err := writeFileToAr(archive, baseHeader, controlFile); err != nil {
+	if _, err := write(file); err != nil {
+		file.Close()
+		os.Remove(filename)
 		return err
 	}
-
-	dataFile := filepath.Join(ws, "data.tar.gz")
-	if err := p.CreateDataArchive(dataFile); err != nil {
-		return fmt.Errorf("Failed to compress data files: %s", err)
+	if err := file.Close(); err != nil {
+		os.Remove(filename)
+		return err
 	}
+	return nil
+}
 
-	// Copy the data archive into the ar (.deb)
-	if err := writeFileToAr(archive, baseHeader, dataFile); err != nil {
-		return err
+// ControlFile is a file in the control archive of a package
+type ControlFile struct {
+	Name string
+	Mode int64
+	Data []byte
+}
+
+// RenderControlFiles returns the control files that are rendered from the spec:
+// triggers, debconf templates, and the maintainer scripts including the
+// debconf config script, in the order they are added to the package. The
+// control file, md5sums, and conffiles depend on the files in the package, so
+// they are not included.
+func (p *PackageSpec) RenderControlFiles() ([]ControlFile, error) {
+	files := []ControlFile{}
+	if triggers := p.RenderTriggers(); triggers != nil {
+		files = append(files, ControlFile{Name: "triggers", Mode: 0644, Data: triggers})
+	}
+	if templates, ok := p.MapControlFiles()["templates"]; ok {
+		data, err := p.readFile(templates)
+		if err != nil {
+			return nil, fmt.Errorf("Failed reading templates %q: %s", templates, err)
+		}
+		files = append(files, ControlFile{Name: "templates", Mode: 0644, Data: data})
 	}
 
-	if err := archive.Close(); err != nil {
-		return err
+	scripts, err := p.RenderControlScripts()
+	if err != nil {
+		return nil, err
 	}
-	if err := file.Close(); err != nil {
-		return err
+	for _, name := range controlFiles {
+		if script, ok := scripts[name]; ok {
+			files = append(files, ControlFile{Name: name, Mode: 0755, Data: script})
+		}
 	}
-	return nil
+	return files, nil
 }
 
 // RenderControlFile creates a debian control file for this package.
 func (p *PackageSpec) RenderControlFile() ([]byte, error) {
-	t, err := template.New("controlfile").Funcs(template.FuncMap{"join": join}).Parse(controlFileTemplate)
+	t, err := template.New("controlfile").Funcs(template.FuncMap{"join": join, "fields": fields}).Parse(controlFileTemplate)
 	if err != nil {
 		// This should only happen if the template itself is messed up, which
 		// means the code has an error (not a user error)
@@ -390,29 +776,33 @@ func (p *PackageSpec) ListFiles(includeDirs bool) ([]string, error) {
 	targets := map[string]struct{}{}
 
 	// First, grab all the files in AutoPath that are not control files
-	if p.AutoPath != "" && p.AutoPath != "-" && FileExists(p.AutoPath) {
-		if err := filepath.Walk(p.AutoPath, func(filepath string, info os.FileInfo, err2 error) error {
+	if p.AutoPath != "" && p.AutoPath != "-" && p.fileExists(p.AutoPath) {
+		fsys, root, err := p.sourceFS(p.AutoPath)
+		if err != nil {
+			return nil, err
+		}
+		if err := fs.WalkDir(fsys, root, func(filename string, entry fs.DirEntry, err2 error) error {
 			if err2 != nil {
 				return err2
 			}
 
 			// Skip directories if instructed
-			if !includeDirs && info.IsDir() {
+			if !includeDirs && entry.IsDir() {
 				return nil
 			}
 
 			// Skip control files
-			if hasString(controlFiles, path.Base(filepath)) {
+			if p.isControlFile(filename) {
 				return nil
 			}
-			files = append(files, filepath)
-			target, err := p.NormalizeFilename(filepath)
+			files = append(files, filename)
+			target, err := p.NormalizeFilename(filename)
 			if err != nil {
 				return err
 			}
 			if _, ok := targets[target]; ok {
 				// This is an odd edge case; it should probably never happen
-				return fmt.Errorf("Duplicate file detected from AutoPath: %s", filepath)
+				return fmt.Errorf("Duplicate file detected from AutoPath: %s", filename)
 			}
 			targets[target] = struct{}{}
 			return nil
@@ -421,7 +811,8 @@ func (p *PackageSpec) ListFiles(includeDirs bool) ([]string, error) {
 		}
 	}
 
-	for src := range p.Files {
+	// Sort the maps so the archive is the same every time
+	for _, src := range sortedKeys(p.Files) {
 		target, err := p.NormalizeFilename(src)
 		if err != nil {
 			return files, err
@@ -435,6 +826,18 @@ func (p *PackageSpec) ListFiles(includeDirs bool) ([]string, error) {
 		files = append(files, src)
 	}
 
+	for _, src := range sortedKeys(p.installedFiles()) {
+		target, err := p.NormalizeFilename(src)
+		if err != nil {
+			return files, err
+		}
+		if _, ok := targets[target]; ok {
+			return files, fmt.Errorf("Duplicate file detected: %s", target)
+		}
+		targets[target] = struct{}{}
+		files = append(files, src)
+	}
+
 	return files, nil
 }
 
@@ -469,7 +872,8 @@ func (p *PackageSpec) ListEtcFiles() ([]string, error) {
 }
 
 // MapControlFiles returns a list of optional control scripts including
-// pre/post/inst/rm that are used in this package.
+// pre/post/inst/rm and the debconf config and templates that are used in this
+// package.
 func (p *PackageSpec) MapControlFiles() map[string]string {
 	files := map[string]string{}
 
@@ -479,7 +883,7 @@ func (p *PackageSpec) MapControlFiles() map[string]string {
 		files["preinst"] = p.Preinst
 	} else if p.AutoPath != "" && p.AutoPath != "-" {
 		filename := path.Join(p.AutoPath, "preinst")
-		if FileExists(filename) {
+		if p.fileExists(filename) {
 			files["preinst"] = filename
 		}
 	}
@@ -488,7 +892,7 @@ func (p *PackageSpec) MapControlFiles() map[string]string {
 		files["postinst"] = p.Postinst
 	} else if p.AutoPath != "" && p.AutoPath != "-" {
 		filename := path.Join(p.AutoPath, "postinst")
-		if FileExists(filename) {
+		if p.fileExists(filename) {
 			files["postinst"] = filename
 		}
 	}
@@ -497,7 +901,7 @@ func (p *PackageSpec) MapControlFiles() map[string]string {
 		files["prerm"] = p.Prerm
 	} else if p.AutoPath != "" && p.AutoPath != "-" {
 		filename := path.Join(p.AutoPath, "prerm")
-		if FileExists(filename) {
+		if p.fileExists(filename) {
 			files["prerm"] = filename
 		}
 	}
@@ -506,44 +910,89 @@ func (p *PackageSpec) MapControlFiles() map[string]string {
 		files["postrm"] = p.Postrm
 	} else if p.AutoPath != "" && p.AutoPath != "-" {
 		filename := path.Join(p.AutoPath, "postrm")
-		if FileExists(filename) {
+		if p.fileExists(filename) {
 			files["postrm"] = filename
 		}
 	}
 
+	if p.Config != "" {
+		files["config"] = p.Config
+	} else if p.AutoPath != "" && p.AutoPath != "-" {
+		filename := path.Join(p.AutoPath, "config")
+		if p.fileExists(filename) {
+			files["config"] = filename
+		}
+	}
+
+	if p.Templates != "" {
+		files["templates"] = p.Templates
+	} else if p.AutoPath != "" && p.AutoPath != "-" {
+		filename := path.Join(p.AutoPath, "templates")
+		if p.fileExists(filename) {
+			files["templates"] = filename
+		}
+	}
+
+	return files
+}
+
+// installedFiles maps files that are installed by options other than Files and
+// AutoPath to their target paths. This includes files generated by Build.
+func (p *PackageSpec) installedFiles() map[string]string {
+	files := p.systemdFiles()
+	for src, dest := range p.generated {
+		files[src] = dest
+	}
 	return files
 }
 
+// writeGeneratedFiles creates files that are generated from the package spec
+// in the build workspace so they can be included in the package.
+func (p *PackageSpec) writeGeneratedFiles(workspace string) error {
+	if err := p.writeSysusers(workspace); err != nil {
+		return err
+	}
+	if err := p.writeDocs(workspace); err != nil {
+		return err
+	}
+	return p.writeManpages(workspace)
+}
+
+// addGeneratedFile adds a file generated during the build to the package
+func (p *PackageSpec) addGeneratedFile(filename, target string) {
+	if p.generated == nil {
+		p.generated = map[string]string{}
+	}
+	p.generated[filename] = target
+}
+
 // CalculateSize returns the size in Kilobytes of all files in the package.
 func (p *PackageSpec) CalculateSize() (int64, error) {
-	size := int64(0)
-
-	files, err := p.ListFiles(false)
+	payload, err := p.walkPayload()
 	if err != nil {
 		return 0, err
 	}
+	return p.installedSize(payload)
+}
 
-	controlFiles := p.MapControlFiles()
-	controlFilesList := []string{}
-	for _, item := range controlFiles {
-		controlFilesList = append(controlFilesList, item)
-	}
-
-	// Merge list of control files and data files so we can get the whole size
-	files = append(files, controlFilesList...)
+// installedSize returns the size in Kilobytes of the files in the payload and
+// the control files that dpkg keeps after installing the package
+func (p *PackageSpec) installedSize(payload *payload) (int64, error) {
+	size := payload.size()
 
-	for _, file := range files {
-		var fileinfo os.FileInfo
-		var err error
-		if p.PreserveSymlinks {
-			fileinfo, err = os.Lstat(file)
-		} else {
-			fileinfo, err = os.Stat(file)
-		}
+	scripts, err := p.RenderControlScripts()
+	if err != nil {
+		return 0, err
+	}
+	for _, script := range scripts {
+		size += int64(len(script))
+	}
+	if templates, ok := p.MapControlFiles()["templates"]; ok {
+		info, err := p.statFile(templates)
 		if err != nil {
-			return 0, fmt.Errorf("Failed to stat %q: %s", file, err)
+			return 0, fmt.Errorf("Failed to stat %q: %s", templates, err)
 		}
-		size += fileinfo.Size()
+		size += info.Size()
 	}
 
 	// Convert size from bytes to kilobytes. If there is a remainder, round up.
@@ -564,28 +1013,17 @@ func (p *PackageSpec) CalculateSize() (int64, error) {
 //
 // All files returned by ListFiles() are included
 func (p *PackageSpec) CalculateChecksums() ([]byte, error) {
-	data := []byte{}
-	files, err := p.ListFiles(false)
+	payload, err := p.walkPayload()
 	if err != nil {
-		return data, err
+		return nil, err
 	}
-
-	for _, file := range files {
-		sum, err := md5SumFile(file)
-		if err != nil {
-			return data, err
-		}
-		normFile, err := p.NormalizeFilename(file)
-		if err != nil {
-			return data, err
-		}
-		data = append(data, []byte(sum+"  "+normFile+"\n")...)
+	if err := p.readPayload(context.Background(), nil, payload, ProgressHashing); err != nil {
+		return nil, err
 	}
-
-	return data, nil
+	return payload.md5sums(), nil
 }
 
-// CreateDataArchive creates
+// CreateDataArchive creates the data.tar.gz part of the .deb package at target
 func (p *PackageSpec) CreateDataArchive(target string) error {
 	file, err := os.Create(target)
 	if err != nil {
@@ -593,57 +1031,14 @@ func (p *PackageSpec) CreateDataArchive(target string) error {
 	}
 	defer file.Close()
 
-	// Create a compressed archive stream
-	zipwriter := pgzip.NewWriter(file)
-	defer zipwriter.Close()
-	archive := tar.NewWriter(zipwriter)
-	defer archive.Close()
-
-	files, err := p.ListFiles(true)
+	payload, err := p.walkPayload()
 	if err != nil {
 		return err
 	}
-
-	for _, filename := range files {
-		target, err := p.NormalizeFilename(filename)
-		if err != nil {
-			return err
-		}
-
-		info, err := os.Stat(filename)
-		if err != nil {
-			return err
-		}
-
-		header, err := tar.FileInfoHeader(info, filename)
-		if err != nil {
-			return err
-		}
-
-		header.Name = target
-		header.Uid = 0
-		header.Gid = 0
-		header.Uname = "root"
-		header.Gname = "root"
-
-		archive.WriteHeader(header)
-		if !info.IsDir() {
-			dataFile, err := os.Open(filename)
-
-			if err != nil {
-				return err
-			}
-
-			_, err = io.Copy(archive, dataFile)
-			dataFile.Close()
-
-			if err != nil {
-				return err
-			}
-		}
+	if err := p.readPayload(context.Background(), file, payload, ProgressCompressing); err != nil {
+		return err
 	}
-
-	return nil
+	return file.Close()
 }
 
 // CreateControlArchive creates the control.tar.gz part of the .deb package
@@ -652,9 +1047,11 @@ func (p *PackageSpec) CreateDataArchive(target string) error {
 //	conffiles
 //	md5sums
 //	control
-//	pre/post/inst/rm scripts (if any)
+//	triggers (if any)
+//	templates (if any)
+//	pre/post/inst/rm and config scripts (if any)
 //
-// You must pass in a file handle that is open for writing.
+// The archive is written to the file target.
 func (p *PackageSpec) CreateControlArchive(target string) error {
 	file, err := os.Create(target)
 	if err != nil {
@@ -662,78 +1059,76 @@ func (p *PackageSpec) CreateControlArchive(target string) error {
 	}
 	defer file.Close()
 
+	payload, err := p.walkPayload()
+	if err != nil {
+		return err
+	}
+	if err := p.readPayload(context.Background(), nil, payload, ProgressHashing); err != nil {
+		return err
+	}
+	if err := p.writeControlArchive(file, payload, time.Now()); err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// writeControlArchive writes the control archive to w, using modTime for the
+// files in it. The payload must have been read so the checksums are known.
+func (p *PackageSpec) writeControlArchive(w io.Writer, payload *payload, modTime time.Time) error {
 	// Create a compressed archive stream
-	zipwriter := pgzip.NewWriter(file)
-	defer zipwriter.Close()
+	zipwriter := pgzip.NewWriter(w)
 	archive := tar.NewWriter(zipwriter)
-	defer archive.Close()
 
 	header := tar.Header{
 		Mode:    0644,
 		Uid:     0,
 		Gid:     0,
-		ModTime: time.Now(),
+		ModTime: modTime,
 		Uname:   "root",
 		Gname:   "root",
 	}
 
 	// Add md5sums
-	sumData, err := p.CalculateChecksums()
-	if err != nil {
+	if err := writeBytesToTar(archive, header, "md5sums", payload.md5sums()); err != nil {
 		return err
 	}
-	sumHeader := header
-	sumHeader.Name = "md5sums"
-	sumHeader.Size = int64(len(sumData))
-	archive.WriteHeader(&sumHeader)
-	archive.Write(sumData)
 
 	// Add conffiles
-	confFiles, err := p.ListEtcFiles()
-	if err != nil {
-		return err
+	confFiles := []string{}
+	if !p.UpgradeConfigs {
+		confFiles = payload.etcFiles()
 	}
 	confData := []byte(strings.Join(confFiles, "\n") + "\n")
-	confHeader := header
-	confHeader.Name = "conffiles"
-	confHeader.Size = int64(len(confData))
-	archive.WriteHeader(&confHeader)
-	archive.Write(confData)
+	if err := writeBytesToTar(archive, header, "conffiles", confData); err != nil {
+		return err
+	}
 
 	// Add control file
 	controlData, err := p.RenderControlFile()
 	if err != nil {
 		return err
 	}
-	controlHeader := header
-	controlHeader.Name = "control"
-	controlHeader.Size = int64(len(controlData))
-	archive.WriteHeader(&controlHeader)
-	archive.Write(controlData)
+	if err := writeBytesToTar(archive, header, "control", controlData); err != nil {
+		return err
+	}
 
-	// Add control scripts
-	scripts := p.MapControlFiles()
+	// Add triggers, debconf templates, and control scripts
+	files, err := p.RenderControlFiles()
 	if err != nil {
 		return err
 	}
-	for target, script := range scripts {
-		scriptData, err := ioutil.ReadFile(script)
-		if err != nil {
-			return fmt.Errorf("Failed reading script %q: %s", script, err)
-		}
-
-		scriptHeader := header
-		scriptHeader.Mode = 0755
-		scriptHeader.Name = target
-		if err != nil {
+	for _, file := range files {
+		fileHeader := header
+		fileHeader.Mode = file.Mode
+		if err := writeBytesToTar(archive, fileHeader, file.Name, file.Data); err != nil {
 			return err
 		}
-		scriptHeader.Size = int64(len(scriptData))
-		archive.WriteHeader(&scriptHeader)
-		archive.Write(scriptData)
 	}
 
-	return nil
+	if err := archive.Close(); err != nil {
+		return err
+	}
+	return zipwriter.Close()
 }
 
 // NormalizeFilename converts a local filename into a target archive filename
@@ -744,6 +1139,9 @@ func (p *PackageSpec) NormalizeFilename(filename string) (string, error) {
 	if target, ok := p.Files[filename]; ok {
 		return path.Join(".", target), nil
 	}
+	if target, ok := p.installedFiles()[filename]; ok {
+		return path.Join(".", target), nil
+	}
 	if p.AutoPath != "" && p.AutoPath != "-" {
 		fpath, err := filepath.Rel(p.AutoPath, filename)
 		if err != nil {
@@ -765,6 +1163,16 @@ func SupportedArchitectures() []string {
 	return supportedArchitectures
 }
 
+// sortedKeys returns the keys of m in order
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
 func hasString(items []string, search string) bool {
 	for _, item := range items {
 		if item == search {
@@ -774,20 +1182,18 @@ func hasString(items []string, search string) bool {
 	return false
 }
 
-func md5SumFile(path string) (string, error) {
-	file, err := os.Open(path)
-	if err != nil {
-		return "", err
+// writeBytesToTar adds a file to a tar archive
+func writeBytesToTar(archive *tar.Writer, header tar.Header, name string, data []byte) error {
+	header.Name = name
+	header.Size = int64(len(data))
+	normalizeTarHeader(&header)
+	if err := archive.WriteHeader(&header); err != nil {
+		return fmt.Errorf("Failed writing tar header for %q: %s", name, err)
 	}
-
-	hash := md5.New()
-	_, err = io.Copy(hash, file)
-	if err != nil {
-		return "", err
+	if _, err := archive.Write(data); err != nil {
+		return fmt.Errorf("Failed writing tar data for %q: %s", name, err)
 	}
-
-	sum := hash.Sum([]byte{})
-	return hex.EncodeToString(sum), nil
+	return nil
 }
 
 func writeBytesToAr(archive *ar.Writer, header ar.Header, name string, data []byte) error {
@@ -806,51 +1212,53 @@ func writeBytesToAr(archive *ar.Writer, header ar.Header, name string, data []by
 	return nil
 }
 
-func writeFileToAr(archive *ar.Writer, header ar.Header, source string) error {
-	header.Name = filepath.Base(source)
-	file, err := os.Open(source)
-	if err != nil {
-		return err
+// expandArch renders a Files path template for the given architecture
+func expandArch(filename, arch string) (string, error) {
+	if !strings.Contains(filename, "{{") {
+		return filename, nil
 	}
-	info, err := file.Stat()
+	t, err := template.New("path").Option("missingkey=error").Parse(filename)
 	if err != nil {
-		return fmt.Errorf("Failed to stat %q to write ar header size: %s", file.Name(), err)
-	}
-
-	header.Size = info.Size()
-	if err := archive.WriteHeader(&header); err != nil {
-		return fmt.Errorf("Failed writing ar header for %q: %s", source, err)
+		return "", err
 	}
-	if numbytes, err := io.Copy(archive, file); err != nil {
-		return fmt.Errorf("Failed writing ar data for %q (had %d, wrote %d): %s",
-			source, info.Size(), numbytes, err)
+	buf := &bytes.Buffer{}
+	if err := t.Execute(buf, struct{ Arch string }{arch}); err != nil {
+		return "", err
 	}
-	return nil
+	return buf.String(), nil
 }
 
 func join(s []string) string {
 	return strings.Join(s, ", ")
 }
 
+func fields(s []string) string {
+	return strings.Join(s, " ")
+}
+
 const controlFileTemplate = `Package: {{ .Package }}
 Version: {{ .Version }}
 Architecture: {{ .Architecture}}
 Maintainer: {{ .Maintainer }}
 Installed-Size: {{ .InstalledSize }}
-{{- if (len .PreDepends) gt 0 }}
+{{- if gt (len .PreDepends) 0 }}
 Pre-Depends: {{ join .PreDepends }}
 {{- end -}}
-{{- if (len .Depends) gt 0 }}
+{{- if gt (len .Depends) 0 }}
 Depends: {{ join .Depends }}
 {{- end -}}
-{{- if (len .Conflicts) gt 0 }}
+{{- if gt (len .Conflicts) 0 }}
 Conflicts: {{ join .Conflicts }}
 {{- end -}}
-{{- if (len .Breaks) gt 0 }}
+{{- if gt (len .Breaks) 0 }}
 Breaks: {{ join .Breaks }}
 {{- end -}}
-{{- if (len .Replaces) gt 0 }}
+{{- if gt (len .Replaces) 0 }}
 Replaces: {{ join .Replaces }}
+{{- end -}}
+{{- if gt (len .BuildIDs) 0 }}
+Auto-Built-Package: debug-symbols
+Build-Ids: {{ fields .BuildIDs }}
 {{- end }}
 Section: {{ .Section }}
 Priority: {{ .Priority }}
@@ -1,6 +1,10 @@
 package deb
 
 import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"io"
 	"io/ioutil"
 	"os"
 	"path"
@@ -9,15 +13,68 @@ import (
 	"testing"
 )
 
-func PackageSpecFixture(t *testing.T) *PackageSpec {
+// PackageSpecFixture loads the basic example package. The configs named by
+// overlays are read from test-fixtures on top of it, so tests for a feature
+// only need a config with the fields for that feature.
+func PackageSpecFixture(t *testing.T, overlays ...string) *PackageSpec {
 	p, err := NewPackageSpecFromFile(path.Join("test-fixtures", "example-basic.json"))
 	if err != nil {
 		t.Fatalf("Failed to load fixture: %s", err)
 	}
 	p.AutoPath = path.Join("test-fixtures", "package1")
+	for _, overlay := range overlays {
+		data, err := ioutil.ReadFile(path.Join("test-fixtures", overlay))
+		if err != nil {
+			t.Fatalf("Failed to load fixture: %s", err)
+		}
+		if err := json.Unmarshal(data, p); err != nil {
+			t.Fatalf("Failed to load fixture %s: %s", overlay, err)
+		}
+	}
 	return p
 }
 
+// buildArchiveFiles writes an archive with create, such as
+// CreateControlArchive, and returns the contents of the files in it
+func buildArchiveFiles(t *testing.T, create func(target string) error) map[string][]byte {
+	dir, err := ioutil.TempDir("", "mkdeb-archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "archive.tar.gz")
+	if err := create(filename); err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	zipreader, err := gzip.NewReader(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	archive := tar.NewReader(zipreader)
+	files := map[string][]byte{}
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadAll(archive)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[header.Name] = data
+	}
+	return files
+}
+
 func TestDefaultPackageSpec(t *testing.T) {
 	p := DefaultPackageSpec()
 	expected := "deb-pkg"
@@ -32,7 +89,7 @@ func TestFilename(t *testing.T) {
 		Version:      "0.1.0",
 		Architecture: "amd64",
 	}
-	expected := "mkdeb-0.1.0-amd64.deb"
+	expected := "mkdeb_0.1.0_amd64.deb"
 	if p.Filename() != expected {
 		t.Fatalf("Expected filename to be %q, got %q", expected, p.Filename())
 	}
@@ -54,6 +111,67 @@ func TestValidate(t *testing.T) {
 	}
 }
 
+func TestValidateArchitectures(t *testing.T) {
+	p := PackageSpecFixture(t)
+	p.Architecture = ""
+	p.Architectures = []string{"amd64", "arm64"}
+
+	if err := p.Validate(false); err != nil {
+		t.Fatal(err)
+	}
+
+	p.Architectures = []string{"amd64", "sparc"}
+	err := p.Validate(false)
+	if err == nil || !strings.Contains(err.Error(), "sparc") {
+		t.Fatalf("Expected unsupported arch error; found %+v", err)
+	}
+
+	// Architecture would be ignored, so both may not be set
+	p.Architecture = "amd64"
+	p.Architectures = []string{"arm64"}
+	err = p.Validate(false)
+	if err == nil || !strings.Contains(err.Error(), "use only one") {
+		t.Fatalf("Expected error for both architecture and architectures; found %+v", err)
+	}
+}
+
+func TestArchitectureSpecs(t *testing.T) {
+	p := PackageSpecFixture(t)
+	p.Version = "0.1.0"
+	p.Architecture = ""
+	p.Architectures = []string{"amd64", "arm64"}
+	p.Files = map[string]string{
+		"dist/{{.Arch}}/magic": "/usr/local/bin/magic",
+	}
+
+	specs, err := p.ArchitectureSpecs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(specs) != 2 {
+		t.Fatalf("Expected 2 specs, got %d", len(specs))
+	}
+
+	for i, arch := range p.Architectures {
+		spec := specs[i]
+		if spec.Architecture != arch {
+			t.Errorf("Expected architecture %q got %q", arch, spec.Architecture)
+		}
+		src := "dist/" + arch + "/magic"
+		if _, ok := spec.Files[src]; !ok {
+			t.Errorf("Expected %q in files: %+v", src, spec.Files)
+		}
+		expected := "mkdeb_0.1.0_" + arch + ".deb"
+		if spec.Filename() != expected {
+			t.Errorf("Expected filename to be %q, got %q", expected, spec.Filename())
+		}
+	}
+
+	if _, ok := p.Files["dist/{{.Arch}}/magic"]; !ok {
+		t.Errorf("Original spec was modified: %+v", p.Files)
+	}
+}
+
 func TestListControlFiles(t *testing.T) {
 	p := PackageSpecFixture(t)
 
@@ -175,18 +293,6 @@ func TestUpgradeConfig(t *testing.T) {
 	}
 }
 
-func TestMD5SumFile(t *testing.T) {
-	sum, err := md5SumFile(path.Join("test-fixtures", "example-depends.json"))
-	if err != nil {
-		t.Fatal(err)
-	}
-
-	expected := "77d87ca6af3e6710a1faf86aaed5b800"
-	if sum != expected {
-		t.Errorf("Expected %q got %q", expected, sum)
-	}
-}
-
 func TestCalculateChecksums(t *testing.T) {
 	p := PackageSpecFixture(t)
 
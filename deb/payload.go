@@ -0,0 +1,400 @@
+package deb
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/klauspost/pgzip"
+)
+
+const (
+	// hashChunkSize is how much of a file is read at once. Each chunk is
+	// written to the data archive and then hashed by a worker.
+	hashChunkSize = 256 * 1024
+
+	// tarFormat is used for every header in the data and control archives.
+	// dpkg-deb writes GNU tar archives too, and every version of dpkg reads
+	// their long names (over 100 characters) and large sizes (8 GiB and up).
+	// Otherwise Go picks USTAR, PAX, or GNU depending on each header, so
+	// the format of an entry would depend on details like its timestamp.
+	tarFormat = tar.FormatGNU
+)
+
+// FileChecksum holds the checksums of a file in the package
+type FileChecksum struct {
+	Path   string // Installed path, e.g. /usr/bin/foo
+	Size   int64
+	MD5    string
+	SHA256 string
+}
+
+// payloadFile is a file or directory in the data archive
+type payloadFile struct {
+	source string       // Source path, as returned by ListFiles
+	target string       // Path in the archive, e.g. usr/bin/foo
+	info   fs.FileInfo  // Of the content, which may be a stripped copy
+	link   *payloadFile // The first file in the payload this is a hard link to
+	md5    string
+	sha256 string
+}
+
+// regular reports whether the file has contents that are read and hashed.
+// Hard links are not read, since they share the contents of another file.
+func (f *payloadFile) regular() bool {
+	return f.link == nil && f.info.Mode().IsRegular()
+}
+
+// fileID identifies a file on the host, to find hard links
+type fileID struct {
+	dev uint64
+	ino uint64
+}
+
+// payload is the list of files in the data archive. It is created by walking
+// the files once, and then used for the checksums, conffiles, size, and data
+// archive.
+type payload struct {
+	files []*payloadFile
+}
+
+// walkPayload lists and stats the files in the package, including
+// directories, in the order they are written to the data archive
+func (p *PackageSpec) walkPayload() (*payload, error) {
+	files, err := p.ListFiles(true)
+	if err != nil {
+		return nil, err
+	}
+	payload := &payload{}
+	links := map[fileID]*payloadFile{}
+	for _, filename := range files {
+		target, err := p.NormalizeFilename(filename)
+		if err != nil {
+			return nil, err
+		}
+		info, err := p.statFile(p.contentPath(filename))
+		if err != nil {
+			return nil, fmt.Errorf("Failed to stat %q: %s", filename, err)
+		}
+		file := &payloadFile{
+			source: filename,
+			target: target,
+			info:   info,
+		}
+		payload.files = append(payload.files, file)
+
+		// Hard links are found in the source, since stripped copies of linked
+		// binaries are separate files with the same contents. Symlinks are
+		// not followed, so only real hard links are found.
+		if !file.regular() {
+			continue
+		}
+		source, err := p.lstatFile(filename)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to stat %q: %s", filename, err)
+		}
+		if id, ok := hostFileID(source); ok {
+			if first, ok := links[id]; ok {
+				file.link = first
+			} else {
+				links[id] = file
+			}
+		}
+	}
+	if err := p.checkCapabilityFiles(payload); err != nil {
+		return nil, err
+	}
+	return payload, nil
+}
+
+// sourceFiles returns the source paths of the files that are read, which
+// excludes directories and hard links
+func (pl *payload) sourceFiles() []string {
+	files := []string{}
+	for _, file := range pl.files {
+		if !file.info.IsDir() && file.link == nil {
+			files = append(files, file.source)
+		}
+	}
+	return files
+}
+
+// size returns the total size of the files in bytes. Like dpkg, the contents
+// of hard linked files are only counted once.
+func (pl *payload) size() int64 {
+	size := int64(0)
+	for _, file := range pl.files {
+		if !file.info.IsDir() && file.link == nil {
+			size += file.info.Size()
+		}
+	}
+	return size
+}
+
+// etcFiles returns the files installed under /etc, which are conffiles
+func (pl *payload) etcFiles() []string {
+	etcFiles := []string{}
+	for _, file := range pl.files {
+		if !file.info.IsDir() && strings.HasPrefix(file.target, "etc") {
+			etcFiles = append(etcFiles, "/"+file.target)
+		}
+	}
+	return etcFiles
+}
+
+// md5sums returns the contents of the md5sums control file, which lists hard
+// links like any other file. The payload must have been read first.
+func (pl *payload) md5sums() []byte {
+	data := []byte{}
+	for _, file := range pl.files {
+		if !file.info.IsDir() {
+			data = append(data, []byte(file.md5+"  "+file.target+"\n")...)
+		}
+	}
+	return data
+}
+
+// checksums returns the checksums of the files. The payload must have been
+// read first.
+func (pl *payload) checksums() []FileChecksum {
+	sums := []FileChecksum{}
+	for _, file := range pl.files {
+		if !file.info.IsDir() {
+			sums = append(sums, FileChecksum{
+				Path:   "/" + file.target,
+				Size:   file.info.Size(),
+				MD5:    file.md5,
+				SHA256: file.sha256,
+			})
+		}
+	}
+	return sums
+}
+
+// readPayload reads every file once, hashing it and writing it to w as a
+// compressed tar archive. If w is nil the files are only hashed. The output
+// only depends on the contents of the files, so it is the same every time.
+func (p *PackageSpec) readPayload(ctx context.Context, w io.Writer, pl *payload, stage ProgressStage) (err error) {
+	progress := p.newProgress(ctx, stage, pl.sourceFiles())
+
+	hashes := newHashPool(runtime.NumCPU())
+	defer func() {
+		// The hashes are only complete once the workers are done
+		hashes.close()
+		for _, file := range pl.files {
+			if file.link != nil {
+				file.md5, file.sha256 = file.link.md5, file.link.sha256
+			}
+		}
+	}()
+
+	var zipwriter *pgzip.Writer
+	var archive *tar.Writer
+	if w != nil {
+		zipwriter = pgzip.NewWriter(w)
+		archive = tar.NewWriter(zipwriter)
+	}
+
+	for _, file := range pl.files {
+		if err := progress.startFile(file.source); err != nil {
+			return err
+		}
+
+		if archive != nil {
+			header, err := p.tarHeader(file)
+			if err != nil {
+				return err
+			}
+			if err := archive.WriteHeader(header); err != nil {
+				return fmt.Errorf("Failed writing tar header for %q: %s", file.target, err)
+			}
+		}
+		if !file.regular() {
+			continue
+		}
+
+		var out io.Writer
+		if archive != nil {
+			out = archive
+		}
+		if err := p.readPayloadFile(file, progress, hashes, out); err != nil {
+			return err
+		}
+		progress.finishFile()
+	}
+
+	if archive == nil {
+		return nil
+	}
+	if err := archive.Close(); err != nil {
+		return err
+	}
+	return zipwriter.Close()
+}
+
+// readPayloadFile reads one file in chunks, writing each to out and handing it
+// to a hash worker
+func (p *PackageSpec) readPayloadFile(file *payloadFile, progress *progressTracker, hashes *hashPool, out io.Writer) error {
+	data, err := p.openFile(p.contentPath(file.source))
+	if err != nil {
+		return err
+	}
+	defer data.Close()
+
+	chunks := hashes.start(file)
+	defer close(chunks)
+
+	r := progress.reader(data)
+	for {
+		buf := hashes.buffer()
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			if out != nil {
+				if _, err := out.Write(buf[:n]); err != nil {
+					hashes.release(buf)
+					return err
+				}
+			}
+			chunks <- buf[:n]
+		} else {
+			hashes.release(buf)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// tarHeader returns the header of a file in the data archive
+func (p *PackageSpec) tarHeader(file *payloadFile) (*tar.Header, error) {
+	header, err := tar.FileInfoHeader(file.info, file.source)
+	if err != nil {
+		return nil, err
+	}
+
+	header.Name = file.target
+	if file.link != nil {
+		header.Typeflag = tar.TypeLink
+		header.Linkname = file.link.target
+		header.Size = 0
+	}
+	header.Uid = 0
+	header.Gid = 0
+	header.Uname = "root"
+	header.Gname = "root"
+	if owner, ok := p.Owners["/"+file.target]; ok {
+		header.Uname, header.Gname, err = parseOwner(owner)
+		if err != nil {
+			return nil, err
+		}
+	}
+	normalizeTarHeader(header)
+
+	// GNU headers can't hold extended attributes, so these files use PAX.
+	// Hard links share the attributes of the file they link to.
+	if caps, ok := p.Capabilities["/"+file.target]; ok && p.CapabilityXattrs && file.link == nil {
+		sets, err := parseCapabilities(caps)
+		if err != nil {
+			return nil, fmt.Errorf("Capabilities of %q are invalid: %s", "/"+file.target, err)
+		}
+		header.Format = tar.FormatPAX
+		header.PAXRecords = map[string]string{capabilityXattr: string(sets.xattr())}
+	}
+	return header, nil
+}
+
+// normalizeTarHeader makes a header the same on every build and every host. The
+// access and change times are removed, since they change when the package is
+// built, and the modification time is truncated to seconds like dpkg-deb
+// does, since GNU headers can't hold more precision.
+func normalizeTarHeader(header *tar.Header) {
+	header.Format = tarFormat
+	header.ModTime = header.ModTime.Truncate(time.Second)
+	header.AccessTime = time.Time{}
+	header.ChangeTime = time.Time{}
+}
+
+// hashPool hashes files with md5 and sha256 on several goroutines. Files are
+// read one after another, and each file is hashed by one worker while the next
+// file is read, so hashing keeps up with compression.
+type hashPool struct {
+	jobs    chan *hashJob
+	buffers chan []byte
+	wg      sync.WaitGroup
+}
+
+type hashJob struct {
+	file   *payloadFile
+	chunks chan []byte
+}
+
+func newHashPool(workers int) *hashPool {
+	h := &hashPool{
+		jobs:    make(chan *hashJob, workers),
+		buffers: make(chan []byte, 4*workers),
+	}
+	for i := 0; i < cap(h.buffers); i++ {
+		h.buffers <- make([]byte, hashChunkSize)
+	}
+	for i := 0; i < workers; i++ {
+		h.wg.Add(1)
+		go h.work()
+	}
+	return h
+}
+
+func (h *hashPool) work() {
+	defer h.wg.Done()
+	for job := range h.jobs {
+		md5sum, sha256sum := md5.New(), sha256.New()
+		sums := io.MultiWriter(md5sum, sha256sum)
+		for chunk := range job.chunks {
+			sums.Write(chunk)
+			h.release(chunk)
+		}
+		job.file.md5 = hexSum(md5sum)
+		job.file.sha256 = hexSum(sha256sum)
+	}
+}
+
+// start hashes a file. Its contents are sent to the returned channel, which
+// must be closed at the end of the file.
+func (h *hashPool) start(file *payloadFile) chan<- []byte {
+	job := &hashJob{file: file, chunks: make(chan []byte, 2)}
+	h.jobs <- job
+	return job.chunks
+}
+
+// buffer returns a buffer to read a chunk into. It blocks when the workers are
+// behind, which limits the memory used for chunks that are waiting.
+func (h *hashPool) buffer() []byte {
+	return <-h.buffers
+}
+
+// release returns a buffer to the pool
+func (h *hashPool) release(buf []byte) {
+	h.buffers <- buf[:cap(buf)]
+}
+
+// close waits for the workers to hash the files that were started
+func (h *hashPool) close() {
+	close(h.jobs)
+	h.wg.Wait()
+}
+
+func hexSum(h hash.Hash) string {
+	return hex.EncodeToString(h.Sum(nil))
+}
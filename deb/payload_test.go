@@ -0,0 +1,141 @@
+package deb
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestPayloadChecksums(t *testing.T) {
+	// Larger than a chunk so it is hashed in pieces
+	large := make([]byte, 3*hashChunkSize+100)
+	rand.New(rand.NewSource(1)).Read(large)
+	largeMD5, largeSHA256 := md5.Sum(large), sha256.Sum256(large)
+
+	p := PackageSpecFixture(t)
+	p.Version = "0.1.0"
+	p.AutoPath = "-"
+	p.Files = map[string]string{
+		"example-depends.json": "/usr/share/mkdeb/example.json",
+		"large":                "/usr/share/mkdeb/large",
+		"empty":                "/usr/share/mkdeb/empty",
+	}
+	p.Source = fstest.MapFS{
+		"example-depends.json": {Data: mustReadFile(t, filepath.Join("test-fixtures", "example-depends.json"))},
+		"large":                {Data: large},
+		"empty":                {Data: []byte{}},
+	}
+
+	b, err := p.NewBuilder()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	if _, err := b.WriteTo(ioutil.Discard); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []FileChecksum{
+		{"/usr/share/mkdeb/empty", 0, "d41d8cd98f00b204e9800998ecf8427e", "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"},
+		{"/usr/share/mkdeb/example.json", 271, "77d87ca6af3e6710a1faf86aaed5b800", "cba8ab269b1d715e127d6513de8bae9a8eca01c511feee99bf6314fc18a784fd"},
+		{"/usr/share/mkdeb/large", int64(len(large)), hex.EncodeToString(largeMD5[:]), hex.EncodeToString(largeSHA256[:])},
+	}
+	sums := b.Checksums()
+	if len(sums) != len(expected) {
+		t.Fatalf("Expected %d checksums; found %+v", len(expected), sums)
+	}
+	for i := range expected {
+		if sums[i] != expected[i] {
+			t.Errorf("Expected %+v; found %+v", expected[i], sums[i])
+		}
+	}
+
+	// The same checksums are used for md5sums
+	md5sums, err := p.CalculateChecksums()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(md5sums), hex.EncodeToString(largeMD5[:])+"  usr/share/mkdeb/large\n") {
+		t.Errorf("Unexpected md5sums\n%s", md5sums)
+	}
+
+	// Installed-Size is calculated from the payload
+	if p.InstalledSize != 1+int64(len(large))/1024 {
+		t.Errorf("Expected Installed-Size of %d; found %d", 1+len(large)/1024, p.InstalledSize)
+	}
+}
+
+func TestPayloadReadError(t *testing.T) {
+	p := PackageSpecFixture(t)
+	p.AutoPath = "-"
+	p.Files = map[string]string{"missing": "/usr/bin/missing"}
+	p.Source = fstest.MapFS{"missing": {Data: []byte("gone")}}
+
+	payload, err := p.walkPayload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The file disappears after the walk
+	p.Source = fstest.MapFS{}
+	if err := p.readPayload(context.Background(), ioutil.Discard, payload, ProgressCompressing); err == nil {
+		t.Errorf("Expected error reading a missing file")
+	}
+}
+
+func mustReadFile(t *testing.T, filename string) []byte {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// BenchmarkBuildTree builds a package from thousands of files on the host
+func BenchmarkBuildTree(b *testing.B) {
+	dir, err := ioutil.TempDir("", "mkdeb-bench")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Half random and half repetitive data, so it compresses like binaries
+	random := rand.New(rand.NewSource(1))
+	total := int64(0)
+	for i := 0; i < 4000; i++ {
+		filename := filepath.Join(dir, "deb-pkg", "usr", "share", "bench", fmt.Sprintf("%02d", i%50), fmt.Sprintf("file%d", i))
+		if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+			b.Fatal(err)
+		}
+		data := make([]byte, 1024+random.Intn(32*1024))
+		random.Read(data[:len(data)/2])
+		if err := ioutil.WriteFile(filename, data, 0644); err != nil {
+			b.Fatal(err)
+		}
+		total += int64(len(data))
+	}
+
+	p := DefaultPackageSpec()
+	p.Package = "bench"
+	p.Version = "1.0"
+	p.Architecture = "all"
+	p.Maintainer = "mkdeb <mkdeb@example.com>"
+	p.Description = "benchmark"
+	p.BaseDir = dir
+
+	b.SetBytes(total)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := p.WriteTo(ioutil.Discard); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package deb
+
+import (
+	"context"
+	"io"
+)
+
+// ProgressStage is the step of a build reported by Progress
+type ProgressStage string
+
+const (
+	// ProgressHashing is reported by CalculateChecksums and
+	// CreateControlArchive, which only hash the files
+	ProgressHashing ProgressStage = "hashing"
+
+	// ProgressCompressing is reported while the files are hashed and
+	// compressed into the data archive
+	ProgressCompressing ProgressStage = "compressing"
+)
+
+// ProgressStages lists the stages of a build in the order they happen. Each
+// stage reads every file in the package once.
+var ProgressStages = []ProgressStage{ProgressCompressing}
+
+// Progress is passed to PackageSpec.OnProgress during a build. Files and Bytes
+// count what has been read so far in the current stage, out of TotalFiles and
+// TotalBytes.
+type Progress struct {
+	Package      string
+	Architecture string
+	Stage        ProgressStage
+	File         string // Source path of the file being read
+	Files        int
+	TotalFiles   int
+	Bytes        int64
+	TotalBytes   int64
+}
+
+// progressTracker counts the files and bytes read in one stage of the build,
+// and stops reading when ctx is cancelled
+type progressTracker struct {
+	ctx      context.Context
+	report   func(Progress)
+	progress Progress
+}
+
+// newProgress starts a stage of the build that reads files. The totals are
+// only calculated if OnProgress is set.
+func (p *PackageSpec) newProgress(ctx context.Context, stage ProgressStage, files []string) *progressTracker {
+	t := &progressTracker{
+		ctx:    ctx,
+		report: p.OnProgress,
+		progress: Progress{
+			Package:      p.Package,
+			Architecture: p.Architecture,
+			Stage:        stage,
+		},
+	}
+	if t.report == nil {
+		return t
+	}
+	for _, file := range files {
+		info, err := p.statFile(p.contentPath(file))
+		if err != nil || info.IsDir() {
+			continue
+		}
+		t.progress.TotalFiles++
+		t.progress.TotalBytes += info.Size()
+	}
+	t.report(t.progress)
+	return t
+}
+
+// startFile is called before a file is read. It returns an error if the build
+// was cancelled.
+func (t *progressTracker) startFile(filename string) error {
+	if err := t.ctx.Err(); err != nil {
+		return err
+	}
+	t.progress.File = filename
+	return nil
+}
+
+// finishFile is called after a file was read
+func (t *progressTracker) finishFile() {
+	t.progress.Files++
+	if t.report != nil {
+		t.report(t.progress)
+	}
+}
+
+// reader counts the bytes read from r, and fails if the build is cancelled so
+// large files don't have to be read to the end
+func (t *progressTracker) reader(r io.Reader) io.Reader {
+	return &progressReader{t: t, r: r}
+}
+
+type progressReader struct {
+	t *progressTracker
+	r io.Reader
+}
+
+func (r *progressReader) Read(b []byte) (int, error) {
+	if err := r.t.ctx.Err(); err != nil {
+		return 0, err
+	}
+	n, err := r.r.Read(b)
+	r.t.progress.Bytes += int64(n)
+	if n > 0 && r.t.report != nil {
+		r.t.report(r.t.progress)
+	}
+	return n, err
+}
@@ -0,0 +1,87 @@
+package deb
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestBuildProgress(t *testing.T) {
+	p := PackageSpecFixture(t)
+	p.Version = "0.1.0"
+
+	last := map[ProgressStage]Progress{}
+	stages := []ProgressStage{}
+	p.OnProgress = func(progress Progress) {
+		if len(stages) == 0 || stages[len(stages)-1] != progress.Stage {
+			stages = append(stages, progress.Stage)
+		}
+		if progress.Package != "mkdeb" || progress.Architecture != "amd64" {
+			t.Errorf("Unexpected package in progress %+v", progress)
+		}
+		last[progress.Stage] = progress
+	}
+
+	if _, err := p.WriteTo(ioutil.Discard); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(stages) != len(ProgressStages) {
+		t.Fatalf("Expected stages %v; found %v", ProgressStages, stages)
+	}
+	for i, stage := range ProgressStages {
+		if stages[i] != stage {
+			t.Errorf("Expected stage %d to be %s; found %s", i, stage, stages[i])
+		}
+		progress := last[stage]
+		if progress.TotalFiles == 0 || progress.TotalBytes == 0 {
+			t.Errorf("Expected totals for %s; found %+v", stage, progress)
+		}
+		if progress.Files != progress.TotalFiles || progress.Bytes != progress.TotalBytes {
+			t.Errorf("Expected %s to finish; found %+v", stage, progress)
+		}
+	}
+}
+
+func TestBuildContextCancel(t *testing.T) {
+	target, err := ioutil.TempDir("", "mkdeb-cancel")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(target)
+
+	// Cancel during each stage, after the package file was created
+	for _, stage := range ProgressStages {
+		p := PackageSpecFixture(t)
+		p.Version = "0.1.0"
+		ctx, cancel := context.WithCancel(context.Background())
+		p.OnProgress = func(progress Progress) {
+			if progress.Stage == stage && progress.Bytes > 0 {
+				cancel()
+			}
+		}
+
+		if err := p.BuildContext(ctx, target); err == nil {
+			t.Errorf("Expected build to fail when cancelled while %s", stage)
+		}
+		cancel()
+
+		files, err := ioutil.ReadDir(target)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(files) > 0 {
+			t.Errorf("Expected partial package to be removed when cancelled while %s; found %s", stage, files[0].Name())
+		}
+	}
+
+	// A cancelled context doesn't start the build
+	p := PackageSpecFixture(t)
+	p.Version = "0.1.0"
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := p.BuildContext(ctx, target); err != context.Canceled {
+		t.Errorf("Expected %v; found %v", context.Canceled, err)
+	}
+}
@@ -0,0 +1,297 @@
+package deb
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+	"text/template"
+)
+
+var (
+	reScriptToken = regexp.MustCompile(`(?m)^[ \t]*#MKDEB#[ \t]*\n?`)
+
+	// reScriptExit matches an exit command on the last line of a script
+	reScriptExit = regexp.MustCompile(`(?m)^exit\b.*\n?\s*\z`)
+
+	// scriptActions lists the arguments dpkg (and debconf) may pass as $1 to
+	// each maintainer script.
+	scriptActions = map[string][]string{
+		"preinst":  {"install", "upgrade", "abort-upgrade"},
+		"postinst": {"configure", "abort-upgrade", "abort-remove", "abort-deconfigure", "triggered", "reconfigure"},
+		"prerm":    {"remove", "upgrade", "deconfigure", "failed-upgrade"},
+		"postrm":   {"remove", "purge", "upgrade", "failed-upgrade", "abort-install", "abort-upgrade", "disappear"},
+		"config":   {"configure", "reconfigure"},
+	}
+
+	// scriptGenerators create script fragments for other options. They are
+	// listed in the order they run when they are not placed explicitly. Users
+	// are created before services are started, and capabilities are set after
+	// ownership is changed.
+	scriptGenerators = []scriptGenerator{
+		{"users", (*PackageSpec).usersScripts},
+		{"capabilities", (*PackageSpec).capabilitiesScripts},
+		{"diversions", (*PackageSpec).diversionsScripts},
+		{"alternatives", (*PackageSpec).alternativesScripts},
+		{"systemd", (*PackageSpec).systemdScripts},
+		{"triggers", (*PackageSpec).triggersScripts},
+	}
+)
+
+type scriptGenerator struct {
+	name     string
+	generate func(p *PackageSpec) (map[string][]byte, error)
+}
+
+// ScriptSnippet is a piece of a maintainer script. Exactly one of File, Inline,
+// or Generated should be specified.
+//
+// File is the path to a file containing shell code. A #! line at the start of
+// the file is ignored. Inline is shell code written directly in the config.
+// Generated places the fragment generated for another option, such as
+// "systemd" or "users", at this point in the script. Generated fragments that
+// are not placed explicitly are added after all of the other snippets.
+//
+// Actions limits the snippet to run only when the script is called with one of
+// the listed actions, e.g. "configure". By default the snippet always runs.
+type ScriptSnippet struct {
+	File      string   `json:"file,omitempty"`
+	Inline    string   `json:"inline,omitempty"`
+	Generated string   `json:"generated,omitempty"`
+	Actions   []string `json:"actions,omitempty"`
+}
+
+// RenderControlScripts returns the contents of the pre/post/inst/rm scripts
+// used in this package.
+//
+// Scripts listed in Scripts are assembled from their snippets, along with a
+// #!/bin/sh and set -e header and a check that rejects unknown actions.
+// Otherwise the scripts found by MapControlFiles are used as-is, except that
+// generated fragments replace a line containing #MKDEB#, or are added to the
+// end of the script if there is no such line, before a final exit. If there is
+// no script at all but there are generated fragments a script is assembled
+// from those.
+func (p *PackageSpec) RenderControlScripts() (map[string][]byte, error) {
+	generated := map[string]map[string][]byte{}
+	for _, generator := range scriptGenerators {
+		fragments, err := generator.generate(p)
+		if err != nil {
+			return nil, err
+		}
+		generated[generator.name] = fragments
+	}
+
+	files := p.MapControlFiles()
+	scripts := map[string][]byte{}
+	for _, name := range controlFiles {
+		snippets := p.Scripts[name]
+		filename, hasFile := files[name]
+		if len(snippets) == 0 && hasFile {
+			data, err := p.readFile(filename)
+			if err != nil {
+				return nil, fmt.Errorf("Failed reading script %q: %s", filename, err)
+			}
+			fragments := []byte{}
+			for _, generator := range scriptGenerators {
+				fragments = append(fragments, generated[generator.name][name]...)
+			}
+			scripts[name] = insertFragments(data, fragments)
+			continue
+		}
+
+		script, err := p.composeScript(name, snippets, generated)
+		if err != nil {
+			return nil, err
+		}
+		if script != nil {
+			scripts[name] = script
+		}
+	}
+	return scripts, nil
+}
+
+// validateScripts checks that Scripts only refers to known scripts, actions,
+// and generators, and does not conflict with other control script options.
+func (p *PackageSpec) validateScripts() error {
+	files := p.MapControlFiles()
+	for name, snippets := range p.Scripts {
+		actions, ok := scriptActions[name]
+		if !ok {
+			return fmt.Errorf("Script %q is invalid; expected one of %s", name, strings.Join(controlFiles, ", "))
+		}
+		if filename, ok := files[name]; ok && len(snippets) > 0 && !hasSnippetFile(snippets, filename) {
+			return fmt.Errorf("Script %s is specified both as %q and as snippets; use only one", name, filename)
+		}
+		for _, snippet := range snippets {
+			count := 0
+			for _, field := range []string{snippet.File, snippet.Inline, snippet.Generated} {
+				if field != "" {
+					count++
+				}
+			}
+			if count != 1 {
+				return fmt.Errorf("Snippet in %s is invalid; expected exactly one of file, inline, or generated", name)
+			}
+			if snippet.Generated != "" && !hasGenerator(snippet.Generated) {
+				names := []string{}
+				for _, generator := range scriptGenerators {
+					names = append(names, generator.name)
+				}
+				return fmt.Errorf("Generated snippet %q in %s is invalid; expected one of %s",
+					snippet.Generated, name, strings.Join(names, ", "))
+			}
+			for _, action := range snippet.Actions {
+				if !hasString(actions, action) {
+					return fmt.Errorf("Action %q in %s is invalid; expected one of %s",
+						action, name, strings.Join(actions, ", "))
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// composeScript assembles a script from snippets and generated fragments. It
+// returns nil if there is nothing to put in the script.
+func (p *PackageSpec) composeScript(name string, snippets []ScriptSnippet, generated map[string]map[string][]byte) ([]byte, error) {
+	parts := [][]byte{}
+	used := map[string]bool{}
+	for _, snippet := range snippets {
+		var body []byte
+		switch {
+		case snippet.File != "":
+			data, err := p.readFile(snippet.File)
+			if err != nil {
+				return nil, fmt.Errorf("Failed reading script snippet %q: %s", snippet.File, err)
+			}
+			if bytes.HasPrefix(data, []byte("#!")) {
+				if i := bytes.IndexByte(data, '\n'); i >= 0 {
+					data = data[i+1:]
+				} else {
+					data = nil
+				}
+			}
+			body = append([]byte("# "+snippet.File+"\n"), data...)
+		case snippet.Inline != "":
+			body = []byte(snippet.Inline)
+		case snippet.Generated != "":
+			used[snippet.Generated] = true
+			body = generated[snippet.Generated][name]
+		}
+		body = bytes.Trim(body, "\n")
+		if len(body) == 0 {
+			continue
+		}
+		if len(snippet.Actions) > 0 {
+			body = []byte(fmt.Sprintf("case \"$1\" in\n%s)\n%s\n;;\nesac", strings.Join(snippet.Actions, "|"), body))
+		}
+		parts = append(parts, body)
+	}
+	for _, generator := range scriptGenerators {
+		if used[generator.name] {
+			continue
+		}
+		if body := bytes.Trim(generated[generator.name][name], "\n"); len(body) > 0 {
+			parts = append(parts, body)
+		}
+	}
+	if len(parts) == 0 {
+		return nil, nil
+	}
+
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, scriptHeader, strings.Join(scriptActions[name], "|"), name)
+	for _, part := range parts {
+		buf.WriteString("\n")
+		buf.Write(part)
+		buf.WriteString("\n")
+	}
+	buf.WriteString("\nexit 0\n")
+	return buf.Bytes(), nil
+}
+
+// renderScriptTemplates renders a template for each script, skipping scripts
+// that have no template or render empty.
+func renderScriptTemplates(templates map[string]*template.Template, enabled bool, data interface{}) (map[string][]byte, error) {
+	scripts := map[string][]byte{}
+	if !enabled {
+		return scripts, nil
+	}
+	for _, script := range controlFiles {
+		t, ok := templates[script]
+		if !ok {
+			continue
+		}
+		buf := &bytes.Buffer{}
+		if err := t.Execute(buf, data); err != nil {
+			return nil, err
+		}
+		if buf.Len() > 0 {
+			scripts[script] = buf.Bytes()
+		}
+	}
+	return scripts, nil
+}
+
+var scriptTemplateFuncs = template.FuncMap{
+	"quote": shellQuote,
+}
+
+// shellQuote quotes s so it is interpreted as a single word by the shell
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// insertFragments adds generated fragments to a user supplied script in place
+// of #MKDEB#, or at the end if the script does not have #MKDEB#. Scripts often
+// end with exit 0, so the fragments go before it, or they would never run.
+func insertFragments(script, fragments []byte) []byte {
+	if len(fragments) == 0 {
+		return script
+	}
+	if loc := reScriptToken.FindIndex(script); loc != nil {
+		rendered := append([]byte{}, script[:loc[0]]...)
+		rendered = append(rendered, fragments...)
+		return append(rendered, script[loc[1]:]...)
+	}
+	if loc := reScriptExit.FindIndex(script); loc != nil {
+		rendered := append([]byte{}, script[:loc[0]]...)
+		rendered = append(rendered, fragments...)
+		return append(rendered, script[loc[0]:]...)
+	}
+	if !bytes.HasSuffix(script, []byte("\n")) {
+		script = append(script, '\n')
+	}
+	return append(script, fragments...)
+}
+
+func hasSnippetFile(snippets []ScriptSnippet, filename string) bool {
+	for _, snippet := range snippets {
+		if snippet.File == filename {
+			return true
+		}
+	}
+	return false
+}
+
+func hasGenerator(name string) bool {
+	for _, generator := range scriptGenerators {
+		if generator.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+const scriptHeader = `#!/bin/sh
+set -e
+
+case "$1" in
+%[1]s)
+	;;
+*)
+	echo "%[2]s called with unknown argument '$1'" >&2
+	exit 1
+	;;
+esac
+`
@@ -0,0 +1,133 @@
+package deb
+
+import (
+	"os/exec"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestComposeScripts(t *testing.T) {
+	p := PackageSpecFixture(t, "systemd.json")
+	p.Scripts = map[string][]ScriptSnippet{
+		"postinst": {
+			{Inline: "echo first"},
+			{Generated: "systemd"},
+			{File: path.Join("test-fixtures", "setup.sh"), Actions: []string{"configure", "abort-upgrade"}},
+		},
+	}
+
+	if err := p.Validate(false); err != nil {
+		t.Fatal(err)
+	}
+
+	scripts, err := p.RenderControlScripts()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	postinst := string(scripts["postinst"])
+	expected := `#!/bin/sh
+set -e
+
+case "$1" in
+configure|abort-upgrade|abort-remove|abort-deconfigure|triggered|reconfigure)
+	;;
+*)
+	echo "postinst called with unknown argument '$1'" >&2
+	exit 1
+	;;
+esac
+
+echo first
+`
+	if !strings.HasPrefix(postinst, expected) {
+		t.Fatalf("--Expected prefix--\n%s\n--Found--\n%s\n", expected, postinst)
+	}
+
+	expected = `
+case "$1" in
+configure|abort-upgrade)
+# test-fixtures/setup.sh
+mkdir -p /var/lib/package1
+;;
+esac
+
+exit 0
+`
+	if !strings.HasSuffix(postinst, expected) {
+		t.Fatalf("--Expected suffix--\n%s\n--Found--\n%s\n", expected, postinst)
+	}
+
+	first := strings.Index(postinst, "echo first")
+	systemd := strings.Index(postinst, "deb-systemd-helper")
+	setup := strings.Index(postinst, "mkdir -p")
+	if !(first < systemd && systemd < setup) {
+		t.Errorf("Expected snippets in order:\n%s", postinst)
+	}
+
+	// prerm is not listed in Scripts, so it's assembled from generated code
+	prerm := string(scripts["prerm"])
+	if !strings.Contains(prerm, "remove|upgrade|deconfigure|failed-upgrade)") ||
+		!strings.Contains(prerm, "deb-systemd-invoke stop") {
+		t.Errorf("Expected generated prerm:\n%s", prerm)
+	}
+
+	// preinst still comes from AutoPath
+	if strings.Contains(string(scripts["preinst"]), "case") {
+		t.Errorf("Expected preinst to be used as-is:\n%s", scripts["preinst"])
+	}
+
+	if _, err := exec.LookPath("sh"); err == nil {
+		for name, script := range scripts {
+			cmd := exec.Command("sh", "-n")
+			cmd.Stdin = strings.NewReader(string(script))
+			if output, err := cmd.CombinedOutput(); err != nil {
+				t.Errorf("%s has a syntax error: %s\n%s\n%s", name, err, output, script)
+			}
+		}
+	}
+}
+
+func TestInsertFragments(t *testing.T) {
+	fragments := []byte("# generated\n")
+	cases := map[string]string{
+		"#!/bin/sh\necho hi\n#MKDEB#\necho bye\n":  "#!/bin/sh\necho hi\n# generated\necho bye\n",
+		"#!/bin/sh\necho hi\n":                     "#!/bin/sh\necho hi\n# generated\n",
+		"#!/bin/sh\necho hi":                       "#!/bin/sh\necho hi\n# generated\n",
+		"#!/bin/sh\necho hi\nexit 0\n\n":           "#!/bin/sh\necho hi\n# generated\nexit 0\n\n",
+		"#!/bin/sh\nif true; then\n\texit 0\nfi\n": "#!/bin/sh\nif true; then\n\texit 0\nfi\n# generated\n",
+	}
+	for script, expected := range cases {
+		if found := string(insertFragments([]byte(script), fragments)); found != expected {
+			t.Errorf("--Expected--\n%s\n--Found--\n%s\n", expected, found)
+		}
+	}
+}
+
+func TestValidateScripts(t *testing.T) {
+	cases := map[string]map[string][]ScriptSnippet{
+		"Script \"install\"":    {"install": {{Inline: "true"}}},
+		"exactly one":           {"postinst": {{Inline: "true", File: "setup.sh"}}},
+		"Generated snippet":     {"postinst": {{Generated: "cron"}}},
+		"Action \"configure\"":  {"prerm": {{Inline: "true", Actions: []string{"configure"}}}},
+		"both as \"test-fixtur": {"preinst": {{Inline: "true"}}},
+	}
+	for expected, scripts := range cases {
+		p := PackageSpecFixture(t)
+		p.Scripts = scripts
+		err := p.Validate(false)
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("Expected error containing %q; found %+v", expected, err)
+		}
+	}
+
+	// Using the AutoPath script as a snippet is not a conflict
+	p := PackageSpecFixture(t)
+	p.Scripts = map[string][]ScriptSnippet{
+		"preinst": {{File: path.Join("test-fixtures", "package1", "preinst")}},
+	}
+	if err := p.Validate(false); err != nil {
+		t.Fatal(err)
+	}
+}
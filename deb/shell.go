@@ -0,0 +1,983 @@
+package deb
+
+import (
+	"fmt"
+	"strings"
+)
+
+// This file contains a small parser for POSIX shell scripts. It is used to lint
+// maintainer scripts before they are packaged, so it only keeps track of the
+// things the linter needs: simple commands, case statements, and bash
+// extensions that dash does not support.
+
+// shellScript is the result of parsing a shell script
+type shellScript struct {
+	commands []shellCommand
+	cases    []shellCase
+	bashisms []shellIssue
+}
+
+// shellCommand is a simple command. Args contains each word as written in the
+// script, including any quotes.
+type shellCommand struct {
+	line int
+	args []string
+}
+
+// shellCase is a case statement and the patterns of its branches
+type shellCase struct {
+	line     int
+	subject  string
+	patterns []shellPattern
+}
+
+type shellPattern struct {
+	line    int
+	pattern string
+}
+
+type shellIssue struct {
+	line    int
+	message string
+}
+
+// shellSyntaxError is returned by parseShell for invalid scripts
+type shellSyntaxError struct {
+	line    int
+	message string
+}
+
+func (e *shellSyntaxError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.line, e.message)
+}
+
+type shellTokenKind int
+
+const (
+	shellEOF shellTokenKind = iota
+	shellNewline
+	shellWord
+	shellOperator
+)
+
+type shellToken struct {
+	kind     shellTokenKind
+	val      string
+	line     int
+	literal  bool // unquoted word without expansions, may be a reserved word
+	ioNumber bool // file descriptor number before a redirection, e.g. 2>
+}
+
+func (t shellToken) String() string {
+	switch t.kind {
+	case shellEOF:
+		return "end of file"
+	case shellNewline:
+		return "newline"
+	}
+	return fmt.Sprintf("%q", t.val)
+}
+
+type shellHeredoc struct {
+	delimiter string
+	stripTabs bool
+	line      int
+}
+
+type shellParser struct {
+	src      string
+	pos      int
+	line     int
+	tok      shellToken
+	heredocs []shellHeredoc
+	script   *shellScript
+}
+
+var (
+	// Operators are matched longest first
+	shellOperators = []string{
+		"<<<", "<<-", "&&", "||", ";;", "<<", ">>", "<&", ">&", "<>", ">|", "&>",
+		";", "&", "|", "<", ">", "(", ")",
+	}
+
+	shellRedirects = []string{"<", ">", ">>", "<&", ">&", "<>", ">|", "<<", "<<-", "<<<", "&>"}
+
+	shellReserved = []string{
+		"if", "then", "else", "elif", "fi", "do", "done", "case", "esac",
+		"while", "until", "for", "{", "}", "!",
+	}
+
+	shellBashBuiltins = []string{
+		"declare", "typeset", "let", "shopt", "pushd", "popd", "disown", "complete", "source",
+	}
+)
+
+// parseShell parses a POSIX shell script. A *shellSyntaxError is returned if
+// the script is not valid.
+func parseShell(src string) (*shellScript, error) {
+	p := &shellParser{src: src, line: 1, script: &shellScript{}}
+	return p.script, p.parseProgram()
+}
+
+func (p *shellParser) parseProgram() error {
+	if err := p.next(); err != nil {
+		return err
+	}
+	if _, err := p.parseList(); err != nil {
+		return err
+	}
+	if p.tok.kind != shellEOF {
+		return p.unexpected()
+	}
+	return nil
+}
+
+// parseList parses commands separated by ; & or newlines until one of the stop
+// words or operators is found. With no stop words the list ends at EOF. It
+// returns the number of commands parsed.
+func (p *shellParser) parseList(stop ...string) (int, error) {
+	count := 0
+	if err := p.skipNewlines(); err != nil {
+		return count, err
+	}
+	for !p.atStop(stop) {
+		if p.tok.kind == shellEOF {
+			if len(stop) > 0 {
+				// The last stop word closes the construct, e.g. fi after elif and else
+				return count, p.errorf("unexpected end of file; expected %q", stop[len(stop)-1])
+			}
+			return count, nil
+		}
+		if err := p.parseAndOr(); err != nil {
+			return count, err
+		}
+		count++
+		switch {
+		case p.isOperator(";"), p.isOperator("&"):
+			if err := p.next(); err != nil {
+				return count, err
+			}
+		case p.tok.kind == shellNewline:
+		case p.atStop(stop):
+			return count, nil
+		case p.tok.kind == shellEOF:
+			continue
+		default:
+			return count, p.unexpected()
+		}
+		if err := p.skipNewlines(); err != nil {
+			return count, err
+		}
+	}
+	return count, nil
+}
+
+func (p *shellParser) parseAndOr() error {
+	if err := p.parsePipeline(); err != nil {
+		return err
+	}
+	for p.isOperator("&&") || p.isOperator("||") {
+		if err := p.next(); err != nil {
+			return err
+		}
+		if err := p.skipNewlines(); err != nil {
+			return err
+		}
+		if err := p.parsePipeline(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *shellParser) parsePipeline() error {
+	if p.isReserved("!") {
+		if err := p.next(); err != nil {
+			return err
+		}
+	}
+	if err := p.parseCommand(); err != nil {
+		return err
+	}
+	for p.isOperator("|") {
+		if err := p.next(); err != nil {
+			return err
+		}
+		if err := p.skipNewlines(); err != nil {
+			return err
+		}
+		if err := p.parseCommand(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *shellParser) parseCommand() error {
+	compound, err := p.parseCompound()
+	if err != nil {
+		return err
+	}
+	if compound {
+		return p.parseRedirects()
+	}
+	if p.tok.kind == shellWord && p.tok.literal && hasString(shellReserved, p.tok.val) {
+		return p.unexpected()
+	}
+	if p.tok.kind == shellWord && p.tok.literal && p.tok.val == "function" {
+		return p.parseBashFunction()
+	}
+	return p.parseSimpleCommand()
+}
+
+// parseCompound parses a compound command if there is one at the current
+// token, and reports whether it did.
+func (p *shellParser) parseCompound() (bool, error) {
+	if p.isOperator("(") {
+		if strings.HasPrefix(p.src[p.pos:], "(") {
+			p.bashism("(( )) arithmetic is a bash extension; use $(( )) with a command like test or :")
+		}
+		return true, p.parseGroup(")")
+	}
+	if p.tok.kind != shellWord || !p.tok.literal {
+		return false, nil
+	}
+	switch p.tok.val {
+	case "{":
+		return true, p.parseGroup("}")
+	case "if":
+		return true, p.parseIf()
+	case "while", "until":
+		if err := p.next(); err != nil {
+			return true, err
+		}
+		if err := p.parseRequiredList("do"); err != nil {
+			return true, err
+		}
+		return true, p.parseDoGroup()
+	case "for":
+		return true, p.parseFor()
+	case "case":
+		return true, p.parseCase()
+	}
+	return false, nil
+}
+
+// parseGroup parses a subshell or brace group; the current token is the
+// opening ( or {
+func (p *shellParser) parseGroup(end string) error {
+	if err := p.next(); err != nil {
+		return err
+	}
+	if err := p.parseRequiredList(end); err != nil {
+		return err
+	}
+	return p.expect(end)
+}
+
+func (p *shellParser) parseIf() error {
+	// if
+	if err := p.next(); err != nil {
+		return err
+	}
+	for {
+		if err := p.parseRequiredList("then"); err != nil {
+			return err
+		}
+		if err := p.expect("then"); err != nil {
+			return err
+		}
+		if err := p.parseRequiredList("elif", "else", "fi"); err != nil {
+			return err
+		}
+		if !p.isReserved("elif") {
+			break
+		}
+		if err := p.next(); err != nil {
+			return err
+		}
+	}
+	if p.isReserved("else") {
+		if err := p.next(); err != nil {
+			return err
+		}
+		if err := p.parseRequiredList("fi"); err != nil {
+			return err
+		}
+	}
+	return p.expect("fi")
+}
+
+func (p *shellParser) parseFor() error {
+	// for
+	if err := p.next(); err != nil {
+		return err
+	}
+	if p.tok.kind != shellWord {
+		return p.errorf("expected a variable name after for, found %s", p.tok)
+	}
+	if err := p.next(); err != nil {
+		return err
+	}
+	if err := p.skipNewlines(); err != nil {
+		return err
+	}
+	if p.isReserved("in") || (p.tok.kind == shellWord && p.tok.literal && p.tok.val == "in") {
+		if err := p.next(); err != nil {
+			return err
+		}
+		for p.tok.kind == shellWord {
+			if err := p.next(); err != nil {
+				return err
+			}
+		}
+		if !p.isOperator(";") && p.tok.kind != shellNewline {
+			return p.unexpected()
+		}
+	}
+	if p.isOperator(";") {
+		if err := p.next(); err != nil {
+			return err
+		}
+	}
+	if err := p.skipNewlines(); err != nil {
+		return err
+	}
+	return p.parseDoGroup()
+}
+
+func (p *shellParser) parseDoGroup() error {
+	if err := p.expect("do"); err != nil {
+		return err
+	}
+	if err := p.parseRequiredList("done"); err != nil {
+		return err
+	}
+	return p.expect("done")
+}
+
+func (p *shellParser) parseCase() error {
+	c := shellCase{line: p.tok.line}
+	// case
+	if err := p.next(); err != nil {
+		return err
+	}
+	if p.tok.kind != shellWord {
+		return p.errorf("expected a word after case, found %s", p.tok)
+	}
+	c.subject = p.tok.val
+	if err := p.next(); err != nil {
+		return err
+	}
+	if err := p.skipNewlines(); err != nil {
+		return err
+	}
+	if !(p.tok.kind == shellWord && p.tok.literal && p.tok.val == "in") {
+		return p.errorf("expected \"in\" after case %s, found %s", c.subject, p.tok)
+	}
+	if err := p.next(); err != nil {
+		return err
+	}
+	if err := p.skipNewlines(); err != nil {
+		return err
+	}
+	for !p.isReserved("esac") {
+		if p.isOperator("(") {
+			if err := p.next(); err != nil {
+				return err
+			}
+		}
+		for {
+			if p.tok.kind != shellWord {
+				return p.errorf("expected a case pattern, found %s", p.tok)
+			}
+			c.patterns = append(c.patterns, shellPattern{line: p.tok.line, pattern: p.tok.val})
+			if err := p.next(); err != nil {
+				return err
+			}
+			if !p.isOperator("|") {
+				break
+			}
+			if err := p.next(); err != nil {
+				return err
+			}
+		}
+		if err := p.expect(")"); err != nil {
+			return err
+		}
+		if _, err := p.parseList(";;", "esac"); err != nil {
+			return err
+		}
+		if !p.isOperator(";;") {
+			break
+		}
+		if err := p.next(); err != nil {
+			return err
+		}
+		if err := p.skipNewlines(); err != nil {
+			return err
+		}
+	}
+	p.script.cases = append(p.script.cases, c)
+	return p.expect("esac")
+}
+
+// parseBashFunction parses function name { ... } so we can report it as a
+// bashism rather than a confusing syntax error.
+func (p *shellParser) parseBashFunction() error {
+	p.bashism("the function keyword is a bash extension; use name() { ... }")
+	if err := p.next(); err != nil {
+		return err
+	}
+	if p.tok.kind != shellWord {
+		return p.errorf("expected a function name, found %s", p.tok)
+	}
+	if err := p.next(); err != nil {
+		return err
+	}
+	if p.isOperator("(") {
+		if err := p.next(); err != nil {
+			return err
+		}
+		if err := p.expect(")"); err != nil {
+			return err
+		}
+	}
+	return p.parseFunctionBody()
+}
+
+func (p *shellParser) parseFunctionBody() error {
+	if err := p.skipNewlines(); err != nil {
+		return err
+	}
+	compound, err := p.parseCompound()
+	if err != nil {
+		return err
+	}
+	if !compound {
+		return p.errorf("expected a function body, found %s", p.tok)
+	}
+	return p.parseRedirects()
+}
+
+func (p *shellParser) parseSimpleCommand() error {
+	cmd := shellCommand{line: p.tok.line}
+	redirects := 0
+	for {
+		switch {
+		case p.tok.kind == shellWord && p.tok.ioNumber:
+			if err := p.next(); err != nil {
+				return err
+			}
+		case p.tok.kind == shellWord:
+			cmd.args = append(cmd.args, p.tok.val)
+			if err := p.next(); err != nil {
+				return err
+			}
+		case p.isRedirect():
+			redirects++
+			if err := p.parseRedirect(); err != nil {
+				return err
+			}
+		case p.isOperator("(") && len(cmd.args) == 1:
+			// name() compound-command
+			if err := p.next(); err != nil {
+				return err
+			}
+			if err := p.expect(")"); err != nil {
+				return err
+			}
+			return p.parseFunctionBody()
+		default:
+			if len(cmd.args)+redirects == 0 {
+				return p.unexpected()
+			}
+			if len(cmd.args) > 0 {
+				p.script.commands = append(p.script.commands, cmd)
+			}
+			return nil
+		}
+	}
+}
+
+func (p *shellParser) parseRedirects() error {
+	for p.isRedirect() || (p.tok.kind == shellWord && p.tok.ioNumber) {
+		if p.tok.ioNumber {
+			if err := p.next(); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := p.parseRedirect(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *shellParser) parseRedirect() error {
+	op := p.tok.val
+	switch op {
+	case "<<<":
+		p.bashism("<<< here-strings are a bash extension; use a here-document or a pipe")
+	case "&>":
+		p.bashism("&> redirection is a bash extension; use >file 2>&1")
+	}
+	if err := p.next(); err != nil {
+		return err
+	}
+	if p.tok.kind != shellWord {
+		return p.errorf("expected a filename after %s, found %s", op, p.tok)
+	}
+	if op == "<<" || op == "<<-" {
+		delimiter := strings.NewReplacer(`\`, "", `'`, "", `"`, "").Replace(p.tok.val)
+		p.heredocs = append(p.heredocs, shellHeredoc{delimiter: delimiter, stripTabs: op == "<<-", line: p.tok.line})
+	}
+	return p.next()
+}
+
+// parseRequiredList parses a list that must contain at least one command
+func (p *shellParser) parseRequiredList(stop ...string) error {
+	count, err := p.parseList(stop...)
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return p.unexpected()
+	}
+	return nil
+}
+
+func (p *shellParser) skipNewlines() error {
+	for p.tok.kind == shellNewline {
+		if err := p.next(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *shellParser) expect(val string) error {
+	if (p.tok.kind == shellOperator && p.tok.val == val) || p.isReserved(val) {
+		return p.next()
+	}
+	return p.errorf("expected %q, found %s", val, p.tok)
+}
+
+func (p *shellParser) atStop(stop []string) bool {
+	for _, s := range stop {
+		if p.isOperator(s) || p.isReserved(s) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *shellParser) isOperator(op string) bool {
+	return p.tok.kind == shellOperator && p.tok.val == op
+}
+
+func (p *shellParser) isReserved(word string) bool {
+	return p.tok.kind == shellWord && p.tok.literal && p.tok.val == word
+}
+
+func (p *shellParser) isRedirect() bool {
+	return p.tok.kind == shellOperator && hasString(shellRedirects, p.tok.val)
+}
+
+func (p *shellParser) unexpected() error {
+	return p.errorf("unexpected %s", p.tok)
+}
+
+func (p *shellParser) errorf(format string, args ...interface{}) error {
+	return &shellSyntaxError{line: p.tok.line, message: fmt.Sprintf(format, args...)}
+}
+
+func (p *shellParser) bashism(message string) {
+	p.script.bashisms = append(p.script.bashisms, shellIssue{line: p.line, message: message})
+}
+
+// next reads the next token into p.tok
+func (p *shellParser) next() error {
+	// Skip blanks, line continuations, and comments
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		switch {
+		case c == ' ' || c == '\t':
+			p.pos++
+			continue
+		case c == '\\' && p.pos+1 < len(p.src) && p.src[p.pos+1] == '\n':
+			p.pos += 2
+			p.line++
+			continue
+		case c == '#':
+			for p.pos < len(p.src) && p.src[p.pos] != '\n' {
+				p.pos++
+			}
+			continue
+		}
+		break
+	}
+
+	if p.pos >= len(p.src) {
+		if len(p.heredocs) > 0 {
+			return &shellSyntaxError{line: p.heredocs[0].line,
+				message: fmt.Sprintf("here-document is not terminated by %q", p.heredocs[0].delimiter)}
+		}
+		p.tok = shellToken{kind: shellEOF, line: p.line}
+		return nil
+	}
+
+	if p.src[p.pos] == '\n' {
+		p.tok = shellToken{kind: shellNewline, val: "\n", line: p.line}
+		p.pos++
+		p.line++
+		return p.readHeredocs()
+	}
+
+	for _, op := range shellOperators {
+		if strings.HasPrefix(p.src[p.pos:], op) {
+			p.tok = shellToken{kind: shellOperator, val: op, line: p.line}
+			p.pos += len(op)
+			return nil
+		}
+	}
+
+	return p.readWord()
+}
+
+// readHeredocs reads the bodies of here-documents that start on the line that
+// just ended.
+func (p *shellParser) readHeredocs() error {
+	for _, heredoc := range p.heredocs {
+		for {
+			if p.pos >= len(p.src) {
+				return &shellSyntaxError{line: heredoc.line,
+					message: fmt.Sprintf("here-document is not terminated by %q", heredoc.delimiter)}
+			}
+			end := strings.IndexByte(p.src[p.pos:], '\n')
+			var line string
+			if end == -1 {
+				line = p.src[p.pos:]
+				p.pos = len(p.src)
+			} else {
+				line = p.src[p.pos : p.pos+end]
+				p.pos += end + 1
+			}
+			p.line++
+			if heredoc.stripTabs {
+				line = strings.TrimLeft(line, "\t")
+			}
+			if line == heredoc.delimiter {
+				break
+			}
+		}
+	}
+	p.heredocs = nil
+	return nil
+}
+
+// readWord reads a word, including any quoted sections and expansions
+func (p *shellParser) readWord() error {
+	start := p.pos
+	tok := shellToken{kind: shellWord, line: p.line, literal: true}
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		if strings.IndexByte(" \t\n;&|<>()", c) >= 0 {
+			break
+		}
+		switch c {
+		case '\\':
+			tok.literal = false
+			p.pos++
+			if p.pos < len(p.src) {
+				if p.src[p.pos] == '\n' {
+					p.line++
+				}
+				p.pos++
+			}
+		case '\'':
+			tok.literal = false
+			if err := p.readSingleQuoted(); err != nil {
+				return err
+			}
+		case '"':
+			tok.literal = false
+			if err := p.readDoubleQuoted(); err != nil {
+				return err
+			}
+		case '`':
+			tok.literal = false
+			if err := p.readBackquoted(); err != nil {
+				return err
+			}
+		case '$':
+			tok.literal = false
+			if err := p.readDollar(); err != nil {
+				return err
+			}
+		default:
+			p.pos++
+		}
+	}
+	tok.val = p.src[start:p.pos]
+	if tok.literal && p.pos < len(p.src) && (p.src[p.pos] == '<' || p.src[p.pos] == '>') &&
+		strings.Trim(tok.val, "0123456789") == "" {
+		tok.ioNumber = true
+	}
+	p.tok = tok
+	return nil
+}
+
+func (p *shellParser) readSingleQuoted() error {
+	line := p.line
+	p.pos++ // opening quote
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		p.pos++
+		if c == '\n' {
+			p.line++
+		}
+		if c == '\'' {
+			return nil
+		}
+	}
+	return &shellSyntaxError{line: line, message: "unterminated single quote"}
+}
+
+func (p *shellParser) readDoubleQuoted() error {
+	line := p.line
+	p.pos++ // opening quote
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		switch c {
+		case '"':
+			p.pos++
+			return nil
+		case '\\':
+			p.pos++
+			if p.pos < len(p.src) {
+				if p.src[p.pos] == '\n' {
+					p.line++
+				}
+				p.pos++
+			}
+		case '`':
+			if err := p.readBackquoted(); err != nil {
+				return err
+			}
+		case '$':
+			if p.pos+1 < len(p.src) && p.src[p.pos+1] == '\'' {
+				// $' is not special inside double quotes
+				p.pos++
+				continue
+			}
+			if err := p.readDollar(); err != nil {
+				return err
+			}
+		case '\n':
+			p.line++
+			p.pos++
+		default:
+			p.pos++
+		}
+	}
+	return &shellSyntaxError{line: line, message: "unterminated double quote"}
+}
+
+// readBackquoted reads an old style `command` substitution and parses the
+// command inside it.
+func (p *shellParser) readBackquoted() error {
+	line := p.line
+	p.pos++ // opening backquote
+	inner := []byte{}
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		switch {
+		case c == '`':
+			p.pos++
+			return p.parseNested(string(inner), line)
+		case c == '\\' && p.pos+1 < len(p.src) && strings.IndexByte("$`\\", p.src[p.pos+1]) >= 0:
+			inner = append(inner, p.src[p.pos+1])
+			p.pos += 2
+		default:
+			if c == '\n' {
+				p.line++
+			}
+			inner = append(inner, c)
+			p.pos++
+		}
+	}
+	return &shellSyntaxError{line: line, message: "unterminated ` command substitution"}
+}
+
+// parseNested parses the contents of a `command` substitution as a script
+func (p *shellParser) parseNested(src string, line int) error {
+	nested := &shellParser{src: src, line: line, script: p.script}
+	if err := nested.parseProgram(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// readDollar reads a parameter expansion, command substitution, or arithmetic
+// expansion starting with $
+func (p *shellParser) readDollar() error {
+	line := p.line
+	p.pos++ // $
+	if p.pos >= len(p.src) {
+		return nil
+	}
+	switch p.src[p.pos] {
+	case '(':
+		if strings.HasPrefix(p.src[p.pos:], "((") {
+			p.pos += 2
+			return p.skipBalanced('(', ')', line, "$((")
+		}
+		return p.readCommandSubstitution()
+	case '{':
+		start := p.pos + 1
+		p.pos++
+		if err := p.skipBalanced('{', '}', line, "${"); err != nil {
+			return err
+		}
+		p.checkParameterExpansion(p.src[start : p.pos-1])
+	case '\'':
+		p.bashism("$'...' quoting is a bash extension; use printf")
+		return p.readSingleQuoted()
+	case '[':
+		p.bashism("$[ ] arithmetic is a bash extension; use $(( ))")
+		p.pos++
+		return p.skipBalanced('[', ']', line, "$[")
+	default:
+		for p.pos < len(p.src) && isShellNameChar(p.src[p.pos]) {
+			p.pos++
+		}
+	}
+	return nil
+}
+
+// readCommandSubstitution parses the commands in $( ... ). The current
+// position is on the opening parenthesis.
+func (p *shellParser) readCommandSubstitution() error {
+	p.pos++ // (
+	saved := p.tok
+	savedHeredocs := p.heredocs
+	p.heredocs = nil
+	if err := p.next(); err != nil {
+		return err
+	}
+	if _, err := p.parseList(")"); err != nil {
+		return err
+	}
+	if !p.isOperator(")") {
+		return p.unexpected()
+	}
+	// The lexer has already moved past the closing parenthesis
+	p.tok = saved
+	p.heredocs = savedHeredocs
+	return nil
+}
+
+// skipBalanced moves past the closing character matching an opening one that
+// has already been read, respecting quotes.
+func (p *shellParser) skipBalanced(open, close byte, line int, what string) error {
+	depth := 1
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		switch c {
+		case open:
+			depth++
+			p.pos++
+		case close:
+			depth--
+			p.pos++
+			if depth == 0 {
+				if what == "$((" {
+					// Arithmetic ends with ))
+					if p.pos < len(p.src) && p.src[p.pos] == ')' {
+						p.pos++
+						return nil
+					}
+					return &shellSyntaxError{line: line, message: "expected )) to end arithmetic expansion"}
+				}
+				return nil
+			}
+		case '\\':
+			p.pos += 2
+		case '\'':
+			if err := p.readSingleQuoted(); err != nil {
+				return err
+			}
+		case '"':
+			if err := p.readDoubleQuoted(); err != nil {
+				return err
+			}
+		case '`':
+			if err := p.readBackquoted(); err != nil {
+				return err
+			}
+		case '$':
+			if err := p.readDollar(); err != nil {
+				return err
+			}
+		case '\n':
+			p.line++
+			p.pos++
+		default:
+			p.pos++
+		}
+	}
+	return &shellSyntaxError{line: line, message: fmt.Sprintf("unterminated %s", what)}
+}
+
+// checkParameterExpansion looks for bash-only forms of ${...}
+func (p *shellParser) checkParameterExpansion(expr string) {
+	if strings.HasPrefix(expr, "!") {
+		p.bashism("${!name} indirect expansion is a bash extension")
+		return
+	}
+	name := 0
+	for name < len(expr) && isShellNameChar(expr[name]) {
+		name++
+	}
+	rest := expr[name:]
+	switch {
+	case strings.HasPrefix(rest, "/"):
+		p.bashism("${name/pattern/replacement} is a bash extension; use sed")
+	case strings.HasPrefix(rest, "^") || strings.HasPrefix(rest, ","):
+		p.bashism("${name^} and ${name,} case conversion are bash extensions; use tr")
+	case strings.HasPrefix(rest, ":") && len(rest) > 1 && strings.IndexByte("-=?+", rest[1]) == -1:
+		p.bashism("${name:offset:length} substrings are a bash extension; use cut or expr")
+	case strings.HasPrefix(rest, "["):
+		p.bashism("arrays are a bash extension")
+	}
+}
+
+func isShellNameChar(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+// commandBashisms looks for bash-only builtins and test operators in the
+// commands of a parsed script.
+func (s *shellScript) commandBashisms() []shellIssue {
+	issues := []shellIssue{}
+	for _, cmd := range s.commands {
+		name := cmd.args[0]
+		switch {
+		case name == "[[":
+			issues = append(issues, shellIssue{cmd.line, "[[ ]] tests are a bash extension; use [ ]"})
+		case hasString(shellBashBuiltins, name):
+			message := fmt.Sprintf("%s is a bash builtin", name)
+			if name == "source" {
+				message += "; use . instead"
+			}
+			issues = append(issues, shellIssue{cmd.line, message})
+		case (name == "[" || name == "test") && hasString(cmd.args, "=="):
+			issues = append(issues, shellIssue{cmd.line, "== in tests is a bash extension; use ="})
+		case name == "echo" && len(cmd.args) > 1 && (strings.HasPrefix(cmd.args[1], "-e") || cmd.args[1] == "-E"):
+			issues = append(issues, shellIssue{cmd.line, "echo " + cmd.args[1] + " is not portable; use printf"})
+		}
+	}
+	return issues
+}
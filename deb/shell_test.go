@@ -0,0 +1,111 @@
+package deb
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseShell(t *testing.T) {
+	valid := []string{
+		"echo hello; echo world & wait",
+		"if [ -d /tmp ]; then\n\techo yes\nelif true; then :\nelse\n\techo no\nfi",
+		"while read line; do echo \"$line\"; done < /etc/passwd",
+		"for f in a b c; do\n\techo $f\ndone\nfor g\ndo :; done",
+		"case \"$1\" in\n(configure|upgrade) echo $1 ;;\n*) ;;\nesac",
+		"f() {\n\tlocal x=$(echo \"$(echo nested)\")\n}\nf 2>/dev/null || true",
+		"x=`echo \\`echo hi\\``; y=$((1 + (2 * 3)))",
+		"cat <<EOF\n$(not parsed\nEOF\necho done",
+		"cat <<-'END' | tr a b\n\tif\n\tEND\n",
+		"# comment with ' quote\necho 'it''s' \"a ) b\" ${x:-}",
+		"echo $(case x in x) echo y ;; esac)",
+		"( cd / && ls ) | { read a; echo \"$a\"; }",
+		"! grep -q foo /etc/hosts",
+		"echo one \\\n\ttwo",
+	}
+	for _, src := range valid {
+		if _, err := parseShell(src); err != nil {
+			t.Errorf("Expected %q to parse; found %s", src, err)
+		}
+	}
+
+	invalid := map[string]int{
+		"if true; then\necho\n":            3,
+		"echo 'unterminated":               1,
+		"echo ok\necho \"unterminated\n\n": 2,
+		"for x in a b; echo; done":         1,
+		"case $1 in\nfoo) echo\nesac\nfi":  4,
+		"echo ok\ncat <<EOF\nno end\n":     2,
+		"echo $(echo":                      1,
+		"while true\ndo\ndone":             3,
+		"echo a |":                         1,
+		"then echo":                        1,
+		"echo ok\n\necho (":                3,
+		"echo ${unterminated":              1,
+		"f() echo":                         1,
+	}
+	for src, line := range invalid {
+		_, err := parseShell(src)
+		syntaxErr, ok := err.(*shellSyntaxError)
+		if !ok {
+			t.Errorf("Expected a syntax error for %q; found %v", src, err)
+			continue
+		}
+		if syntaxErr.line != line {
+			t.Errorf("Expected syntax error for %q on line %d; found %s", src, line, syntaxErr)
+		}
+	}
+
+	if _, err := parseShell("if true; then\necho\n"); err == nil || !strings.Contains(err.Error(), `expected "fi"`) {
+		t.Errorf("Expected missing fi error; found %v", err)
+	}
+}
+
+func TestParseShellCommands(t *testing.T) {
+	script, err := parseShell("set -e\nif [ \"$1\" = configure ]; then\n\tadduser --system foo 2>/dev/null\nfi\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(script.commands) != 3 {
+		t.Fatalf("Expected 3 commands; found %+v", script.commands)
+	}
+	cmd := script.commands[2]
+	if cmd.line != 3 || len(cmd.args) != 3 || cmd.args[0] != "adduser" || cmd.args[2] != "foo" {
+		t.Errorf("Unexpected command %+v", cmd)
+	}
+}
+
+func TestParseShellBashisms(t *testing.T) {
+	cases := map[string]string{
+		"[[ -f /etc/foo ]] && echo": "[[ ]]",
+		"function foo {\n\techo\n}": "function keyword",
+		"source /etc/default/foo":   "use . instead",
+		"[ \"$1\" == configure ]":   "== in tests",
+		"echo -e 'a\\tb'":           "echo -e",
+		"cat <<< \"$x\"":            "here-strings",
+		"echo hi &>/dev/null":       "&> redirection",
+		"echo ${x/a/b}":             "${name/pattern/replacement}",
+		"echo ${x:0:2}":             "substrings",
+		"echo $'\\n'":               "$'...'",
+		"(( x++ ))":                 "(( ))",
+		"declare -a foo":            "declare",
+	}
+	for src, expected := range cases {
+		script, err := parseShell(src)
+		if err != nil {
+			t.Errorf("Failed to parse %q: %s", src, err)
+			continue
+		}
+		issues := append(script.bashisms, script.commandBashisms()...)
+		if len(issues) != 1 || !strings.Contains(issues[0].message, expected) {
+			t.Errorf("Expected one bashism containing %q in %q; found %+v", expected, src, issues)
+		}
+	}
+
+	script, err := parseShell("[ \"$x\" = '==' ] && echo -n \"${x%.deb}\" ${x:-default}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if issues := append(script.bashisms, script.commandBashisms()...); len(issues) != 0 {
+		t.Errorf("Expected no bashisms; found %+v", issues)
+	}
+}
@@ -0,0 +1,225 @@
+package deb
+
+import (
+	"bufio"
+	"debug/elf"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"unicode"
+)
+
+// dpkgInfoDir is where dpkg keeps the shlibs and symbols files of installed
+// packages. It is used to resolve shared libraries when Shlibs is not set.
+var dpkgInfoDir = "/var/lib/dpkg/info"
+
+// CalculateShlibDepends inspects the DT_NEEDED entries of every ELF binary in
+// the package and returns the dependencies that provide those shared libraries.
+// Libraries are resolved using the Shlibs file, or the shlibs and symbols files
+// of the packages installed on this system if Shlibs is not specified.
+//
+// Libraries that are shipped in this package are skipped. It is an error if a
+// library cannot be resolved.
+func (p *PackageSpec) CalculateShlibDepends() ([]string, error) {
+	binaries, err := p.listBinaries()
+	if err != nil {
+		return nil, err
+	}
+
+	// Gather the libraries we need and the ones we provide ourselves
+	needed := []string{}
+	shipped := map[string]struct{}{}
+	for _, binary := range binaries {
+		file, err := p.openELF(binary.filename)
+		if err != nil {
+			return nil, err
+		}
+		libs, err := file.ImportedLibraries()
+		if err != nil {
+			file.Close()
+			return nil, fmt.Errorf("Failed to read shared libraries from %q: %s", binary.filename, err)
+		}
+		sonames, err := file.DynString(elf.DT_SONAME)
+		file.Close()
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read soname from %q: %s", binary.filename, err)
+		}
+
+		// Libraries may be installed under a different name than the source
+		target, err := p.NormalizeFilename(binary.filename)
+		if err != nil {
+			return nil, err
+		}
+		shipped[path.Base(target)] = struct{}{}
+		for _, soname := range sonames {
+			shipped[soname] = struct{}{}
+		}
+		for _, lib := range libs {
+			if !hasString(needed, lib) {
+				needed = append(needed, lib)
+			}
+		}
+	}
+
+	if len(needed) == 0 {
+		return []string{}, nil
+	}
+
+	shlibs, err := p.loadShlibs()
+	if err != nil {
+		return nil, err
+	}
+	return resolveShlibs(needed, shipped, shlibs)
+}
+
+// AddShlibDepends appends the result of CalculateShlibDepends to Depends,
+// skipping any dependencies that are already listed.
+func (p *PackageSpec) AddShlibDepends() error {
+	deps, err := p.CalculateShlibDepends()
+	if err != nil {
+		return err
+	}
+	// Copy Depends since it may be shared with other architectures' specs
+	depends := append([]string{}, p.Depends...)
+	for _, dep := range deps {
+		if !hasString(depends, dep) {
+			depends = append(depends, dep)
+		}
+	}
+	p.Depends = depends
+	return nil
+}
+
+// loadShlibs reads the library mapping from Shlibs or from the dpkg database.
+func (p *PackageSpec) loadShlibs() (map[string]string, error) {
+	shlibs := map[string]string{}
+
+	files := []string{}
+	if p.Shlibs != "" {
+		files = append(files, p.Shlibs)
+	} else {
+		for _, pattern := range []string{"*.shlibs", "*.symbols"} {
+			matches, err := filepath.Glob(filepath.Join(dpkgInfoDir, pattern))
+			if err != nil {
+				return nil, err
+			}
+			files = append(files, matches...)
+		}
+	}
+
+	for _, filename := range files {
+		var file io.ReadCloser
+		var err error
+		if filename == p.Shlibs {
+			file, err = p.openFile(filename)
+		} else {
+			file, err = os.Open(filename)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read shlibs %q: %s", filename, err)
+		}
+		err = parseShlibs(file, shlibs)
+		file.Close()
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse shlibs %q: %s", filename, err)
+		}
+	}
+	return shlibs, nil
+}
+
+// resolveShlibs maps each needed library to its dependency. Libraries that are
+// shipped in the package are skipped.
+func resolveShlibs(needed []string, shipped map[string]struct{}, shlibs map[string]string) ([]string, error) {
+	deps := []string{}
+	missing := []string{}
+	for _, lib := range needed {
+		if _, ok := shipped[lib]; ok {
+			continue
+		}
+		dep, ok := shlibs[lib]
+		if !ok {
+			missing = append(missing, lib)
+			continue
+		}
+		for _, d := range strings.Split(dep, ",") {
+			d = strings.TrimSpace(d)
+			if d != "" && !hasString(deps, d) {
+				deps = append(deps, d)
+			}
+		}
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("No dependency information found for shared libraries: %s", strings.Join(missing, ", "))
+	}
+	return deps, nil
+}
+
+// parseShlibs reads a dpkg shlibs or symbols file and adds each library soname
+// and the dependency that provides it to shlibs. Libraries that are already in
+// shlibs are not replaced.
+//
+// shlibs files have lines like:
+//
+//	libz 1 zlib1g (>= 1:1.1.4)
+//
+// symbols files have a header line for each library followed by indented
+// symbols, which are ignored:
+//
+//	libz.so.1 zlib1g #MINVER#
+//	 adler32@ZLIB_1.2.0 1:1.2.0
+//
+// Since symbols are not inspected, #MINVER# is dropped rather than replaced by
+// a minimum version.
+func parseShlibs(r io.Reader, shlibs map[string]string) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		// Symbols, alternative dependencies and metadata in symbols files
+		if unicode.IsSpace(rune(line[0])) || line[0] == '|' || line[0] == '*' {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		// Skip entries for other package types like udeb:
+		if strings.HasSuffix(fields[0], ":") {
+			continue
+		}
+
+		if strings.Contains(fields[0], ".so") {
+			// symbols header
+			deps := []string{}
+			for _, field := range fields[1:] {
+				if field != "#MINVER#" {
+					deps = append(deps, field)
+				}
+			}
+			if len(deps) == 0 {
+				return fmt.Errorf("Missing dependency for %q", fields[0])
+			}
+			addShlib(shlibs, fields[0], strings.Join(deps, " "))
+			continue
+		}
+
+		if len(fields) < 3 {
+			return fmt.Errorf("Expected 'library version dependency' but found %q", line)
+		}
+		dep := strings.Join(fields[2:], " ")
+		addShlib(shlibs, fields[0]+".so."+fields[1], dep)
+		addShlib(shlibs, fields[0]+"-"+fields[1]+".so", dep)
+	}
+	return scanner.Err()
+}
+
+func addShlib(shlibs map[string]string, soname, dep string) {
+	if _, ok := shlibs[soname]; !ok {
+		shlibs[soname] = dep
+	}
+}
@@ -0,0 +1,89 @@
+package deb
+
+import (
+	"debug/elf"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseShlibs(t *testing.T) {
+	shlibs := map[string]string{}
+	for _, name := range []string{"example.symbols", "example.shlibs"} {
+		file, err := os.Open(path.Join("test-fixtures", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = parseShlibs(file, shlibs)
+		file.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expected := map[string]string{
+		"libc.so.6":     "libc6 (>= 2.14)",
+		"libc-6.so":     "libc6 (>= 2.14)",
+		"libm.so.6":     "libc6 (>= 2.14)",
+		"libm-6.so":     "libc6 (>= 2.14)",
+		"libssl.so.1.1": "libssl1.1 (>= 1.1.0), openssl",
+		"libssl-1.1.so": "libssl1.1 (>= 1.1.0), openssl",
+		"libz.so.1":     "zlib1g",
+		"libz-1.so":     "zlib1g-other",
+	}
+	if !reflect.DeepEqual(shlibs, expected) {
+		t.Errorf("--Expected--\n%+v\n--Found--\n%+v\n", expected, shlibs)
+	}
+}
+
+func TestResolveShlibs(t *testing.T) {
+	shlibs := map[string]string{
+		"libc.so.6":     "libc6 (>= 2.14)",
+		"libm.so.6":     "libc6 (>= 2.14)",
+		"libssl.so.1.1": "libssl1.1 (>= 1.1.0), openssl",
+	}
+	shipped := map[string]struct{}{
+		"libmine.so.1": struct{}{},
+	}
+
+	deps, err := resolveShlibs([]string{"libc.so.6", "libmine.so.1", "libm.so.6", "libssl.so.1.1"}, shipped, shlibs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"libc6 (>= 2.14)", "libssl1.1 (>= 1.1.0)", "openssl"}
+	if !reflect.DeepEqual(deps, expected) {
+		t.Errorf("Expected %+v got %+v", expected, deps)
+	}
+
+	_, err = resolveShlibs([]string{"libc.so.6", "libunknown.so.2"}, shipped, shlibs)
+	if err == nil || !strings.Contains(err.Error(), "libunknown.so.2") {
+		t.Fatalf("Expected unresolved library error; found %+v", err)
+	}
+}
+
+func TestShlibDependsStatic(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mkdeb-elf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	p := PackageSpecFixture(t)
+	p.Version = "0.1.0"
+	p.AutoPath = dir
+	p.Shlibs = path.Join("test-fixtures", "example.shlibs")
+	p.Depends = []string{"tree"}
+
+	writeELF(t, filepath.Join(dir, "usr", "bin", "static"), elf.ELFCLASS64, elf.ELFDATA2LSB, elf.EM_X86_64, 0)
+
+	if err := p.AddShlibDepends(); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"tree"}
+	if !reflect.DeepEqual(p.Depends, expected) {
+		t.Errorf("Expected %+v got %+v", expected, p.Depends)
+	}
+}
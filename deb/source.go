@@ -0,0 +1,149 @@
+package deb
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// hostFS reads files from the host file system. Unlike os.DirFS, names are
+// host paths which may be absolute or relative to dir, or to the working
+// directory if dir is empty. It is used when PackageSpec.Source is not set.
+type hostFS struct {
+	dir string
+}
+
+func (h hostFS) Open(name string) (fs.File, error)      { return os.Open(h.path(name)) }
+func (h hostFS) Stat(name string) (fs.FileInfo, error)  { return os.Stat(h.path(name)) }
+func (h hostFS) Lstat(name string) (fs.FileInfo, error) { return os.Lstat(h.path(name)) }
+func (h hostFS) ReadFile(name string) ([]byte, error)   { return ioutil.ReadFile(h.path(name)) }
+func (h hostFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	return os.ReadDir(h.path(name))
+}
+
+// path returns the host path of name
+func (h hostFS) path(name string) string {
+	if h.dir == "" || filepath.IsAbs(name) {
+		return name
+	}
+	return filepath.Join(h.dir, name)
+}
+
+// hostPath resolves a path on the host, such as TempPath, against BaseDir
+func (p *PackageSpec) hostPath(name string) string {
+	return hostFS{dir: p.BaseDir}.path(name)
+}
+
+// sourceFS returns the file system that package files are read from, and the
+// name of the file in it. Files written to the build workspace, such as
+// stripped binaries, are always read from the host.
+func (p *PackageSpec) sourceFS(name string) (fs.FS, string, error) {
+	if p.Source == nil || p.isWorkspaceFile(name) {
+		return hostFS{dir: p.BaseDir}, name, nil
+	}
+	clean := path.Clean(filepath.ToSlash(name))
+	if !fs.ValidPath(clean) {
+		return nil, "", fmt.Errorf("%q is not a path inside the package source; expected a relative path", name)
+	}
+	return p.Source, clean, nil
+}
+
+// isWorkspaceFile reports whether name was written to the build workspace
+func (p *PackageSpec) isWorkspaceFile(name string) bool {
+	return p.workspace != "" && strings.HasPrefix(name, p.workspace+string(filepath.Separator))
+}
+
+// openFile opens a file from the package source
+func (p *PackageSpec) openFile(name string) (fs.File, error) {
+	fsys, name, err := p.sourceFS(name)
+	if err != nil {
+		return nil, err
+	}
+	return fsys.Open(name)
+}
+
+// readFile reads a file from the package source
+func (p *PackageSpec) readFile(name string) ([]byte, error) {
+	fsys, name, err := p.sourceFS(name)
+	if err != nil {
+		return nil, err
+	}
+	return fs.ReadFile(fsys, name)
+}
+
+// statFile returns information about a file in the package source, following
+// symlinks
+func (p *PackageSpec) statFile(name string) (fs.FileInfo, error) {
+	fsys, name, err := p.sourceFS(name)
+	if err != nil {
+		return nil, err
+	}
+	return fs.Stat(fsys, name)
+}
+
+// lstatFile returns information about a file in the package source without
+// following symlinks. File systems without symlink support use Stat.
+func (p *PackageSpec) lstatFile(name string) (fs.FileInfo, error) {
+	fsys, name, err := p.sourceFS(name)
+	if err != nil {
+		return nil, err
+	}
+	if lstater, ok := fsys.(interface {
+		Lstat(name string) (fs.FileInfo, error)
+	}); ok {
+		return lstater.Lstat(name)
+	}
+	return fs.Stat(fsys, name)
+}
+
+// fileExists reports whether a file exists in the package source
+func (p *PackageSpec) fileExists(name string) bool {
+	_, err := p.statFile(name)
+	return err == nil
+}
+
+// openReaderAt opens a file for random access, which debug/elf needs. Files
+// that do not support it are read into memory.
+func openReaderAt(fsys fs.FS, name string) (io.ReaderAt, io.Closer, error) {
+	file, err := fsys.Open(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	if r, ok := file.(io.ReaderAt); ok {
+		return r, file, nil
+	}
+	defer file.Close()
+	data, err := ioutil.ReadAll(file)
+	if err != nil {
+		return nil, nil, err
+	}
+	return bytes.NewReader(data), ioutil.NopCloser(nil), nil
+}
+
+// copyToHost copies a file from the package source to the host, for programs
+// like objcopy that can't read from the source
+func (p *PackageSpec) copyToHost(name, target string) error {
+	file, err := p.openFile(name)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	out, err := os.Create(target)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, file); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,174 @@
+package deb
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+)
+
+// sourceFixture holds the files for test-fixtures/source.json
+var sourceFixture = fstest.MapFS{
+	"deb-pkg/usr/bin/mkdeb":       {Data: []byte("#!/bin/sh\necho mkdeb\n"), Mode: 0755},
+	"deb-pkg/etc/mkdeb/mkdeb.ini": {Data: []byte("verbose = true\n"), Mode: 0644},
+	"deb-pkg/postinst":            {Data: []byte("#!/bin/sh\nset -e\nif [ \"$1\" = configure ]; then\n  echo hi\nfi\n"), Mode: 0755},
+	"build/tool":                  {Data: []byte("tool"), Mode: 0755},
+	"debian/templates":            {Data: []byte("Template: mkdeb/question\nType: boolean\nDescription: Are you sure?\n"), Mode: 0644},
+}
+
+func TestSourceListFiles(t *testing.T) {
+	p := PackageSpecFixture(t, "source.json")
+	p.Version = "0.1.0"
+	p.Source = sourceFixture
+
+	files, err := p.ListFiles(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	targets := []string{}
+	for _, file := range files {
+		target, err := p.NormalizeFilename(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		targets = append(targets, target)
+	}
+	expected := "etc/mkdeb/mkdeb.ini,usr/bin/mkdeb,usr/bin/tool"
+	if strings.Join(targets, ",") != expected {
+		t.Errorf("Expected %s; found %s", expected, strings.Join(targets, ","))
+	}
+
+	if _, ok := p.MapControlFiles()["postinst"]; !ok {
+		t.Errorf("Expected postinst to be found in the source")
+	}
+
+	etc, err := p.ListEtcFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(etc, ",") != "/etc/mkdeb/mkdeb.ini" {
+		t.Errorf("Unexpected conffiles %v", etc)
+	}
+}
+
+func TestSourceBuild(t *testing.T) {
+	p := PackageSpecFixture(t, "source.json")
+	p.Version = "0.1.0"
+	p.Source = sourceFixture
+
+	buf := &bytes.Buffer{}
+	if _, err := p.WriteTo(buf); err != nil {
+		t.Fatal(err)
+	}
+
+	_, members := readArMembers(t, buf.Bytes())
+	control := strings.Join(readTarGz(t, members["control.tar.gz"]), ",")
+	for _, name := range []string{"postinst", "templates", "md5sums"} {
+		if !strings.Contains(control, name) {
+			t.Errorf("Expected %s in control archive; found %s", name, control)
+		}
+	}
+	data := strings.Join(readTarGz(t, members["data.tar.gz"]), ",")
+	for _, name := range []string{"usr/bin/mkdeb", "usr/bin/tool", "etc/mkdeb/mkdeb.ini"} {
+		if !strings.Contains(data, name) {
+			t.Errorf("Expected %s in data archive; found %s", name, data)
+		}
+	}
+
+	sums, err := p.CalculateChecksums()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// md5 of "tool"
+	if !strings.Contains(string(sums), "39ab32c5aeb56c9f5ae17f073ce31023  usr/bin/tool") {
+		t.Errorf("Unexpected md5sums\n%s", sums)
+	}
+
+	size, err := p.CalculateSize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size != 1 {
+		t.Errorf("Expected size of 1K; found %d", size)
+	}
+}
+
+func TestBaseDir(t *testing.T) {
+	base, err := filepath.Abs("test-fixtures")
+	if err != nil {
+		t.Fatal(err)
+	}
+	target, err := ioutil.TempDir("", "mkdeb-basedir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(target)
+
+	expected, err := PackageSpecFixture(t).ListFiles(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Build packages with relative paths at the same time, which would not
+	// work if the paths depended on the working directory
+	packages := []string{"mkdeb", "mkdeb-other"}
+	errs := make([]error, len(packages))
+	var wg sync.WaitGroup
+	for i, name := range packages {
+		p := PackageSpecFixture(t)
+		p.Package = name
+		p.Version = "0.1.0"
+		p.AutoPath = "package1"
+		p.TempPath = "."
+		p.BaseDir = base
+
+		files, err := p.ListFiles(false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(files) != len(expected) {
+			t.Errorf("Expected %d files relative to BaseDir; found %v", len(expected), files)
+		}
+
+		wg.Add(1)
+		go func(i int, p *PackageSpec) {
+			defer wg.Done()
+			errs[i] = p.Build(target)
+		}(i, p)
+	}
+	wg.Wait()
+
+	for i, name := range packages {
+		if errs[i] != nil {
+			t.Fatalf("Failed to build %s: %s", name, errs[i])
+		}
+		if !FileExists(filepath.Join(target, name+"_0.1.0_amd64.deb")) {
+			t.Errorf("Expected %s to be built", name)
+		}
+	}
+
+	// The workspaces in TempPath are removed after the build
+	workspaces, _ := filepath.Glob(filepath.Join(base, "mkdeb*"))
+	if len(workspaces) > 0 {
+		t.Errorf("Expected workspaces in BaseDir to be removed; found %v", workspaces)
+	}
+}
+
+func TestSourceInvalidPath(t *testing.T) {
+	p := PackageSpecFixture(t, "source.json")
+	p.Version = "0.1.0"
+	p.Source = sourceFixture
+
+	// Files must be inside the source, like the config directory in the CLI
+	for _, source := range []string{"../outside", "/usr/bin/outside"} {
+		p.Files = map[string]string{source: "/usr/bin/outside"}
+		_, err := p.CalculateChecksums()
+		if err == nil || !strings.Contains(err.Error(), "not a path inside the package source") {
+			t.Errorf("Expected invalid path error for %s; found %v", source, err)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package deb
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"text/template"
+)
+
+// systemdUnitPath is where systemd units are installed
+const systemdUnitPath = "/lib/systemd/system"
+
+var reSystemdUnit = regexp.MustCompile(`^[a-zA-Z0-9:_.@-]+\.(service|socket|device|mount|automount|swap|target|path|timer|slice|scope)$`)
+
+// SystemdUnit describes a systemd unit file that is installed by the package.
+// The maintainer scripts needed to enable, start, stop and clean up the unit
+// are generated automatically, in the same way as dh_installsystemd.
+//
+// Unit is the path to the unit file. Name is the name it will be installed as
+// under /lib/systemd/system, and defaults to the filename of Unit.
+//
+// Enable enables the unit when the package is installed. Start starts the unit
+// when the package is installed and stops it when the package is removed.
+// RestartOnUpgrade restarts the unit after the new version of the package has
+// been unpacked, instead of stopping the unit before the upgrade and starting
+// it again afterwards.
+type SystemdUnit struct {
+	Unit             string `json:"unit"`
+	Name             string `json:"name,omitempty"`
+	Enable           bool   `json:"enable"`
+	Start            bool   `json:"start"`
+	RestartOnUpgrade bool   `json:"restartOnUpgrade,omitempty"`
+}
+
+// UnitName returns the name the unit is installed as
+func (u SystemdUnit) UnitName() string {
+	if u.Name != "" {
+		return u.Name
+	}
+	return path.Base(u.Unit)
+}
+
+// validateSystemd checks that all of the systemd units have valid names
+func (p *PackageSpec) validateSystemd() error {
+	for _, unit := range p.Systemd {
+		if unit.Unit == "" {
+			return fmt.Errorf("Systemd unit is missing the unit file")
+		}
+		if !reSystemdUnit.MatchString(unit.UnitName()) {
+			return fmt.Errorf("Systemd unit %q is invalid; expected something like 'foo.service' matching %q",
+				unit.UnitName(), reSystemdUnit.String())
+		}
+	}
+	return nil
+}
+
+// systemdFiles maps the systemd unit files to their install path
+func (p *PackageSpec) systemdFiles() map[string]string {
+	files := map[string]string{}
+	for _, unit := range p.Systemd {
+		files[unit.Unit] = path.Join(systemdUnitPath, unit.UnitName())
+	}
+	return files
+}
+
+// systemdScripts renders the maintainer script fragments for the systemd units.
+// The map is keyed by script name, e.g. postinst.
+func (p *PackageSpec) systemdScripts() (map[string][]byte, error) {
+	return renderScriptTemplates(systemdTemplates, len(p.Systemd) > 0, p.Systemd)
+}
+
+var systemdTemplates = map[string]*template.Template{
+	"postinst": template.Must(template.New("postinst").Parse(systemdPostinstTemplate)),
+	"prerm":    template.Must(template.New("prerm").Parse(systemdPrermTemplate)),
+	"postrm":   template.Must(template.New("postrm").Parse(systemdPostrmTemplate)),
+}
+
+const systemdPostinstTemplate = `{{- range . }}
+# systemd: {{ .UnitName }}
+if [ "$1" = "configure" ] || [ "$1" = "abort-upgrade" ] || [ "$1" = "abort-deconfigure" ] || [ "$1" = "abort-remove" ] ; then
+{{- if .Enable }}
+	# This will only remove masks created by deb-systemd-helper on removal
+	deb-systemd-helper unmask '{{ .UnitName }}' >/dev/null || true
+
+	# was-enabled defaults to true, so new installations run enable
+	if deb-systemd-helper --quiet was-enabled '{{ .UnitName }}'; then
+		deb-systemd-helper enable '{{ .UnitName }}' >/dev/null || true
+	else
+		deb-systemd-helper update-state '{{ .UnitName }}' >/dev/null || true
+	fi
+{{- else }}
+	deb-systemd-helper update-state '{{ .UnitName }}' >/dev/null || true
+{{- end }}
+{{- if .Start }}
+	if [ -d /run/systemd/system ]; then
+		systemctl --system daemon-reload >/dev/null || true
+{{- if .RestartOnUpgrade }}
+		if [ -n "$2" ]; then
+			deb-systemd-invoke restart '{{ .UnitName }}' >/dev/null || true
+		else
+			deb-systemd-invoke start '{{ .UnitName }}' >/dev/null || true
+		fi
+{{- else }}
+		deb-systemd-invoke start '{{ .UnitName }}' >/dev/null || true
+{{- end }}
+	fi
+{{- end }}
+fi
+{{ end -}}
+`
+
+const systemdPrermTemplate = `{{- range . }}
+{{- if .Start }}
+# systemd: {{ .UnitName }}
+{{- if .RestartOnUpgrade }}
+if [ -d /run/systemd/system ] && [ "$1" = remove ]; then
+{{- else }}
+if [ -d /run/systemd/system ]; then
+{{- end }}
+	deb-systemd-invoke stop '{{ .UnitName }}' >/dev/null || true
+fi
+{{ end }}
+{{- end -}}
+`
+
+const systemdPostrmTemplate = `{{- range . }}
+# systemd: {{ .UnitName }}
+if [ -d /run/systemd/system ]; then
+	systemctl --system daemon-reload >/dev/null || true
+fi
+if [ "$1" = "remove" ]; then
+	if [ -x "/usr/bin/deb-systemd-helper" ]; then
+		deb-systemd-helper mask '{{ .UnitName }}' >/dev/null || true
+	fi
+fi
+if [ "$1" = "purge" ]; then
+	if [ -x "/usr/bin/deb-systemd-helper" ]; then
+		deb-systemd-helper purge '{{ .UnitName }}' >/dev/null || true
+		deb-systemd-helper unmask '{{ .UnitName }}' >/dev/null || true
+	fi
+fi
+{{ end -}}
+`
@@ -0,0 +1,122 @@
+package deb
+
+import (
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestValidateSystemd(t *testing.T) {
+	p := PackageSpecFixture(t, "systemd.json")
+	if err := p.Validate(false); err != nil {
+		t.Fatal(err)
+	}
+
+	p.Systemd[0].Name = "package1"
+	err := p.Validate(false)
+	if err == nil || !strings.Contains(err.Error(), "Systemd unit") {
+		t.Fatalf("Expected invalid unit error; found %+v", err)
+	}
+}
+
+func TestListFilesSystemd(t *testing.T) {
+	p := PackageSpecFixture(t, "systemd.json")
+
+	files, err := p.ListFiles(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	unit := path.Join("test-fixtures", "package1.service")
+	if !hasString(files, unit) {
+		t.Fatalf("%q is missing: %+v", unit, files)
+	}
+
+	expected := "lib/systemd/system/package1.service"
+	if filename, err := p.NormalizeFilename(unit); err != nil {
+		t.Fatal(err)
+	} else if filename != expected {
+		t.Errorf("Expected %q got %q", expected, filename)
+	}
+}
+
+func TestSystemdScripts(t *testing.T) {
+	p := PackageSpecFixture(t, "systemd.json")
+
+	scripts, err := p.systemdScripts()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string][]string{
+		"postinst": {
+			"deb-systemd-helper enable 'package1.service'",
+			"deb-systemd-invoke restart 'package1.service'",
+			"deb-systemd-invoke start 'package1.service'",
+		},
+		"prerm": {
+			`if [ -d /run/systemd/system ] && [ "$1" = remove ]; then`,
+			"deb-systemd-invoke stop 'package1.service'",
+		},
+		"postrm": {
+			"deb-systemd-helper mask 'package1.service'",
+			"deb-systemd-helper purge 'package1.service'",
+		},
+	}
+	for name, lines := range expected {
+		for _, line := range lines {
+			if !strings.Contains(string(scripts[name]), line) {
+				t.Errorf("Expected %s to contain %q\n%s", name, line, scripts[name])
+			}
+		}
+	}
+	if _, ok := scripts["preinst"]; ok {
+		t.Errorf("Unexpected systemd preinst:\n%s", scripts["preinst"])
+	}
+
+	// Without start the unit should not be stopped or started
+	p.Systemd[0].Start = false
+	scripts, err = p.systemdScripts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := scripts["prerm"]; ok {
+		t.Errorf("Unexpected systemd prerm:\n%s", scripts["prerm"])
+	}
+	if strings.Contains(string(scripts["postinst"]), "deb-systemd-invoke") {
+		t.Errorf("Unexpected deb-systemd-invoke in postinst:\n%s", scripts["postinst"])
+	}
+}
+
+func TestRenderControlScripts(t *testing.T) {
+	p := PackageSpecFixture(t, "systemd.json")
+	p.Postinst = path.Join("test-fixtures", "postinst-token")
+
+	scripts, err := p.RenderControlScripts()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// preinst comes from AutoPath and has no generated fragments
+	preinst := "#!/bin/sh\necho \"We are ready to install\"\n"
+	if string(scripts["preinst"]) != preinst {
+		t.Errorf("--Expected--\n%s\n--Found--\n%s\n", preinst, scripts["preinst"])
+	}
+
+	// postinst has the systemd fragment in place of #MKDEB#
+	postinst := string(scripts["postinst"])
+	before := strings.Index(postinst, "Before generated code")
+	generated := strings.Index(postinst, "deb-systemd-helper enable")
+	after := strings.Index(postinst, "After generated code")
+	if before == -1 || generated == -1 || after == -1 || !(before < generated && generated < after) {
+		t.Errorf("Expected systemd fragment between user code:\n%s", postinst)
+	}
+	if strings.Contains(postinst, "#MKDEB#") {
+		t.Errorf("Expected #MKDEB# to be replaced:\n%s", postinst)
+	}
+
+	// prerm is generated from scratch
+	if !strings.HasPrefix(string(scripts["prerm"]), "#!/bin/sh\nset -e\n") {
+		t.Errorf("Expected generated prerm to start with a shebang:\n%s", scripts["prerm"])
+	}
+}
@@ -0,0 +1,245 @@
+package deb
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+// readTarHeaders returns the headers of the entries in a compressed tar archive
+func readTarHeaders(t *testing.T, data []byte) map[string]*tar.Header {
+	zipreader, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	archive := tar.NewReader(zipreader)
+	headers := map[string]*tar.Header{}
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		headers[header.Name] = header
+	}
+	return headers
+}
+
+func TestTarHeaders(t *testing.T) {
+	longName := "usr/share/mkdeb/" + strings.Repeat("long-directory-name/", 8) + "file.txt"
+	modTime := time.Date(2020, 1, 2, 3, 4, 5, 678900000, time.UTC)
+
+	p := PackageSpecFixture(t)
+	p.Version = "0.1.0"
+	p.AutoPath = "deb-pkg"
+	p.Source = fstest.MapFS{
+		"deb-pkg/" + longName:  {Data: []byte("long\n"), Mode: 0644, ModTime: modTime},
+		"deb-pkg/usr/bin/tool": {Data: []byte("tool\n"), Mode: 0755, ModTime: modTime},
+		"deb-pkg/postinst":     {Data: []byte("#!/bin/sh\nset -e\n"), Mode: 0755, ModTime: modTime},
+	}
+
+	buf := &bytes.Buffer{}
+	if _, err := p.WriteTo(buf); err != nil {
+		t.Fatal(err)
+	}
+	_, members := readArMembers(t, buf.Bytes())
+
+	data := readTarHeaders(t, members["data.tar.gz"])
+	control := readTarHeaders(t, members["control.tar.gz"])
+	if _, ok := data[longName]; !ok {
+		t.Fatalf("Expected %s in the data archive", longName)
+	}
+	if _, ok := control["postinst"]; !ok {
+		t.Fatalf("Expected postinst in the control archive")
+	}
+
+	for _, headers := range []map[string]*tar.Header{data, control} {
+		for name, header := range headers {
+			if header.Format != tar.FormatGNU {
+				t.Errorf("Expected %s to have a GNU header; found %s", name, header.Format)
+			}
+			if header.ModTime.Nanosecond() != 0 {
+				t.Errorf("Expected %s to have a whole second mtime; found %s", name, header.ModTime)
+			}
+			if !header.AccessTime.IsZero() || !header.ChangeTime.IsZero() {
+				t.Errorf("Expected %s to have no atime or ctime; found %s and %s", name, header.AccessTime, header.ChangeTime)
+			}
+		}
+	}
+	if !data[longName].ModTime.Equal(modTime.Truncate(time.Second)) {
+		t.Errorf("Expected mtime %s; found %s", modTime.Truncate(time.Second), data[longName].ModTime)
+	}
+
+	if _, err := exec.LookPath("dpkg-deb"); err == nil {
+		dir, err := ioutil.TempDir("", "mkdeb-tar")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		filename := filepath.Join(dir, p.Filename())
+		if err := ioutil.WriteFile(filename, buf.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+		output, err := exec.Command("dpkg-deb", "--contents", filename).CombinedOutput()
+		if err != nil {
+			t.Fatalf("dpkg-deb rejected the package: %s\n%s", err, output)
+		}
+		if !strings.Contains(string(output), " "+longName+"\n") {
+			t.Errorf("Expected dpkg-deb to list %s\n%s", longName, output)
+		}
+	}
+}
+
+func TestTarHeaderLargeFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mkdeb-large")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// A sparse file, so it takes no space on disk. It is larger than the 8GiB
+	// a USTAR header can describe.
+	size := int64(9 << 30)
+	filename := filepath.Join(dir, "deb-pkg", "usr", "share", "mkdeb", "large")
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.Create(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := file.Truncate(size); err != nil {
+		file.Close()
+		t.Skipf("Sparse files are not supported: %s", err)
+	}
+	file.Close()
+
+	p := PackageSpecFixture(t)
+	p.Version = "0.1.0"
+	p.AutoPath = "deb-pkg"
+	p.BaseDir = dir
+
+	payload, err := p.walkPayload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if installedSize, err := p.installedSize(payload); err != nil || installedSize != size/1024 {
+		t.Errorf("Expected Installed-Size of %d; found %d, %v", size/1024, installedSize, err)
+	}
+
+	// Only the header is written, since compressing 9GiB takes too long
+	for _, file := range payload.files {
+		if file.target != "usr/share/mkdeb/large" {
+			continue
+		}
+		header, err := p.tarHeader(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf := &bytes.Buffer{}
+		if err := tar.NewWriter(buf).WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		read, err := tar.NewReader(buf).Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if read.Size != size {
+			t.Errorf("Expected size %d; found %d", size, read.Size)
+		}
+		return
+	}
+	t.Errorf("Expected usr/share/mkdeb/large in the payload")
+}
+
+func TestHardLinks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mkdeb-links")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// A multi-call binary with a hard link for each command, and a copy that
+	// is not a link
+	bin := filepath.Join(dir, "deb-pkg", "usr", "bin")
+	if err := os.MkdirAll(bin, 0755); err != nil {
+		t.Fatal(err)
+	}
+	data := bytes.Repeat([]byte("busybox\n"), 1024)
+	if err := ioutil.WriteFile(filepath.Join(bin, "busybox"), data, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"ls", "sh"} {
+		if err := os.Link(filepath.Join(bin, "busybox"), filepath.Join(bin, name)); err != nil {
+			t.Skipf("Hard links are not supported: %s", err)
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(bin, "copy"), data, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	p := PackageSpecFixture(t)
+	p.Version = "0.1.0"
+	p.AutoPath = "deb-pkg"
+	p.BaseDir = dir
+
+	buf := &bytes.Buffer{}
+	if _, err := p.WriteTo(buf); err != nil {
+		t.Fatal(err)
+	}
+	_, members := readArMembers(t, buf.Bytes())
+	headers := readTarHeaders(t, members["data.tar.gz"])
+
+	// The first file in the archive has the contents
+	if header := headers["usr/bin/busybox"]; header.Typeflag != tar.TypeReg || header.Size != int64(len(data)) {
+		t.Errorf("Expected usr/bin/busybox to be a regular file; found %+v", header)
+	}
+	for _, name := range []string{"usr/bin/ls", "usr/bin/sh"} {
+		header := headers[name]
+		if header.Typeflag != tar.TypeLink || header.Linkname != "usr/bin/busybox" || header.Size != 0 {
+			t.Errorf("Expected %s to be a hard link to usr/bin/busybox; found %+v", name, header)
+		}
+	}
+	if header := headers["usr/bin/copy"]; header.Typeflag != tar.TypeReg {
+		t.Errorf("Expected usr/bin/copy to be a regular file; found %+v", header)
+	}
+
+	// Every link is listed in md5sums, but only counted once in the size
+	md5sums, err := p.CalculateChecksums()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"busybox", "copy", "ls", "sh"} {
+		if !bytes.Contains(md5sums, []byte("  usr/bin/"+name+"\n")) {
+			t.Errorf("Expected usr/bin/%s in md5sums\n%s", name, md5sums)
+		}
+	}
+	if size, err := p.CalculateSize(); err != nil || size != 2*int64(len(data))/1024 {
+		t.Errorf("Expected Installed-Size of %d; found %d, %v", 2*len(data)/1024, size, err)
+	}
+
+	if _, err := exec.LookPath("dpkg-deb"); err == nil {
+		filename := filepath.Join(dir, p.Filename())
+		if err := ioutil.WriteFile(filename, buf.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+		output, err := exec.Command("dpkg-deb", "--contents", filename).CombinedOutput()
+		if err != nil {
+			t.Fatalf("dpkg-deb rejected the package: %s\n%s", err, output)
+		}
+		if !strings.Contains(string(output), "usr/bin/ls link to usr/bin/busybox") {
+			t.Errorf("Expected dpkg-deb to list usr/bin/ls as a link\n%s", output)
+		}
+	}
+}
@@ -0,0 +1,14 @@
+// Source for test-fixtures/package2/usr/bin/hello, a small amd64 ELF binary
+// with debug info and a build id. Rebuild with:
+//
+//	gcc -g -Os -nostdlib -static -no-pie -Wl,--build-id=sha1 -o package2/usr/bin/hello hello.c
+
+static int counter;
+
+int helper(int n) { return n * 2 + counter; }
+
+void _start(void) {
+	counter = helper(21);
+	for (;;) {
+	}
+}
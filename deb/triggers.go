@@ -0,0 +1,112 @@
+package deb
+
+import (
+	"bytes"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// reTriggerName matches explicit trigger names. File triggers are absolute
+// paths instead.
+var reTriggerName = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9.+_:-]*$`)
+
+// Triggers describes the dpkg triggers a package is interested in or
+// activates. These are written to the triggers control file. See deb-triggers(5)
+// for details.
+//
+// Each entry is either an explicit trigger name, e.g. "ldconfig", or an absolute
+// path for a file trigger, which fires when another package installs or
+// removes files under that path.
+//
+// Interest and InterestNoawait list triggers that cause this package's postinst
+// to be called with "triggered". Activate and ActivateNoawait list triggers
+// this package activates when it is installed or removed. The noawait variants
+// do not put the activating package in triggers-awaited state.
+//
+// Triggered is shell code that postinst runs when it is called with
+// "triggered". The names of the activated triggers are available in $2.
+type Triggers struct {
+	Interest        []string `json:"interest,omitempty"`
+	InterestNoawait []string `json:"interestNoawait,omitempty"`
+	Activate        []string `json:"activate,omitempty"`
+	ActivateNoawait []string `json:"activateNoawait,omitempty"`
+	Triggered       string   `json:"triggered,omitempty"`
+}
+
+type triggerDirective struct {
+	directive string
+	names     []string
+}
+
+// directives returns the triggers grouped by their deb-triggers(5) directive,
+// in the order they are written to the triggers file
+func (t *Triggers) directives() []triggerDirective {
+	return []triggerDirective{
+		{"interest", t.Interest},
+		{"interest-noawait", t.InterestNoawait},
+		{"activate", t.Activate},
+		{"activate-noawait", t.ActivateNoawait},
+	}
+}
+
+// validateTriggers checks that trigger names are valid
+func (p *PackageSpec) validateTriggers() error {
+	if p.Triggers == nil {
+		return nil
+	}
+	interested := false
+	for _, d := range p.Triggers.directives() {
+		for _, name := range d.names {
+			if strings.HasPrefix(name, "/") {
+				if path.Clean(name) != name {
+					return fmt.Errorf("File trigger %q is invalid; expected a clean absolute path like %q", name, path.Clean(name))
+				}
+			} else if !reTriggerName.MatchString(name) {
+				return fmt.Errorf("Trigger %q is invalid; expected an absolute path or a name matching %q",
+					name, reTriggerName.String())
+			}
+			if strings.HasPrefix(d.directive, "interest") {
+				interested = true
+			}
+		}
+	}
+	if p.Triggers.Triggered != "" && !interested {
+		return fmt.Errorf("Triggers has a triggered script but no interest; postinst will never be called with \"triggered\"")
+	}
+	return nil
+}
+
+// RenderTriggers returns the contents of the triggers control file, or nil if
+// the package does not use triggers.
+func (p *PackageSpec) RenderTriggers() []byte {
+	if p.Triggers == nil {
+		return nil
+	}
+	buf := &bytes.Buffer{}
+	for _, d := range p.Triggers.directives() {
+		for _, name := range d.names {
+			fmt.Fprintf(buf, "%s %s\n", d.directive, name)
+		}
+	}
+	if buf.Len() == 0 {
+		return nil
+	}
+	return buf.Bytes()
+}
+
+// triggersScripts renders the postinst fragment that runs Triggered
+func (p *PackageSpec) triggersScripts() (map[string][]byte, error) {
+	scripts := map[string][]byte{}
+	if p.Triggers == nil || strings.TrimSpace(p.Triggers.Triggered) == "" {
+		return scripts, nil
+	}
+	buf := &bytes.Buffer{}
+	buf.WriteString("\n# triggers\nif [ \"$1\" = \"triggered\" ]; then\n")
+	// The code is added as-is, since indenting it would change heredocs
+	buf.WriteString(strings.Trim(p.Triggers.Triggered, "\n"))
+	buf.WriteString("\nfi\n")
+	scripts["postinst"] = buf.Bytes()
+	return scripts, nil
+}
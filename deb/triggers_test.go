@@ -0,0 +1,94 @@
+package deb
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateTriggers(t *testing.T) {
+	p := PackageSpecFixture(t, "triggers.json")
+	if err := p.Validate(false); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := map[string]*Triggers{
+		"Trigger \"bad name\"":           {Interest: []string{"bad name"}},
+		"File trigger \"/usr/lib/foo/\"": {Activate: []string{"/usr/lib/foo/"}},
+		"no interest":                    {Activate: []string{"ldconfig"}, Triggered: "true"},
+	}
+	for expected, triggers := range cases {
+		p := PackageSpecFixture(t)
+		p.Triggers = triggers
+		err := p.Validate(false)
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("Expected error containing %q; found %+v", expected, err)
+		}
+	}
+}
+
+func TestRenderTriggers(t *testing.T) {
+	p := PackageSpecFixture(t, "triggers.json")
+
+	expected := `interest package1-reload
+interest-noawait /usr/lib/package1/plugins
+activate-noawait ldconfig
+`
+	if triggers := string(p.RenderTriggers()); triggers != expected {
+		t.Errorf("--Expected--\n%s\n--Found--\n%s\n", expected, triggers)
+	}
+
+	p.Triggers = &Triggers{}
+	if triggers := p.RenderTriggers(); triggers != nil {
+		t.Errorf("Expected no triggers file; found %q", triggers)
+	}
+}
+
+func TestTriggersScripts(t *testing.T) {
+	p := PackageSpecFixture(t, "triggers.json")
+	p.AutoPath = "-"
+
+	scripts, err := p.RenderControlScripts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(scripts) != 1 {
+		t.Fatalf("Expected only postinst; found %d scripts", len(scripts))
+	}
+
+	expected := `
+# triggers
+if [ "$1" = "triggered" ]; then
+package1 --rebuild-index
+fi
+`
+	if postinst := string(scripts["postinst"]); !strings.Contains(postinst, expected) {
+		t.Errorf("--Expected--\n%s\n--Found--\n%s\n", expected, postinst)
+	}
+
+	// The code is not changed, so heredocs still work
+	p.Triggers.Triggered = "cat >/var/lib/package1/index <<EOF\nrebuild\nEOF\n"
+	scripts, err = p.RenderControlScripts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if postinst := string(scripts["postinst"]); !strings.Contains(postinst, "<<EOF\nrebuild\nEOF\nfi\n") {
+		t.Errorf("Expected the triggered code as-is\n%s", postinst)
+	}
+
+	diagnostics, err := p.LintControlScripts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diagnostics) != 0 {
+		t.Errorf("Unexpected diagnostics: %+v", diagnostics)
+	}
+}
+
+func TestCreateControlArchiveTriggers(t *testing.T) {
+	p := PackageSpecFixture(t, "triggers.json")
+
+	files := buildArchiveFiles(t, p.CreateControlArchive)
+	if triggers, ok := files["triggers"]; !ok || string(triggers) != string(p.RenderTriggers()) {
+		t.Errorf("Unexpected triggers file:\n%s", triggers)
+	}
+}
@@ -0,0 +1,224 @@
+package deb
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+// sysusersPath is where sysusers.d configuration is installed
+const sysusersPath = "/usr/lib/sysusers.d"
+
+// maxUserNameLength is the size of the user and group name fields in a tar
+// header
+const maxUserNameLength = 32
+
+var reUserName = regexp.MustCompile(`^[a-z_][a-z0-9_-]*[$]?$`)
+
+// User describes a user account that is created when the package is
+// installed. Users are never removed, since files owned by them may remain on
+// the system after the package is gone.
+//
+// Name is the login name. Home and Shell are optional. System users default to
+// a home of /nonexistent and a shell of /usr/sbin/nologin. Each user gets a
+// primary group with the same name, and is added to any supplementary Groups.
+type User struct {
+	Name   string   `json:"name"`
+	Home   string   `json:"home,omitempty"`
+	Shell  string   `json:"shell,omitempty"`
+	System bool     `json:"system"`
+	Groups []string `json:"groups,omitempty"`
+}
+
+// Group describes a group that is created when the package is installed.
+type Group struct {
+	Name   string `json:"name"`
+	System bool   `json:"system"`
+}
+
+// validateUsers checks the syntax of users, groups, and ownership overrides.
+func (p *PackageSpec) validateUsers() error {
+	for _, group := range p.Groups {
+		if !reUserName.MatchString(group.Name) {
+			return fmt.Errorf("Group %q is invalid; expected a name matching %q", group.Name, reUserName.String())
+		}
+	}
+	for _, user := range p.Users {
+		if !reUserName.MatchString(user.Name) {
+			return fmt.Errorf("User %q is invalid; expected a name matching %q", user.Name, reUserName.String())
+		}
+		for _, group := range user.Groups {
+			if !reUserName.MatchString(group) {
+				return fmt.Errorf("Group %q for user %q is invalid; expected a name matching %q",
+					group, user.Name, reUserName.String())
+			}
+		}
+		if user.Home != "" && !path.IsAbs(user.Home) {
+			return fmt.Errorf("Home %q for user %q must be an absolute path", user.Home, user.Name)
+		}
+		if user.Shell != "" && !path.IsAbs(user.Shell) {
+			return fmt.Errorf("Shell %q for user %q must be an absolute path", user.Shell, user.Name)
+		}
+		if p.Sysusers && !user.System {
+			return fmt.Errorf("User %q must be a system user to be included in sysusers", user.Name)
+		}
+	}
+	for target, owner := range p.Owners {
+		if !path.IsAbs(target) || path.Clean(target) != target {
+			return fmt.Errorf("Owner of %q is invalid; expected a clean absolute path like %q", target, path.Clean("/"+target))
+		}
+		if _, _, err := parseOwner(owner); err != nil {
+			return fmt.Errorf("Owner of %q is invalid: %s", target, err)
+		}
+	}
+	return nil
+}
+
+// parseOwner splits an owner in the form user or user:group. If no group is
+// given the user's primary group is used.
+func parseOwner(owner string) (string, string, error) {
+	parts := strings.SplitN(owner, ":", 2)
+	for _, part := range parts {
+		if !reUserName.MatchString(part) {
+			return "", "", fmt.Errorf("expected something like 'user:group' but found %q", owner)
+		}
+		if len(part) > maxUserNameLength {
+			return "", "", fmt.Errorf("%q in %q is too long; expected at most %d characters", part, owner, maxUserNameLength)
+		}
+	}
+	if len(parts) == 1 {
+		return parts[0], parts[0], nil
+	}
+	return parts[0], parts[1], nil
+}
+
+// writeSysusers writes a sysusers.d config file for the users and groups into
+// the workspace so it can be added to the package.
+func (p *PackageSpec) writeSysusers(workspace string) error {
+	if !p.Sysusers || len(p.Users)+len(p.Groups) == 0 {
+		return nil
+	}
+	buf := &bytes.Buffer{}
+	if err := sysusersTemplate.Execute(buf, p); err != nil {
+		return err
+	}
+	filename := filepath.Join(workspace, "generated", "sysusers.conf")
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filename, buf.Bytes(), 0644); err != nil {
+		return err
+	}
+	p.addGeneratedFile(filename, path.Join(sysusersPath, p.Package+".conf"))
+	return nil
+}
+
+// usersScripts renders the maintainer script fragments that create the users
+// and groups and apply ownership overrides.
+func (p *PackageSpec) usersScripts() (map[string][]byte, error) {
+	scripts := map[string][]byte{}
+	if len(p.Users)+len(p.Groups)+len(p.Owners) == 0 {
+		return scripts, nil
+	}
+
+	// Sort ownership overrides so the script is the same every build
+	targets := []string{}
+	for target := range p.Owners {
+		targets = append(targets, target)
+	}
+	sort.Strings(targets)
+	owners := []map[string]string{}
+	for _, target := range targets {
+		user, group, err := parseOwner(p.Owners[target])
+		if err != nil {
+			return nil, err
+		}
+		owners = append(owners, map[string]string{"Path": target, "User": user, "Group": group})
+	}
+
+	buf := &bytes.Buffer{}
+	err := usersPostinstTemplate.Execute(buf, map[string]interface{}{
+		"Groups": p.Groups,
+		"Users":  p.Users,
+		"Owners": owners,
+	})
+	if err != nil {
+		return nil, err
+	}
+	scripts["postinst"] = buf.Bytes()
+	return scripts, nil
+}
+
+// adduserArgs returns the adduser options used to create the user
+func adduserArgs(user User) string {
+	args := []string{}
+	if user.System {
+		args = append(args, "--system")
+		if user.Home == "" {
+			args = append(args, "--home", "/nonexistent", "--no-create-home")
+		}
+	} else {
+		args = append(args, "--disabled-password", "--gecos", "''")
+	}
+	if user.Home != "" {
+		args = append(args, "--home", shellQuote(user.Home))
+	}
+	if user.Shell != "" {
+		args = append(args, "--shell", shellQuote(user.Shell))
+	}
+	return strings.Join(args, " ")
+}
+
+var usersPostinstTemplate = template.Must(template.New("postinst").Funcs(template.FuncMap{
+	"quote":       shellQuote,
+	"adduserArgs": adduserArgs,
+}).Parse(`
+# users and groups
+if [ "$1" = "configure" ]; then
+{{- range .Groups }}
+	if ! getent group {{ quote .Name }} >/dev/null; then
+		addgroup {{ if .System }}--system {{ end }}{{ quote .Name }} >/dev/null
+	fi
+{{- end }}
+{{- range .Users }}
+	if ! getent passwd {{ quote .Name }} >/dev/null; then
+		if getent group {{ quote .Name }} >/dev/null; then
+			adduser {{ adduserArgs . }} --ingroup {{ quote .Name }} {{ quote .Name }} >/dev/null
+		else
+			adduser {{ adduserArgs . }}{{ if .System }} --group{{ end }} {{ quote .Name }} >/dev/null
+		fi
+	fi
+{{- $user := .Name }}
+{{- range .Groups }}
+	if ! id -nG {{ quote $user }} | tr ' ' '\n' | grep -qx {{ quote . }}; then
+		adduser {{ quote $user }} {{ quote . }} >/dev/null
+	fi
+{{- end }}
+{{- end }}
+{{- range .Owners }}
+	if ! dpkg-statoverride --list {{ quote .Path }} >/dev/null 2>&1; then
+		chown -h {{ quote (printf "%s:%s" .User .Group) }} {{ quote .Path }}
+	fi
+{{- end }}
+fi
+`))
+
+var sysusersTemplate = template.Must(template.New("sysusers").Parse(`# Generated by mkdeb for {{ .Package }}
+{{- range .Groups }}
+g {{ .Name }} -
+{{- end }}
+{{- range .Users }}
+u {{ .Name }} - - {{ if .Home }}{{ .Home }}{{ else }}/nonexistent{{ end }} {{ if .Shell }}{{ .Shell }}{{ else }}/usr/sbin/nologin{{ end }}
+{{- $user := .Name }}
+{{- range .Groups }}
+m {{ $user }} {{ . }}
+{{- end }}
+{{- end }}
+`))
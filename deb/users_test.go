@@ -0,0 +1,163 @@
+package deb
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValidateUsers(t *testing.T) {
+	p := PackageSpecFixture(t, "users.json")
+	if err := p.Validate(false); err != nil {
+		t.Fatal(err)
+	}
+
+	p.Users[0].Name = "Package One"
+	err := p.Validate(false)
+	if err == nil || !strings.Contains(err.Error(), "Package One") {
+		t.Fatalf("Expected invalid user error; found %+v", err)
+	}
+
+	p = PackageSpecFixture(t, "users.json")
+	p.Owners = map[string]string{"etc/package1/config": "package1"}
+	err = p.Validate(false)
+	if err == nil || !strings.Contains(err.Error(), "absolute path") {
+		t.Fatalf("Expected invalid owner path error; found %+v", err)
+	}
+
+	p = PackageSpecFixture(t, "users.json")
+	p.Owners = map[string]string{"/etc/package1/config": "package1:"}
+	err = p.Validate(false)
+	if err == nil || !strings.Contains(err.Error(), "user:group") {
+		t.Fatalf("Expected invalid owner error; found %+v", err)
+	}
+
+	// Names longer than a tar header can hold
+	p = PackageSpecFixture(t, "users.json")
+	p.Owners = map[string]string{"/etc/package1/config": strings.Repeat("u", 33)}
+	err = p.Validate(false)
+	if err == nil || !strings.Contains(err.Error(), "too long") {
+		t.Fatalf("Expected owner too long error; found %+v", err)
+	}
+}
+
+func TestUsersScripts(t *testing.T) {
+	p := PackageSpecFixture(t, "users.json")
+
+	scripts, err := p.usersScripts()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	postinst := string(scripts["postinst"])
+	expected := []string{
+		"addgroup --system 'package1-admin' >/dev/null",
+		"adduser --system --home '/var/lib/package1' --ingroup 'package1' 'package1' >/dev/null",
+		"adduser --system --home '/var/lib/package1' --group 'package1' >/dev/null",
+		"adduser 'package1' 'adm' >/dev/null",
+		"chown -h 'package1:package1' '/etc/package1/config'",
+	}
+	for _, line := range expected {
+		if !strings.Contains(postinst, line) {
+			t.Errorf("Expected postinst to contain %q\n%s", line, postinst)
+		}
+	}
+
+	// Users must be created before services are started
+	p.Systemd = PackageSpecFixture(t, "systemd.json").Systemd
+	rendered, err := p.RenderControlScripts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	postinst = string(rendered["postinst"])
+	if strings.Index(postinst, "adduser") > strings.Index(postinst, "deb-systemd-helper") {
+		t.Errorf("Expected users to be created before systemd units are enabled\n%s", postinst)
+	}
+}
+
+func TestBuildSysusers(t *testing.T) {
+	p := PackageSpecFixture(t, "users.json")
+	p.Sysusers = true
+
+	ws, err := ioutil.TempDir("", "mkdeb-sysusers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(ws)
+
+	if err := p.writeGeneratedFiles(ws); err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := p.ListFiles(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf := filepath.Join(ws, "generated", "sysusers.conf")
+	if !hasString(files, conf) {
+		t.Fatalf("%q is missing: %+v", conf, files)
+	}
+	if target, err := p.NormalizeFilename(conf); err != nil {
+		t.Fatal(err)
+	} else if target != "usr/lib/sysusers.d/mkdeb.conf" {
+		t.Errorf("Expected %q got %q", "usr/lib/sysusers.d/mkdeb.conf", target)
+	}
+
+	data, err := ioutil.ReadFile(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `# Generated by mkdeb for mkdeb
+g package1-admin -
+u package1 - - /var/lib/package1 /usr/sbin/nologin
+m package1 package1-admin
+m package1 adm
+`
+	if string(data) != expected {
+		t.Errorf("--Expected--\n%s\n--Found--\n%s\n", expected, data)
+	}
+}
+
+func TestCreateDataArchiveOwners(t *testing.T) {
+	p := PackageSpecFixture(t, "users.json")
+
+	filename := filepath.Join(os.TempDir(), "test-owners.tar.gz")
+	if err := p.CreateDataArchive(filename); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(filename)
+
+	file, err := os.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	zipreader, err := gzip.NewReader(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	archive := tar.NewReader(zipreader)
+	found := false
+	for {
+		header, err := archive.Next()
+		if err != nil {
+			break
+		}
+		owner := header.Uname + ":" + header.Gname
+		if header.Name == "etc/package1/config" {
+			found = true
+			if owner != "package1:package1" {
+				t.Errorf("Expected %s to be owned by package1:package1, got %s", header.Name, owner)
+			}
+		} else if owner != "root:root" {
+			t.Errorf("Expected %s to be owned by root:root, got %s", header.Name, owner)
+		}
+	}
+	if !found {
+		t.Errorf("etc/package1/config is missing from the data archive")
+	}
+}
@@ -0,0 +1,92 @@
+package deb
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+var (
+	// reVersion matches [epoch:]upstream_version[-debian_revision]. Hyphens are
+	// allowed in the upstream version because the last one starts the revision.
+	reVersion = regexp.MustCompile(`^([0-9]+:)?[0-9][a-zA-Z0-9.+~-]*$`)
+
+	// reTagVersion splits a tag into the version and an optional pre-release
+	// suffix such as -rc1, -beta.2 or -alpha
+	reTagVersion = regexp.MustCompile(`^[vV]?([0-9][a-zA-Z0-9.+~_]*?)(?:[-_.]?((?:alpha|beta|pre|rc|dev|preview)[a-zA-Z0-9.-]*))?$`)
+)
+
+const (
+	versionGit  = "git"
+	versionFile = "file:"
+)
+
+// ResolveVersion returns the package version for a version flag. "git"
+// computes the version from the nearest git tag with GitVersion, and
+// "file:PATH" reads it from a file, relative to dir. Anything else is used as
+// the version itself.
+func ResolveVersion(version, dir string) (string, error) {
+	switch {
+	case version == versionGit:
+		return GitVersion(dir)
+	case strings.HasPrefix(version, versionFile):
+		filename := strings.TrimPrefix(version, versionFile)
+		if !filepath.IsAbs(filename) {
+			filename = filepath.Join(dir, filename)
+		}
+		data, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return "", fmt.Errorf("Failed to read version: %s", err)
+		}
+		version = strings.TrimSpace(string(data))
+		if version == "" {
+			return "", fmt.Errorf("Version file %q is empty", filename)
+		}
+		return version, nil
+	}
+	return version, nil
+}
+
+// GitVersion computes a debian version from the nearest tag in the git
+// repository containing dir, like git describe --tags. A leading "v" is
+// removed, and commits since the tag are appended so the version sorts after
+// the release: v1.2.3 with 4 more commits becomes 1.2.3+4.gabcdef0.
+// Pre-release tags such as v1.3.0-rc1 use ~ so they sort before the release:
+// 1.3.0~rc1.
+func GitVersion(dir string) (string, error) {
+	repo, err := openGitRepo(dir)
+	if err != nil {
+		return "", err
+	}
+	tag, distance, hash, err := repo.describe("HEAD")
+	if err != nil {
+		return "", err
+	}
+	return gitDescribeVersion(tag, distance, hash)
+}
+
+// gitDescribeVersion converts the parts of git describe output into a debian
+// version
+func gitDescribeVersion(tag string, distance int, hash string) (string, error) {
+	name := tag[strings.LastIndex(tag, "/")+1:]
+	matches := reTagVersion.FindStringSubmatch(name)
+	if matches == nil {
+		return "", fmt.Errorf("Tag %q is not a version; expected something like v1.2.3 or 1.2.3-rc1", tag)
+	}
+	version := strings.Replace(matches[1], "_", ".", -1)
+	if matches[2] != "" {
+		version += "~" + strings.Replace(matches[2], "-", ".", -1)
+	}
+	if distance > 0 {
+		if len(hash) > 7 {
+			hash = hash[:7]
+		}
+		version += fmt.Sprintf("+%d.g%s", distance, hash)
+	}
+	if !reVersion.MatchString(version) {
+		return "", fmt.Errorf("Tag %q is not a version; expected something like v1.2.3 or 1.2.3-rc1", tag)
+	}
+	return version, nil
+}
@@ -0,0 +1,180 @@
+package deb
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGitDescribeVersion(t *testing.T) {
+	hash := "abcdef0123456789abcdef0123456789abcdef01"
+	cases := []struct {
+		tag      string
+		distance int
+		expected string
+	}{
+		{"v1.2.3", 0, "1.2.3"},
+		{"1.2.3", 0, "1.2.3"},
+		{"v1.2.3", 4, "1.2.3+4.gabcdef0"},
+		{"v1.3.0-rc1", 0, "1.3.0~rc1"},
+		{"v1.3.0-rc1", 2, "1.3.0~rc1+2.gabcdef0"},
+		{"v2.0.0-beta-2", 0, "2.0.0~beta.2"},
+		{"v2.0.0.alpha", 0, "2.0.0~alpha"},
+		{"release/v1.0", 0, "1.0"},
+		{"1_0_2", 0, "1.0.2"},
+	}
+	for _, c := range cases {
+		version, err := gitDescribeVersion(c.tag, c.distance, hash)
+		if err != nil {
+			t.Errorf("%s: %s", c.tag, err)
+			continue
+		}
+		if version != c.expected {
+			t.Errorf("Expected %s to be %s; found %s", c.tag, c.expected, version)
+		}
+	}
+
+	for _, tag := range []string{"latest", "v1.0-1", "stable-1.0"} {
+		if _, err := gitDescribeVersion(tag, 0, hash); err == nil {
+			t.Errorf("Expected tag %q to be rejected", tag)
+		}
+	}
+}
+
+func TestGitVersion(t *testing.T) {
+	dir, git := GitRepoFixture(t)
+	defer os.RemoveAll(dir)
+
+	check := func(expected string) {
+		version, err := ResolveVersion("git", dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if version != expected {
+			t.Errorf("Expected %s; found %s", expected, version)
+		}
+	}
+
+	check("1.1")
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "README"), []byte("changed"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	git("commit", "-q", "-a", "-m", "Change the docs")
+	hash := git("rev-parse", "--short=7", "HEAD")
+	check("1.1+1.g" + hash)
+
+	git("tag", "-a", "-m", "Release candidate", "v1.2-rc1")
+	check("1.2~rc1")
+
+	// Packed refs and annotated tags
+	git("gc", "-q", "--prune=now")
+	check("1.2~rc1")
+
+	git("checkout", "-q", "v1.0")
+	check("1.0")
+}
+
+func TestGitVersionPrefersReleaseTags(t *testing.T) {
+	dir, git := GitRepoFixture(t)
+	defer os.RemoveAll(dir)
+
+	check := func(expected string) {
+		version, err := GitVersion(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if version != expected {
+			t.Errorf("Expected %s; found %s", expected, version)
+		}
+	}
+
+	// A release candidate that became the release
+	git("tag", "v1.2-rc1")
+	git("tag", "v1.2")
+	check("1.2")
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "README"), []byte("changed"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	git("commit", "-q", "-a", "-m", "Change the docs")
+	hash := git("rev-parse", "--short=7", "HEAD")
+	check("1.2+1.g" + hash)
+
+	// Annotated tags are preferred over lightweight ones, like git describe
+	git("tag", "-a", "-m", "Release candidate", "v1.3-rc1")
+	git("tag", "v1.3")
+	check("1.3~rc1")
+
+	git("tag", "-a", "-m", "Release", "v1.3.0")
+	check("1.3.0")
+}
+
+func TestGitVersionWithoutTags(t *testing.T) {
+	dir, git := GitRepoFixture(t)
+	defer os.RemoveAll(dir)
+	git("tag", "-d", "v1.0", "v1.1")
+
+	if _, err := GitVersion(dir); err == nil || !strings.Contains(err.Error(), "No tags") {
+		t.Errorf("Expected no tags error; found %v", err)
+	}
+}
+
+func TestResolveVersionFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mkdeb-version")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "VERSION"), []byte("2.4.1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	version, err := ResolveVersion("file:VERSION", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != "2.4.1" {
+		t.Errorf("Expected 2.4.1; found %q", version)
+	}
+
+	if _, err := ResolveVersion("file:missing", dir); err == nil {
+		t.Errorf("Expected missing version file to fail")
+	}
+
+	version, err = ResolveVersion("1.2.3", dir)
+	if err != nil || version != "1.2.3" {
+		t.Errorf("Expected 1.2.3; found %q %v", version, err)
+	}
+}
+
+func TestValidateVersion(t *testing.T) {
+	p := PackageSpecFixture(t)
+
+	p.Version = ""
+	if err := p.Validate(true); err == nil || !strings.Contains(err.Error(), "version") {
+		t.Errorf("Expected missing version error; found %v", err)
+	}
+
+	for _, version := range []string{"1.0", "1:2.3.4-1", "1.3.0~rc1+2.gabcdef0"} {
+		p.Version = version
+		if err := p.Validate(false); err != nil {
+			t.Errorf("Expected %s to be valid; found %s", version, err)
+		}
+	}
+
+	for _, version := range []string{"v1.0", "1.0 beta", "1.0_1"} {
+		p.Version = version
+		if err := p.Validate(false); err == nil || !strings.Contains(err.Error(), "Version") {
+			t.Errorf("Expected %s to be invalid; found %v", version, err)
+		}
+	}
+
+	p.Version = "1.0"
+	p.Depends = []string{"libc (>= 1:2.3~rc1+4)"}
+	if err := p.Validate(false); err != nil {
+		t.Errorf("Expected dependency to be valid; found %s", err)
+	}
+}
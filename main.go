@@ -19,6 +19,8 @@ func main() {
 	subcommands.Register(&commands.PackagingCmd{}, "")
 	subcommands.Register(&commands.LicenceCmd{}, "")
 	subcommands.Register(&commands.ValidateCmd{}, "")
+	subcommands.Register(&commands.ChangelogCmd{}, "")
+	subcommands.Register(&commands.CacheCmd{}, "")
 	flagenv.Prefix="deb_"
 	flagenv.Parse()
 	flag.Parse()